@@ -1,12 +1,22 @@
 package main
 
 import (
-	"database/sql"
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
 	"log"
+	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/luizgustavojunqueira/Blogo/internal/auth"
+	"github.com/luizgustavojunqueira/Blogo/internal/handlers"
+	"github.com/luizgustavojunqueira/Blogo/internal/mail"
+	"github.com/luizgustavojunqueira/Blogo/internal/media"
 	"github.com/luizgustavojunqueira/Blogo/internal/repository"
 	"github.com/luizgustavojunqueira/Blogo/pkg/blogo"
 
@@ -14,10 +24,21 @@ import (
 	"github.com/golang-migrate/migrate/v4/database/sqlite3"
 
 	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"golang.org/x/term"
 )
 
 func main() {
-	db, err := sql.Open("sqlite3", os.Getenv("DB_PATH"))
+	// keygen needs no configuration, since it creates the first key
+	if len(os.Args) > 1 && os.Args[1] == "keygen" {
+		key, err := auth.GenerateSecretKey()
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(key)
+		return
+	}
+
+	db, err := repository.OpenSQLite(os.Getenv("DB_PATH"))
 	if err != nil {
 		log.Panic(err)
 	}
@@ -44,30 +65,304 @@ func main() {
 
 	queries := repository.New(db)
 
+	wordsPerMinute, _ := strconv.Atoi(os.Getenv("WORDS_PER_MINUTE"))
+
+	imageWidths, err := parseWidths(os.Getenv("IMAGE_WIDTHS"))
+	if err != nil {
+		log.Panic(err)
+	}
+
+	mediaStorage, err := newMediaStorage()
+	if err != nil {
+		log.Panic(err)
+	}
+
+	authConfig, err := newAuthConfig()
+	if err != nil {
+		log.Panic(err)
+	}
+
+	mailer, err := newMailer()
+	if err != nil {
+		log.Panic(err)
+	}
+
+	security, err := newSecurityConfig()
+	if err != nil {
+		log.Panic(err)
+	}
+
 	location, err := time.LoadLocation("America/Sao_Paulo")
 	if err != nil {
 		log.Panic(err)
 	}
 
 	blog, err := blogo.NewBlogo(&blogo.BlogoConfig{
-		BlogName: "Luiz Gustavo Junqueira",
-		Title:    "Luiz Gustavo",
-		Port:     os.Getenv("SERVER_PORT"),
-		DB:       db,
-		AuthConfig: &auth.AuthConfig{
-			Username:      os.Getenv("USERNAME"),
-			Password:      os.Getenv("PASSWORD"),
-			SecretKey:     os.Getenv("SECRET_KEY"),
-			CookieName:    os.Getenv("COOKIE_NAME"),
-			TokenValidity: 3600,
-		},
-		Logger:   log.New(os.Stdout, "", log.LstdFlags),
-		Location: location,
-		Queries:  queries,
+		BlogName:       "Luiz Gustavo Junqueira",
+		Title:          "Luiz Gustavo",
+		Port:           os.Getenv("SERVER_PORT"),
+		DB:             db,
+		AuthConfig:     authConfig,
+		Logger:         log.New(os.Stdout, "", log.LstdFlags),
+		Location:       location,
+		Queries:        queries,
+		WordsPerMinute: wordsPerMinute,
+		CodeLightTheme: os.Getenv("CODE_LIGHT_THEME"),
+		CodeDarkTheme:  os.Getenv("CODE_DARK_THEME"),
+		MediaStorage:   mediaStorage,
+		MediaDir:       os.Getenv("MEDIA_DIR"),
+		ImageWidths:    imageWidths,
+		ImageCacheDir:  os.Getenv("IMAGE_CACHE_DIR"),
+		TrustedProxies: parseList(os.Getenv("TRUSTED_PROXIES")),
+		BaseURL:        os.Getenv("BASE_URL"),
+		Mailer:         mailer,
+		OIDC:           newOIDCConfig(),
+		Security:       security,
 	})
 	if err != nil {
 		log.Panic(err)
 	}
 
+	if len(os.Args) > 1 {
+		if err := runCommand(blog, os.Args[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	blog.Start()
 }
+
+// runCommand runs a maintenance command instead of starting the server.
+func runCommand(blog *blogo.Blogo, args []string) error {
+	ctx := context.Background()
+
+	switch args[0] {
+	case "readtime":
+		return blog.RecomputeReadTimes(ctx)
+	case "excerpts":
+		return blog.RegenerateExcerpts(ctx)
+	case "rerender":
+		return blog.RerenderPosts(ctx)
+	case "user":
+		return runUserCommand(ctx, blog, args[1:])
+	default:
+		return fmt.Errorf("unknown command %q, available commands: readtime, excerpts, rerender, user, keygen", args[0])
+	}
+}
+
+// runUserCommand manages accounts. "user set-password <username>" asks for
+// the new password twice without echoing it when stdin is a terminal, and
+// otherwise reads it from the first line of stdin, so scripts can pipe it in.
+func runUserCommand(ctx context.Context, blog *blogo.Blogo, args []string) error {
+	if len(args) != 2 || args[0] != "set-password" {
+		return errors.New("usage: user set-password <username>")
+	}
+
+	password, err := readPassword(fmt.Sprintf("New password for %s: ", args[1]))
+	if err != nil {
+		return err
+	}
+
+	return blog.SetPassword(ctx, args[1], password)
+}
+
+// readPassword reads a password from stdin. On a terminal it prompts for it
+// and for a confirmation, which must match.
+func readPassword(prompt string) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return "", err
+		}
+		return strings.TrimRight(password, "\r\n"), nil
+	}
+
+	fmt.Fprint(os.Stderr, prompt)
+	password, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+
+	fmt.Fprint(os.Stderr, "Confirm the password: ")
+	confirmation, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+
+	if string(password) != string(confirmation) {
+		return "", errors.New("the passwords do not match")
+	}
+
+	return string(password), nil
+}
+
+// parseWidths parses a comma separated list of image widths, like "480,960".
+func parseWidths(s string) ([]int, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	widths := make([]int, 0)
+	for _, field := range strings.Split(s, ",") {
+		width, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil {
+			return nil, fmt.Errorf("invalid image width %q", field)
+		}
+		widths = append(widths, width)
+	}
+
+	return widths, nil
+}
+
+// parseList splits a comma separated list, like "10.0.0.1,10.0.0.2".
+func parseList(s string) []string {
+	list := make([]string, 0)
+	for _, field := range strings.Split(s, ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			list = append(list, field)
+		}
+	}
+	return list
+}
+
+// newMediaStorage returns an S3 storage when S3_BUCKET is set. Otherwise it
+// returns nil and uploads are kept in MEDIA_DIR.
+func newMediaStorage() (media.Storage, error) {
+	if os.Getenv("S3_BUCKET") == "" {
+		return nil, nil
+	}
+
+	presignExpiry, _ := time.ParseDuration(os.Getenv("S3_PRESIGN_EXPIRY"))
+
+	return media.NewS3(media.S3Config{
+		Endpoint:      os.Getenv("S3_ENDPOINT"),
+		Region:        os.Getenv("S3_REGION"),
+		Bucket:        os.Getenv("S3_BUCKET"),
+		Prefix:        os.Getenv("S3_PREFIX"),
+		AccessKey:     os.Getenv("S3_ACCESS_KEY"),
+		SecretKey:     os.Getenv("S3_SECRET_KEY"),
+		PublicURL:     os.Getenv("S3_PUBLIC_URL"),
+		PresignExpiry: presignExpiry,
+	})
+}
+
+// newMailer returns an SMTP mailer when SMTP_HOST is set, which enables
+// logging in with emailed links. Otherwise it returns nil. The server must
+// offer TLS unless SMTP_REQUIRE_TLS is false.
+func newMailer() (auth.Mailer, error) {
+	if os.Getenv("SMTP_HOST") == "" {
+		return nil, nil
+	}
+
+	return mail.NewSMTP(mail.SMTPConfig{
+		Host:       os.Getenv("SMTP_HOST"),
+		Port:       os.Getenv("SMTP_PORT"),
+		Username:   os.Getenv("SMTP_USERNAME"),
+		Password:   os.Getenv("SMTP_PASSWORD"),
+		From:       os.Getenv("SMTP_FROM"),
+		RequireTLS: os.Getenv("SMTP_REQUIRE_TLS") != "false",
+	})
+}
+
+// newOIDCConfig returns the OpenID Connect login when OIDC_ISSUER is set.
+// The callback defaults to /login/oidc/callback under BASE_URL.
+func newOIDCConfig() *auth.OIDCConfig {
+	if os.Getenv("OIDC_ISSUER") == "" {
+		return nil
+	}
+
+	redirectURL := os.Getenv("OIDC_REDIRECT_URL")
+	if redirectURL == "" {
+		redirectURL = strings.TrimSuffix(os.Getenv("BASE_URL"), "/") + "/login/oidc/callback"
+	}
+
+	return &auth.OIDCConfig{
+		Issuer:       os.Getenv("OIDC_ISSUER"),
+		ClientID:     os.Getenv("OIDC_CLIENT_ID"),
+		ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectURL:  redirectURL,
+		Scopes:       strings.Fields(os.Getenv("OIDC_SCOPES")),
+		Allowlist:    parseList(os.Getenv("OIDC_ALLOWLIST")),
+		Name:         os.Getenv("OIDC_NAME"),
+	}
+}
+
+// newSecurityConfig reads the security headers. HSTS_MAX_AGE is a duration
+// like 8760h, and Strict-Transport-Security is not sent without it.
+func newSecurityConfig() (handlers.SecurityConfig, error) {
+	var hstsMaxAge time.Duration
+	if s := os.Getenv("HSTS_MAX_AGE"); s != "" {
+		var err error
+		if hstsMaxAge, err = time.ParseDuration(s); err != nil {
+			return handlers.SecurityConfig{}, fmt.Errorf("invalid HSTS_MAX_AGE: %w", err)
+		}
+	}
+
+	return handlers.SecurityConfig{
+		ContentSecurityPolicy: os.Getenv("CONTENT_SECURITY_POLICY"),
+		CSPReportOnly:         os.Getenv("CSP_REPORT_ONLY") == "true",
+		ReferrerPolicy:        os.Getenv("REFERRER_POLICY"),
+		PermissionsPolicy:     os.Getenv("PERMISSIONS_POLICY"),
+		HSTSMaxAge:            hstsMaxAge,
+		HSTSIncludeSubdomains: os.Getenv("HSTS_INCLUDE_SUBDOMAINS") == "true",
+	}, nil
+}
+
+// newAuthConfig reads the login settings. Sessions last an hour unless
+// TOKEN_VALIDITY is set, and are renewed while in use.
+func newAuthConfig() (*auth.AuthConfig, error) {
+	tokenValidity := time.Hour
+	if s := os.Getenv("TOKEN_VALIDITY"); s != "" {
+		var err error
+		if tokenValidity, err = time.ParseDuration(s); err != nil {
+			return nil, fmt.Errorf("invalid TOKEN_VALIDITY: %w", err)
+		}
+	}
+
+	var rememberValidity time.Duration
+	if s := os.Getenv("REMEMBER_VALIDITY"); s != "" {
+		var err error
+		if rememberValidity, err = time.ParseDuration(s); err != nil {
+			return nil, fmt.Errorf("invalid REMEMBER_VALIDITY: %w", err)
+		}
+	}
+
+	sameSite, err := parseSameSite(os.Getenv("COOKIE_SAMESITE"))
+	if err != nil {
+		return nil, err
+	}
+
+	return &auth.AuthConfig{
+		Username:         os.Getenv("USERNAME"),
+		Password:         os.Getenv("PASSWORD"),
+		SecretKeys:       parseList(os.Getenv("SECRET_KEY")),
+		CookieName:       os.Getenv("COOKIE_NAME"),
+		TokenValidity:    int64(tokenValidity.Seconds()),
+		RememberValidity: int64(rememberValidity.Seconds()),
+		CookieSecure:     os.Getenv("COOKIE_SECURE") == "true",
+		CookieSameSite:   sameSite,
+		CookieDomain:     os.Getenv("COOKIE_DOMAIN"),
+		CookiePath:       os.Getenv("COOKIE_PATH"),
+	}, nil
+}
+
+// parseSameSite parses "lax", "strict" or "none". An empty string leaves the
+// default of auth.AuthConfig.
+func parseSameSite(s string) (http.SameSite, error) {
+	switch strings.ToLower(s) {
+	case "":
+		return 0, nil
+	case "lax":
+		return http.SameSiteLaxMode, nil
+	case "strict":
+		return http.SameSiteStrictMode, nil
+	case "none":
+		return http.SameSiteNoneMode, nil
+	default:
+		return 0, fmt.Errorf("invalid COOKIE_SAMESITE %q, use lax, strict or none", s)
+	}
+}
@@ -0,0 +1,206 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ErrAPITokenNotFound is returned by APITokens when no token has the hash or ID.
+var ErrAPITokenNotFound = errors.New("API token not found")
+
+// ErrAPITokensDisabled is returned when AuthConfig has no APITokens.
+var ErrAPITokensDisabled = errors.New("API tokens are disabled")
+
+// ErrInsufficientScope is returned when an API token lacks the scope a request needs.
+var ErrInsufficientScope = errors.New("API token lacks the required scope")
+
+// Scopes of the API tokens. Read allows the safe methods, write creating and
+// editing, and delete the DELETE requests.
+const (
+	ScopeRead   = "read"
+	ScopeWrite  = "write"
+	ScopeDelete = "delete"
+)
+
+// Scopes lists the scopes an API token can have.
+var Scopes = []string{ScopeRead, ScopeWrite, ScopeDelete}
+
+// ValidScope reports whether scope is one of Scopes.
+func ValidScope(scope string) bool {
+	return slices.Contains(Scopes, scope)
+}
+
+// RequiredScope returns the scope an API token needs to make a request with method.
+func RequiredScope(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return ScopeRead
+	case http.MethodDelete:
+		return ScopeDelete
+	default:
+		return ScopeWrite
+	}
+}
+
+// apiTokenPrefix starts every API token, so leaked tokens are easy to find.
+const apiTokenPrefix = "blogo_"
+
+// APIToken is a named token a user created for scripts. Only the hash of the
+// token itself is stored.
+type APIToken struct {
+	ID         int64
+	UserID     int64
+	Name       string
+	Scopes     []string
+	CreatedAt  time.Time
+	LastUsedAt time.Time // Zero when the token was never used
+}
+
+// APITokens stores the API tokens.
+type APITokens interface {
+	CreateAPIToken(ctx context.Context, token APIToken, hash string) (APIToken, error)
+	GetAPITokenByHash(ctx context.Context, hash string) (APIToken, error)
+	TouchAPIToken(ctx context.Context, id int64, lastUsedAt time.Time) error
+	ListAPITokens(ctx context.Context, userID int64) ([]APIToken, error)
+	// DeleteAPIToken deletes the token with the given ID when it belongs to
+	// the user, and returns ErrAPITokenNotFound otherwise.
+	DeleteAPIToken(ctx context.Context, userID, id int64) error
+}
+
+// CreateAPIToken creates a token named name for user with the given scopes.
+// It returns the token, which cannot be shown again since only its hash is stored.
+func (auth *Auth) CreateAPIToken(ctx context.Context, user User, name string, scopes []string) (string, APIToken, error) {
+	if auth.apiTokens == nil {
+		return "", APIToken{}, ErrAPITokensDisabled
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", APIToken{}, fmt.Errorf("the token needs a name")
+	}
+
+	if len(scopes) == 0 {
+		return "", APIToken{}, fmt.Errorf("the token needs at least one scope")
+	}
+
+	for _, scope := range scopes {
+		if !ValidScope(scope) {
+			return "", APIToken{}, fmt.Errorf("invalid scope %q", scope)
+		}
+	}
+
+	token := apiTokenPrefix + rand.Text()
+
+	created, err := auth.apiTokens.CreateAPIToken(ctx, APIToken{
+		UserID:    user.ID,
+		Name:      name,
+		Scopes:    scopes,
+		CreatedAt: time.Now(),
+	}, hashAPIToken(token))
+	if err != nil {
+		return "", APIToken{}, err
+	}
+
+	return token, created, nil
+}
+
+// APITokens returns the API tokens of a user.
+func (auth *Auth) APITokens(ctx context.Context, userID int64) ([]APIToken, error) {
+	if auth.apiTokens == nil {
+		return nil, ErrAPITokensDisabled
+	}
+	return auth.apiTokens.ListAPITokens(ctx, userID)
+}
+
+// RevokeAPIToken deletes an API token of a user.
+func (auth *Auth) RevokeAPIToken(ctx context.Context, userID, id int64) error {
+	if auth.apiTokens == nil {
+		return ErrAPITokensDisabled
+	}
+	return auth.apiTokens.DeleteAPIToken(ctx, userID, id)
+}
+
+// UserFromAPIToken returns the user an API token belongs to, with Scopes set
+// to the scopes of the token, and records when the token was last used.
+func (auth *Auth) UserFromAPIToken(ctx context.Context, token string) (User, error) {
+	if auth.apiTokens == nil {
+		return User{}, ErrAPITokensDisabled
+	}
+
+	if !strings.HasPrefix(token, apiTokenPrefix) {
+		return User{}, ErrAPITokenNotFound
+	}
+
+	apiToken, err := auth.apiTokens.GetAPITokenByHash(ctx, hashAPIToken(token))
+	if err != nil {
+		return User{}, err
+	}
+
+	now := time.Now()
+	if now.Sub(apiToken.LastUsedAt) >= touchInterval {
+		if err := auth.apiTokens.TouchAPIToken(ctx, apiToken.ID, now); err != nil {
+			return User{}, err
+		}
+	}
+
+	user, err := auth.users.GetUserByID(ctx, apiToken.UserID)
+	if err != nil {
+		return User{}, err
+	}
+
+	user.Scopes = apiToken.Scopes
+	return user, nil
+}
+
+// UserFromRequest returns the user making a request, from the bearer token
+// in its Authorization header or else from its session cookie. API tokens
+// must have the scope RequiredScope returns for the method of the request.
+func (auth *Auth) UserFromRequest(r *http.Request) (User, error) {
+	if token, ok := BearerToken(r); ok {
+		user, err := auth.UserFromAPIToken(r.Context(), token)
+		if err != nil {
+			return User{}, err
+		}
+
+		if !user.HasScope(RequiredScope(r.Method)) {
+			return User{}, ErrInsufficientScope
+		}
+
+		return user, nil
+	}
+
+	cookie, err := r.Cookie(auth.cookieName)
+	if err != nil {
+		return User{}, ErrSessionNotFound
+	}
+
+	return auth.UserFromToken(r.Context(), cookie.Value)
+}
+
+// BearerToken returns the token of a request with an "Authorization: Bearer"
+// header. Such requests are not sent by browsers on their own, so they need
+// no CSRF token.
+func BearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+// hashAPIToken hashes a token for storage. Tokens are random, so a fast hash
+// is enough, like for recovery codes.
+func hashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,168 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+type apiTokensMock struct {
+	tokens map[string]APIToken // By hash
+}
+
+func (m *apiTokensMock) CreateAPIToken(ctx context.Context, token APIToken, hash string) (APIToken, error) {
+	token.ID = int64(len(m.tokens) + 1)
+	m.tokens[hash] = token
+	return token, nil
+}
+
+func (m *apiTokensMock) GetAPITokenByHash(ctx context.Context, hash string) (APIToken, error) {
+	token, ok := m.tokens[hash]
+	if !ok {
+		return APIToken{}, ErrAPITokenNotFound
+	}
+	return token, nil
+}
+
+func (m *apiTokensMock) TouchAPIToken(ctx context.Context, id int64, lastUsedAt time.Time) error {
+	for hash, token := range m.tokens {
+		if token.ID == id {
+			token.LastUsedAt = lastUsedAt
+			m.tokens[hash] = token
+		}
+	}
+	return nil
+}
+
+func (m *apiTokensMock) ListAPITokens(ctx context.Context, userID int64) ([]APIToken, error) {
+	var tokens []APIToken
+	for _, token := range m.tokens {
+		if token.UserID == userID {
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens, nil
+}
+
+func (m *apiTokensMock) DeleteAPIToken(ctx context.Context, userID, id int64) error {
+	for hash, token := range m.tokens {
+		if token.ID == id && token.UserID == userID {
+			delete(m.tokens, hash)
+			return nil
+		}
+	}
+	return ErrAPITokenNotFound
+}
+
+func TestAuth_APITokens(t *testing.T) {
+	ctx := context.Background()
+	tokens := &apiTokensMock{tokens: map[string]APIToken{}}
+
+	a, err := NewAuth(AuthConfig{
+		SecretKey:     "thisisaverylongsecretkeythatisatleast32characterslong",
+		TokenValidity: 60,
+		CookieName:    "testcookie",
+		Users:         &usersMock{users: []User{{ID: 1, Username: "writer", Role: RoleAuthor}}},
+		Sessions:      newSessionsMock(),
+		APITokens:     tokens,
+	})
+	if err != nil {
+		t.Fatalf("NewAuth() error = %v", err)
+	}
+
+	writer := User{ID: 1}
+
+	if _, _, err := a.CreateAPIToken(ctx, writer, "ci", []string{"admin"}); err == nil {
+		t.Errorf("CreateAPIToken() accepted an invalid scope")
+	}
+	if _, _, err := a.CreateAPIToken(ctx, writer, " ", []string{ScopeRead}); err == nil {
+		t.Errorf("CreateAPIToken() accepted an empty name")
+	}
+
+	token, created, err := a.CreateAPIToken(ctx, writer, "ci", []string{ScopeRead, ScopeWrite})
+	if err != nil {
+		t.Fatalf("CreateAPIToken() error = %v", err)
+	}
+
+	for hash := range tokens.tokens {
+		if strings.Contains(hash, token) || strings.Contains(token, hash) {
+			t.Errorf("the token is stored instead of its hash")
+		}
+	}
+
+	request := func(method, token string) *http.Request {
+		r := httptest.NewRequest(method, "/post/new", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		return r
+	}
+
+	user, err := a.UserFromRequest(request(http.MethodPost, token))
+	if err != nil || user.Username != "writer" || !slices.Equal(user.Scopes, []string{ScopeRead, ScopeWrite}) {
+		t.Fatalf("UserFromRequest(POST) = %v, %v, want writer with read and write", user, err)
+	}
+	if !user.ViaAPIToken() || user.HasScope(ScopeDelete) {
+		t.Errorf("user = %v, want an API token user without the delete scope", user)
+	}
+
+	if _, err := a.UserFromRequest(request(http.MethodDelete, token)); !errors.Is(err, ErrInsufficientScope) {
+		t.Errorf("UserFromRequest(DELETE) error = %v, want %v", err, ErrInsufficientScope)
+	}
+
+	if _, err := a.UserFromRequest(request(http.MethodGet, "blogo_WRONG")); !errors.Is(err, ErrAPITokenNotFound) {
+		t.Errorf("UserFromRequest() with a wrong token error = %v, want %v", err, ErrAPITokenNotFound)
+	}
+
+	listed, err := a.APITokens(ctx, writer.ID)
+	if err != nil || len(listed) != 1 || listed[0].LastUsedAt.IsZero() {
+		t.Errorf("APITokens() = %v, %v, want the token with its last use", listed, err)
+	}
+
+	if err := a.RevokeAPIToken(ctx, 2, created.ID); !errors.Is(err, ErrAPITokenNotFound) {
+		t.Errorf("RevokeAPIToken() of another user error = %v, want %v", err, ErrAPITokenNotFound)
+	}
+	if err := a.RevokeAPIToken(ctx, writer.ID, created.ID); err != nil {
+		t.Fatalf("RevokeAPIToken() error = %v", err)
+	}
+	if _, err := a.UserFromRequest(request(http.MethodGet, token)); err == nil {
+		t.Errorf("UserFromRequest() accepted a revoked token")
+	}
+}
+
+func TestAuth_UserFromRequest_Session(t *testing.T) {
+	ctx := context.Background()
+
+	a, err := NewAuth(AuthConfig{
+		SecretKey:     "thisisaverylongsecretkeythatisatleast32characterslong",
+		TokenValidity: 60,
+		CookieName:    "testcookie",
+		Users:         &usersMock{users: []User{{ID: 1, Username: "writer", Role: RoleAuthor}}},
+		Sessions:      newSessionsMock(),
+	})
+	if err != nil {
+		t.Fatalf("NewAuth() error = %v", err)
+	}
+
+	token, expiry, err := a.CreateSession(ctx, User{ID: 1}, false, "192.0.2.1", "test agent")
+	if err != nil {
+		t.Fatalf("CreateSession() error = %v", err)
+	}
+
+	r := httptest.NewRequest(http.MethodDelete, "/post/delete/hello", nil)
+	r.AddCookie(a.SessionCookie(token, expiry))
+
+	user, err := a.UserFromRequest(r)
+	if err != nil || user.Username != "writer" || user.ViaAPIToken() || !user.HasScope(ScopeDelete) {
+		t.Errorf("UserFromRequest() = %v, %v, want writer with every scope", user, err)
+	}
+
+	// A bearer token is used instead of the cookie, even when it is wrong
+	r.Header.Set("Authorization", "Bearer blogo_WRONG")
+	if _, err := a.UserFromRequest(r); !errors.Is(err, ErrAPITokensDisabled) {
+		t.Errorf("UserFromRequest() error = %v, want %v", err, ErrAPITokensDisabled)
+	}
+}
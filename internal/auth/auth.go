@@ -1,35 +1,159 @@
 package auth
 
 import (
-	"crypto/hmac"
-	"crypto/sha256"
-	"encoding/hex"
+	"context"
+	"errors"
 	"fmt"
+	"net/http"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrUserNotFound is returned by Users when no account has the username.
+var ErrUserNotFound = errors.New("user not found")
+
+// ErrInvalidCredentials is returned when the username or password is wrong.
+var ErrInvalidCredentials = errors.New("invalid username or password")
+
+// Roles of the user accounts. Authors write and manage their own posts,
+// editors manage every post and admins also manage the users and settings.
+const (
+	RoleAdmin  = "admin"
+	RoleEditor = "editor"
+	RoleAuthor = "author"
 )
 
+// Roles lists the roles from the most to the least privileged.
+var Roles = []string{RoleAdmin, RoleEditor, RoleAuthor}
+
+// ValidRole reports whether role is one of Roles.
+func ValidRole(role string) bool {
+	return slices.Contains(Roles, role)
+}
+
+type User struct {
+	ID           int64
+	Username     string
+	PasswordHash string
+	Role         string
+	Email        string   // Address for email login, empty when not set
+	Scopes       []string // Scopes of the API token the user authenticated with, nil for sessions
+}
+
+// HasScope reports whether the user may make requests needing scope. Users
+// signed in with a session have every scope.
+func (u User) HasScope(scope string) bool {
+	return u.Scopes == nil || slices.Contains(u.Scopes, scope)
+}
+
+// ViaAPIToken reports whether the user authenticated with an API token
+// rather than a session.
+func (u User) ViaAPIToken() bool {
+	return u.Scopes != nil
+}
+
+// CanEditPost reports whether the user may edit and delete a post written by
+// the user with ID authorID, or by no one when authorID is zero.
+func (u User) CanEditPost(authorID int64) bool {
+	switch u.Role {
+	case RoleAdmin, RoleEditor:
+		return true
+	case RoleAuthor:
+		return authorID != 0 && authorID == u.ID
+	default:
+		return false
+	}
+}
+
+// CanManageContent reports whether the user may act on the content of every
+// author, like deleting media or checking the links of all posts.
+func (u User) CanManageContent() bool {
+	return u.Role == RoleAdmin || u.Role == RoleEditor
+}
+
+// CanManageUsers reports whether the user may manage accounts and settings.
+func (u User) CanManageUsers() bool {
+	return u.Role == RoleAdmin
+}
+
+// CanWriteRawHTML reports whether the raw HTML in the user's posts is
+// rendered. Only admins, who can already do anything on the blog, may run
+// scripts in the pages of other users.
+func (u User) CanWriteRawHTML() bool {
+	return u.Role == RoleAdmin
+}
+
+type userKey struct{}
+
+// WithUser returns a copy of ctx carrying the user making the request.
+func WithUser(ctx context.Context, user User) context.Context {
+	return context.WithValue(ctx, userKey{}, user)
+}
+
+// UserFromContext returns the user stored by WithUser. The boolean is false
+// for anonymous requests.
+func UserFromContext(ctx context.Context) (User, bool) {
+	user, ok := ctx.Value(userKey{}).(User)
+	return user, ok
+}
+
+// Users stores the user accounts.
+type Users interface {
+	GetUserByUsername(ctx context.Context, username string) (User, error)
+	GetUserByID(ctx context.Context, id int64) (User, error)
+	// GetUserByEmail returns the user with the address, as normalized by NormalizeEmail.
+	GetUserByEmail(ctx context.Context, email string) (User, error)
+	CountUsers(ctx context.Context) (int64, error)
+	CreateUser(ctx context.Context, username, passwordHash, role string) (User, error)
+	UpdatePassword(ctx context.Context, userID int64, passwordHash string) error
+}
+
 type Auth struct {
-	username      string
-	password      string
-	secretKey     string
-	tokenValidity int64
-	cookieName    string
+	username         string
+	password         string
+	keys             []signingKey
+	tokenValidity    int64
+	rememberValidity int64
+	cookieName       string
+	cookieSecure     bool
+	cookieSameSite   http.SameSite
+	cookieDomain     string
+	cookiePath       string
+	users            Users
+	sessions         Sessions
+	apiTokens        APITokens
 }
 
 type AuthConfig struct {
-	Username      string // Username for authentication, at least 4 characters
-	Password      string // Password for authentication, at least 8 characters
-	SecretKey     string // Secret key for token generation, at least 32 characters
-	TokenValidity int64  // Token validity in seconds, at least 60 seconds
-	CookieName    string // Name of the cookie, at least 8 characters
+	Username      string    // Username of the first admin, created when there are no users yet, at least 4 characters
+	Password      string    // Password of the first admin, at least 8 characters
+	SecretKey     string    // Secret key for token generation, at least 32 characters, used when SecretKeys is empty
+	SecretKeys    []string  // Secret keys for rotation, the first signs new tokens and all of them verify, at least 32 characters each
+	TokenValidity int64     // Token validity in seconds, at least 60 seconds
+	CookieName    string    // Name of the cookie, at least 8 characters
+	Users         Users     // Lookup of the user accounts, required
+	Sessions      Sessions  // Storage of the signed in sessions, required
+	APITokens     APITokens // Storage of the API tokens, bearer authentication is disabled when nil
+
+	RememberValidity int64         // Token validity in seconds when logging in with "remember me", at least TokenValidity, defaults to TokenValidity
+	CookieSecure     bool          // Only send the cookies over HTTPS
+	CookieSameSite   http.SameSite // SameSite attribute of the session cookie, defaults to Lax
+	CookieDomain     string        // Domain of the session cookie, defaults to the host of the request
+	CookiePath       string        // Path of the session cookie, defaults to "/"
 }
 
 // NewAuth creates a new Auth instance from the provided configuration.
 // It returns an error if the configuration is invalid.
 func NewAuth(config AuthConfig) (*Auth, error) {
-	if config.Username == "" || config.Password == "" || config.SecretKey == "" || config.CookieName == "" || config.TokenValidity == 0 {
+	if len(config.SecretKeys) == 0 && config.SecretKey != "" {
+		config.SecretKeys = []string{config.SecretKey}
+	}
+
+	if len(config.SecretKeys) == 0 || config.CookieName == "" || config.TokenValidity == 0 || config.Users == nil || config.Sessions == nil {
 		return nil, fmt.Errorf("invalid parameters")
 	}
 
@@ -37,8 +161,19 @@ func NewAuth(config AuthConfig) (*Auth, error) {
 		return nil, fmt.Errorf("token validity must be at least 60 seconds")
 	}
 
-	if len(config.SecretKey) < 32 {
-		return nil, fmt.Errorf("secret key must be at least 32 characters")
+	keys := make([]signingKey, 0, len(config.SecretKeys))
+	for _, secret := range config.SecretKeys {
+		if len(secret) < 32 {
+			return nil, fmt.Errorf("secret key must be at least 32 characters")
+		}
+
+		key := newSigningKey(secret)
+		for _, other := range keys {
+			if other.id == key.id {
+				return nil, fmt.Errorf("secret keys must be different")
+			}
+		}
+		keys = append(keys, key)
 	}
 
 	if len(config.CookieName) < 8 {
@@ -49,77 +184,205 @@ func NewAuth(config AuthConfig) (*Auth, error) {
 		return nil, fmt.Errorf("cookie name cannot contain ':'")
 	}
 
-	if strings.Contains(config.Username, ":") {
-		return nil, fmt.Errorf("username cannot contain ':'")
+	if config.RememberValidity == 0 {
+		config.RememberValidity = config.TokenValidity
+	}
+
+	if config.RememberValidity < config.TokenValidity {
+		return nil, fmt.Errorf("remember validity must be at least the token validity")
 	}
 
-	if config.Username == config.Password {
-		return nil, fmt.Errorf("username and password must be different")
+	if config.CookieSameSite == 0 {
+		config.CookieSameSite = http.SameSiteLaxMode
 	}
 
-	if len(config.Password) < 8 {
-		return nil, fmt.Errorf("password must be at least 8 characters")
+	if config.CookieSameSite == http.SameSiteNoneMode && !config.CookieSecure {
+		return nil, fmt.Errorf("SameSite=None cookies must be secure")
 	}
 
-	if len(config.Username) < 4 {
-		return nil, fmt.Errorf("username must be at least 4 characters")
+	if config.CookiePath == "" {
+		config.CookiePath = "/"
+	}
+
+	if config.Username != "" || config.Password != "" {
+		if err := ValidateUser(config.Username, config.Password); err != nil {
+			return nil, err
+		}
 	}
 
 	return &Auth{
-		username:      config.Username,
-		password:      config.Password,
-		secretKey:     config.SecretKey,
-		tokenValidity: config.TokenValidity,
-		cookieName:    config.CookieName,
+		username:         config.Username,
+		password:         config.Password,
+		keys:             keys,
+		tokenValidity:    config.TokenValidity,
+		rememberValidity: config.RememberValidity,
+		cookieName:       config.CookieName,
+		cookieSecure:     config.CookieSecure,
+		cookieSameSite:   config.CookieSameSite,
+		cookieDomain:     config.CookieDomain,
+		cookiePath:       config.CookiePath,
+		users:            config.Users,
+		sessions:         config.Sessions,
+		apiTokens:        config.APITokens,
 	}, nil
 }
 
-func (auth *Auth) GenerateToken(username string, expiry int64) string {
-	data := fmt.Sprintf("%s:%d", username, expiry)
-	h := hmac.New(sha256.New, []byte(auth.secretKey))
-	h.Write([]byte(data))
-	signature := hex.EncodeToString(h.Sum(nil))
-	token := fmt.Sprintf("%s:%s", data, signature)
-	return token
+// ValidateUser checks the username and password of a new account.
+func ValidateUser(username, password string) error {
+	if strings.Contains(username, ":") {
+		return fmt.Errorf("username cannot contain ':'")
+	}
+
+	if username == password {
+		return fmt.Errorf("username and password must be different")
+	}
+
+	if len(password) < 8 {
+		return fmt.Errorf("password must be at least 8 characters")
+	}
+
+	if len(username) < 4 {
+		return fmt.Errorf("username must be at least 4 characters")
+	}
+
+	return nil
 }
 
-func (auth *Auth) ValidateToken(token string) (bool, error) {
-	if token == "" {
-		return false, fmt.Errorf("empty Token")
+// HashPassword hashes a password with bcrypt for storage.
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
 	}
+	return string(hash), nil
+}
+
+// CreateInitialAdmin creates the account configured with Username and
+// Password when there are no users yet, so existing deployments keep their
+// login. It does nothing once any user exists.
+func (auth *Auth) CreateInitialAdmin(ctx context.Context) (bool, error) {
+	if auth.username == "" {
+		return false, nil
+	}
+
+	count, err := auth.users.CountUsers(ctx)
+	if err != nil || count > 0 {
+		return false, err
+	}
+
+	hash, err := HashPassword(auth.password)
+	if err != nil {
+		return false, err
+	}
+
+	if _, err := auth.users.CreateUser(ctx, auth.username, hash, RoleAdmin); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// GenerateChallenge returns a token proving that the user with ID userID
+// entered their password, to exchange for a session token with a second
+// factor. It is signed differently, so it cannot be used as a session token.
+// remember is whether the session should use the "remember me" validity.
+func (auth *Auth) GenerateChallenge(userID int64, remember bool, expiry int64) string {
+	if remember {
+		return auth.generateToken(rememberChallengePurpose, userID, expiry)
+	}
+	return auth.generateToken(challengePurpose, userID, expiry)
+}
+
+// ParseChallenge validates a token from GenerateChallenge and returns the ID
+// of the user it was issued to and whether they asked to be remembered.
+func (auth *Auth) ParseChallenge(token string) (int64, bool, error) {
+	if userID, err := auth.parseToken(challengePurpose, token); err == nil {
+		return userID, false, nil
+	}
+
+	userID, err := auth.parseToken(rememberChallengePurpose, token)
+	if err != nil {
+		return 0, false, err
+	}
+
+	return userID, true, nil
+}
 
-	parts := strings.Split(token, ":")
-	if len(parts) != 3 {
-		return false, fmt.Errorf("invalid Token")
+const (
+	challengePurpose         = "2fa:"
+	rememberChallengePurpose = "2fa:remember:"
+)
+
+// generateToken returns a token carrying "userID:expiry", sealed for purpose.
+func (auth *Auth) generateToken(purpose string, userID int64, expiry int64) string {
+	return auth.sealToken(purpose, fmt.Sprintf("%d:%d", userID, expiry))
+}
+
+func (auth *Auth) parseToken(purpose, token string) (int64, error) {
+	data, err := auth.openToken(purpose, token)
+	if err != nil {
+		return 0, err
+	}
+
+	userIDStr, expiryStr, ok := strings.Cut(data, ":")
+	if !ok {
+		return 0, fmt.Errorf("invalid Token")
+	}
+
+	userID, err := strconv.ParseInt(userIDStr, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid user")
 	}
-	username := parts[0]
-	expiryStr := parts[1]
-	signatureProvided := parts[2]
 
 	expiry, err := strconv.ParseInt(expiryStr, 10, 64)
 	if err != nil {
-		return false, fmt.Errorf("invalid expiry")
+		return 0, fmt.Errorf("invalid expiry")
 	}
 
 	if time.Now().Unix() > expiry {
-		return false, fmt.Errorf("expired Token")
+		return 0, fmt.Errorf("expired Token")
 	}
 
-	// Recalcula a assinatura esperada
-	data := fmt.Sprintf("%s:%s", username, expiryStr)
-	h := hmac.New(sha256.New, []byte(auth.secretKey))
-	h.Write([]byte(data))
-	expectedSignature := hex.EncodeToString(h.Sum(nil))
+	return userID, nil
+}
 
-	if !hmac.Equal([]byte(signatureProvided), []byte(expectedSignature)) {
-		return false, fmt.Errorf("Invalid Signature")
+// UserFromChallenge validates a challenge token and returns the user it was
+// issued to and whether they asked to be remembered.
+func (auth *Auth) UserFromChallenge(ctx context.Context, token string) (User, bool, error) {
+	userID, remember, err := auth.ParseChallenge(token)
+	if err != nil {
+		return User{}, false, err
 	}
 
-	return true, nil
+	user, err := auth.users.GetUserByID(ctx, userID)
+	if err != nil {
+		return User{}, false, err
+	}
+
+	return user, remember, nil
 }
 
-func (auth *Auth) ValidateCredentials(username, password string) bool {
-	return username == auth.username && password == auth.password
+// dummyHash is compared against when the username does not exist, so the
+// response time does not tell which usernames are taken.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+
+// ValidateCredentials returns the user with the given username and password.
+// It returns ErrInvalidCredentials if either is wrong.
+func (auth *Auth) ValidateCredentials(ctx context.Context, username, password string) (User, error) {
+	user, err := auth.users.GetUserByUsername(ctx, username)
+	if errors.Is(err, ErrUserNotFound) {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return User{}, ErrInvalidCredentials
+	}
+	if err != nil {
+		return User{}, err
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
+		return User{}, ErrInvalidCredentials
+	}
+
+	return user, nil
 }
 
 func (auth *Auth) GetCookieName() string {
@@ -1,13 +1,146 @@
 package auth
 
 import (
+	"context"
+	"errors"
+	"net/http"
 	"reflect"
-	"strings"
 	"testing"
 	"time"
 )
 
+type usersMock struct {
+	users []User
+}
+
+func (m *usersMock) GetUserByUsername(ctx context.Context, username string) (User, error) {
+	for _, user := range m.users {
+		if user.Username == username {
+			return user, nil
+		}
+	}
+	return User{}, ErrUserNotFound
+}
+
+func (m *usersMock) GetUserByID(ctx context.Context, id int64) (User, error) {
+	for _, user := range m.users {
+		if user.ID == id {
+			return user, nil
+		}
+	}
+	return User{}, ErrUserNotFound
+}
+
+func (m *usersMock) GetUserByEmail(ctx context.Context, email string) (User, error) {
+	for _, user := range m.users {
+		if user.Email != "" && user.Email == email {
+			return user, nil
+		}
+	}
+	return User{}, ErrUserNotFound
+}
+
+func (m *usersMock) CountUsers(ctx context.Context) (int64, error) {
+	return int64(len(m.users)), nil
+}
+
+func (m *usersMock) CreateUser(ctx context.Context, username, passwordHash, role string) (User, error) {
+	user := User{ID: int64(len(m.users) + 1), Username: username, PasswordHash: passwordHash, Role: role}
+	m.users = append(m.users, user)
+	return user, nil
+}
+
+func (m *usersMock) UpdatePassword(ctx context.Context, userID int64, passwordHash string) error {
+	for i, user := range m.users {
+		if user.ID == userID {
+			m.users[i].PasswordHash = passwordHash
+			return nil
+		}
+	}
+	return ErrUserNotFound
+}
+
+type sessionsMock struct {
+	sessions map[string]Session
+}
+
+func newSessionsMock() *sessionsMock {
+	return &sessionsMock{sessions: map[string]Session{}}
+}
+
+func (m *sessionsMock) CreateSession(ctx context.Context, session Session) error {
+	m.sessions[session.ID] = session
+	return nil
+}
+
+func (m *sessionsMock) GetSession(ctx context.Context, id string) (Session, error) {
+	session, ok := m.sessions[id]
+	if !ok {
+		return Session{}, ErrSessionNotFound
+	}
+	return session, nil
+}
+
+func (m *sessionsMock) TouchSession(ctx context.Context, id string, lastSeenAt time.Time) error {
+	session := m.sessions[id]
+	session.LastSeenAt = lastSeenAt
+	m.sessions[id] = session
+	return nil
+}
+
+func (m *sessionsMock) RenewSession(ctx context.Context, id string, expiresAt time.Time) error {
+	session := m.sessions[id]
+	session.ExpiresAt = expiresAt
+	m.sessions[id] = session
+	return nil
+}
+
+func (m *sessionsMock) ListSessions(ctx context.Context, now time.Time) ([]Session, error) {
+	var sessions []Session
+	for _, session := range m.sessions {
+		if session.ExpiresAt.After(now) {
+			sessions = append(sessions, session)
+		}
+	}
+	return sessions, nil
+}
+
+func (m *sessionsMock) DeleteSession(ctx context.Context, id string) error {
+	delete(m.sessions, id)
+	return nil
+}
+
+func (m *sessionsMock) DeleteUserSessions(ctx context.Context, userID int64) error {
+	for id, session := range m.sessions {
+		if session.UserID == userID {
+			delete(m.sessions, id)
+		}
+	}
+	return nil
+}
+
+func (m *sessionsMock) DeleteUserSessionsExcept(ctx context.Context, userID int64, keepID string) error {
+	for id, session := range m.sessions {
+		if session.UserID == userID && id != keepID {
+			delete(m.sessions, id)
+		}
+	}
+	return nil
+}
+
+func (m *sessionsMock) DeleteExpiredSessions(ctx context.Context, now time.Time) error {
+	for id, session := range m.sessions {
+		if !session.ExpiresAt.After(now) {
+			delete(m.sessions, id)
+		}
+	}
+	return nil
+}
+
 func TestNewAuth(t *testing.T) {
+	users := &usersMock{}
+	sessions := newSessionsMock()
+
 	tests := []struct {
 		name    string
 		args    AuthConfig
@@ -22,13 +155,20 @@ func TestNewAuth(t *testing.T) {
 				SecretKey:     "thisisaverylongsecretkeythatisatleast32characterslong",
 				CookieName:    "testcookie",
 				TokenValidity: 60,
+				Users:         users,
+				Sessions:      sessions,
 			},
 			want: &Auth{
-				username:      "test",
-				password:      "testtest",
-				secretKey:     "thisisaverylongsecretkeythatisatleast32characterslong",
-				cookieName:    "testcookie",
-				tokenValidity: 60,
+				username:         "test",
+				password:         "testtest",
+				keys:             []signingKey{newSigningKey("thisisaverylongsecretkeythatisatleast32characterslong")},
+				cookieName:       "testcookie",
+				tokenValidity:    60,
+				rememberValidity: 60,
+				cookieSameSite:   http.SameSiteLaxMode,
+				cookiePath:       "/",
+				users:            users,
+				sessions:         sessions,
 			},
 			wantErr: false,
 		},
@@ -40,6 +180,8 @@ func TestNewAuth(t *testing.T) {
 				SecretKey:     "thisisaverysmallsecretkey",
 				CookieName:    "testcookie",
 				TokenValidity: 60,
+				Users:         users,
+				Sessions:      sessions,
 			},
 			want:    nil,
 			wantErr: true,
@@ -53,6 +195,8 @@ func TestNewAuth(t *testing.T) {
 				SecretKey:     "thisisaverylongsecretkeythatisatleast32characterslong",
 				CookieName:    "testcookie",
 				TokenValidity: 60,
+				Users:         users,
+				Sessions:      sessions,
 			},
 			want:    nil,
 			wantErr: true,
@@ -65,6 +209,8 @@ func TestNewAuth(t *testing.T) {
 				SecretKey:     "thisisaverylongsecretkeythatisatleast32characterslong",
 				CookieName:    "testcookie",
 				TokenValidity: 60,
+				Users:         users,
+				Sessions:      sessions,
 			},
 			want:    nil,
 			wantErr: true,
@@ -77,6 +223,8 @@ func TestNewAuth(t *testing.T) {
 				SecretKey:     "thisisaverylongsecretkeythatisatleast32characterslong",
 				CookieName:    "testcookie",
 				TokenValidity: 10,
+				Users:         users,
+				Sessions:      sessions,
 			},
 			want:    nil,
 			wantErr: true,
@@ -89,6 +237,8 @@ func TestNewAuth(t *testing.T) {
 				SecretKey:     "thisisaverylongsecretkeythatisatleast32characterslong",
 				CookieName:    "test",
 				TokenValidity: 60,
+				Users:         users,
+				Sessions:      sessions,
 			},
 			want:    nil,
 			wantErr: true,
@@ -101,6 +251,8 @@ func TestNewAuth(t *testing.T) {
 				SecretKey:     "thisisaverylongsecretkeythatisatleast32characterslong",
 				CookieName:    "test:cookie",
 				TokenValidity: 60,
+				Users:         users,
+				Sessions:      sessions,
 			},
 			want:    nil,
 			wantErr: true,
@@ -113,6 +265,93 @@ func TestNewAuth(t *testing.T) {
 				SecretKey:     "thisisaverylongsecretkeythatisatleast32characterslong",
 				CookieName:    "testcookie",
 				TokenValidity: 60,
+				Users:         users,
+				Sessions:      sessions,
+			},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name: "Without initial admin",
+			args: AuthConfig{
+				SecretKey:     "thisisaverylongsecretkeythatisatleast32characterslong",
+				CookieName:    "testcookie",
+				TokenValidity: 60,
+				Users:         users,
+				Sessions:      sessions,
+			},
+			want: &Auth{
+				keys:             []signingKey{newSigningKey("thisisaverylongsecretkeythatisatleast32characterslong")},
+				cookieName:       "testcookie",
+				tokenValidity:    60,
+				rememberValidity: 60,
+				cookieSameSite:   http.SameSiteLaxMode,
+				cookiePath:       "/",
+				users:            users,
+				sessions:         sessions,
+			},
+			wantErr: false,
+		},
+		{
+			name: "Cookie options",
+			args: AuthConfig{
+				SecretKey:        "thisisaverylongsecretkeythatisatleast32characterslong",
+				CookieName:       "testcookie",
+				TokenValidity:    60,
+				RememberValidity: 3600,
+				CookieSecure:     true,
+				CookieSameSite:   http.SameSiteStrictMode,
+				CookieDomain:     "example.com",
+				CookiePath:       "/blog",
+				Users:            users,
+				Sessions:         sessions,
+			},
+			want: &Auth{
+				keys:             []signingKey{newSigningKey("thisisaverylongsecretkeythatisatleast32characterslong")},
+				cookieName:       "testcookie",
+				tokenValidity:    60,
+				rememberValidity: 3600,
+				cookieSecure:     true,
+				cookieSameSite:   http.SameSiteStrictMode,
+				cookieDomain:     "example.com",
+				cookiePath:       "/blog",
+				users:            users,
+				sessions:         sessions,
+			},
+			wantErr: false,
+		},
+		{
+			name: "Remember validity shorter than the token validity",
+			args: AuthConfig{
+				SecretKey:        "thisisaverylongsecretkeythatisatleast32characterslong",
+				CookieName:       "testcookie",
+				TokenValidity:    3600,
+				RememberValidity: 60,
+				Users:            users,
+				Sessions:         sessions,
+			},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name: "SameSite None without Secure",
+			args: AuthConfig{
+				SecretKey:      "thisisaverylongsecretkeythatisatleast32characterslong",
+				CookieName:     "testcookie",
+				TokenValidity:  60,
+				CookieSameSite: http.SameSiteNoneMode,
+				Users:          users,
+				Sessions:       sessions,
+			},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name: "Without users",
+			args: AuthConfig{
+				SecretKey:     "thisisaverylongsecretkeythatisatleast32characterslong",
+				CookieName:    "testcookie",
+				TokenValidity: 60,
 			},
 			want:    nil,
 			wantErr: true,
@@ -125,6 +364,8 @@ func TestNewAuth(t *testing.T) {
 				SecretKey:     "thisisaverylongsecretkeythatisatleast32characterslong",
 				CookieName:    "testcookie",
 				TokenValidity: 60,
+				Users:         users,
+				Sessions:      sessions,
 			},
 			want:    nil,
 			wantErr: true,
@@ -144,71 +385,303 @@ func TestNewAuth(t *testing.T) {
 	}
 }
 
-func TestAuth_GenerateAndValidateToken(t *testing.T) {
-	secret := "thisisaverylongsecretkeythatisatleast32characterslong"
+func TestAuth_Sessions(t *testing.T) {
+	ctx := context.Background()
+	sessions := newSessionsMock()
+
+	a, err := NewAuth(AuthConfig{
+		SecretKey:     "thisisaverylongsecretkeythatisatleast32characterslong",
+		TokenValidity: 60,
+		CookieName:    "testcookie",
+		Users:         &usersMock{users: []User{{ID: 1, Username: "first"}, {ID: 42, Username: "second"}}},
+		Sessions:      sessions,
+	})
+	if err != nil {
+		t.Fatalf("NewAuth() error = %v", err)
+	}
+
+	token, expiry, err := a.CreateSession(ctx, User{ID: 42}, false, "192.0.2.1", "test agent")
+	if err != nil {
+		t.Fatalf("CreateSession() error = %v", err)
+	}
+	if until := time.Until(expiry); until <= 0 || until > time.Minute {
+		t.Errorf("CreateSession() expiry in %v, want within a minute", until)
+	}
+
+	if valid, err := a.ValidateToken(ctx, token); err != nil || !valid {
+		t.Errorf("ValidateToken() = %v, %v, want true", valid, err)
+	}
+
+	user, err := a.UserFromToken(ctx, token)
+	if err != nil || user.Username != "second" {
+		t.Errorf("UserFromToken() = %v, %v, want second", user, err)
+	}
 
-	type fields struct {
-		Username      string
-		Password      string
-		SecretKey     string
-		TokenValidity int64
-		CookieName    string
+	sessionID, _ := a.ParseToken(token)
+	if session := sessions.sessions[sessionID]; session.IP != "192.0.2.1" || session.UserAgent != "test agent" {
+		t.Errorf("stored session = %+v, want its IP and user agent", session)
 	}
 
+	if valid, _ := a.ValidateToken(ctx, "other"+token); valid {
+		t.Errorf("ValidateToken() accepted a token with a changed session ID")
+	}
+
+	other, _, err := a.CreateSession(ctx, User{ID: 1}, false, "192.0.2.2", "test agent")
+	if err != nil {
+		t.Fatalf("CreateSession() error = %v", err)
+	}
+
+	if err := a.RevokeToken(ctx, token); err != nil {
+		t.Fatalf("RevokeToken() error = %v", err)
+	}
+	if valid, err := a.ValidateToken(ctx, token); valid || !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("ValidateToken() = %v, %v after revoking, want %v", valid, err, ErrSessionNotFound)
+	}
+
+	if valid, _ := a.ValidateToken(ctx, other); !valid {
+		t.Errorf("ValidateToken() rejected the session of another user")
+	}
+
+	if err := a.RevokeUserSessions(ctx, 1); err != nil {
+		t.Fatalf("RevokeUserSessions() error = %v", err)
+	}
+	if valid, _ := a.ValidateToken(ctx, other); valid {
+		t.Errorf("ValidateToken() accepted a session after signing out everywhere")
+	}
+}
+
+func TestAuth_SessionExpiry(t *testing.T) {
+	ctx := context.Background()
+	sessions := newSessionsMock()
+
+	a, err := NewAuth(AuthConfig{
+		SecretKey:     "thisisaverylongsecretkeythatisatleast32characterslong",
+		TokenValidity: 60,
+		CookieName:    "testcookie",
+		Users:         &usersMock{},
+		Sessions:      sessions,
+	})
+	if err != nil {
+		t.Fatalf("NewAuth() error = %v", err)
+	}
+
+	sessions.sessions["EXPIRED"] = Session{ID: "EXPIRED", UserID: 1, ExpiresAt: time.Now().Add(-time.Second)}
+	sessions.sessions["STALE"] = Session{ID: "STALE", UserID: 1, LastSeenAt: time.Now().Add(-time.Hour), ExpiresAt: time.Now().Add(time.Minute)}
+
+	if valid, _ := a.ValidateToken(ctx, a.sessionToken("EXPIRED")); valid {
+		t.Errorf("ValidateToken() accepted an expired session")
+	}
+
+	if valid, err := a.ValidateToken(ctx, a.sessionToken("STALE")); err != nil || !valid {
+		t.Fatalf("ValidateToken() = %v, %v, want true", valid, err)
+	}
+	if seen := time.Since(sessions.sessions["STALE"].LastSeenAt); seen > time.Second {
+		t.Errorf("last seen %v ago, want it updated", seen)
+	}
+
+	active, err := a.ActiveSessions(ctx)
+	if err != nil || len(active) != 1 || active[0].ID != "STALE" {
+		t.Errorf("ActiveSessions() = %v, %v, want only STALE", active, err)
+	}
+
+	if _, _, err := a.CreateSession(ctx, User{ID: 2}, false, "192.0.2.1", "test agent"); err != nil {
+		t.Fatalf("CreateSession() error = %v", err)
+	}
+	if _, ok := sessions.sessions["EXPIRED"]; ok {
+		t.Errorf("CreateSession() did not delete the expired sessions")
+	}
+}
+
+func TestAuth_RenewSession(t *testing.T) {
+	ctx := context.Background()
+	sessions := newSessionsMock()
+
+	a, err := NewAuth(AuthConfig{
+		SecretKey:        "thisisaverylongsecretkeythatisatleast32characterslong",
+		TokenValidity:    3600,
+		RememberValidity: 30 * 24 * 3600,
+		CookieName:       "testcookie",
+		Users:            &usersMock{},
+		Sessions:         sessions,
+	})
+	if err != nil {
+		t.Fatalf("NewAuth() error = %v", err)
+	}
+
+	_, expiry, err := a.CreateSession(ctx, User{ID: 1}, true, "192.0.2.1", "test agent")
+	if err != nil {
+		t.Fatalf("CreateSession() error = %v", err)
+	}
+	if until := time.Until(expiry); until < 29*24*time.Hour {
+		t.Errorf("CreateSession() with remember expires in %v, want 30 days", until)
+	}
+
+	now := time.Now()
+	sessions.sessions["FRESH"] = Session{ID: "FRESH", UserID: 1, LastSeenAt: now, ExpiresAt: now.Add(40 * time.Minute)}
+	sessions.sessions["OLD"] = Session{ID: "OLD", UserID: 1, LastSeenAt: now, ExpiresAt: now.Add(20 * time.Minute)}
+	sessions.sessions["REMEMBERED"] = Session{ID: "REMEMBERED", UserID: 1, LastSeenAt: now, ExpiresAt: now.Add(10 * 24 * time.Hour), Remember: true}
+
 	tests := []struct {
-		fields fields
-		name   string
+		id          string
+		wantRenewed bool
+		wantExpiry  time.Duration
 	}{
-		{
-			fields: fields{
-				Username:      "test",
-				Password:      "testtest",
-				SecretKey:     secret,
-				TokenValidity: 60,
-				CookieName:    "testcookie",
-			},
-			name: "Test case 1",
-		},
+		{id: "FRESH", wantRenewed: false, wantExpiry: 40 * time.Minute},
+		{id: "OLD", wantRenewed: true, wantExpiry: time.Hour},
+		{id: "REMEMBERED", wantRenewed: true, wantExpiry: 30 * 24 * time.Hour},
+	}
 
-		{
-			fields: fields{
-				Username:      "teste123",
-				Password:      "testtest",
-				SecretKey:     secret,
-				TokenValidity: 60,
-				CookieName:    "testcookie",
-			},
-			name: "Test case 2",
-		},
+	for _, tt := range tests {
+		expiry, renewed, err := a.RenewSession(ctx, a.sessionToken(tt.id))
+		if err != nil || renewed != tt.wantRenewed {
+			t.Errorf("RenewSession(%s) = %v, %v, want %v", tt.id, renewed, err, tt.wantRenewed)
+			continue
+		}
+
+		if diff := time.Until(expiry) - tt.wantExpiry; diff > time.Second || diff < -time.Second {
+			t.Errorf("RenewSession(%s) expires in %v, want %v", tt.id, time.Until(expiry), tt.wantExpiry)
+		}
+		if !sessions.sessions[tt.id].ExpiresAt.Equal(expiry) {
+			t.Errorf("RenewSession(%s) did not store the expiry", tt.id)
+		}
+	}
+}
+
+func TestAuth_SessionCookie(t *testing.T) {
+	a, err := NewAuth(AuthConfig{
+		SecretKey:      "thisisaverylongsecretkeythatisatleast32characterslong",
+		TokenValidity:  60,
+		CookieName:     "testcookie",
+		CookieSecure:   true,
+		CookieSameSite: http.SameSiteStrictMode,
+		CookieDomain:   "example.com",
+		Users:          &usersMock{},
+		Sessions:       newSessionsMock(),
+	})
+	if err != nil {
+		t.Fatalf("NewAuth() error = %v", err)
+	}
+
+	expiry := time.Now().Add(time.Minute)
+
+	cookie := a.SessionCookie("token", expiry)
+	if cookie.Name != "testcookie" || cookie.Value != "token" || !cookie.Expires.Equal(expiry) {
+		t.Errorf("SessionCookie() = %v, want the token until the expiry", cookie)
+	}
+	if !cookie.Secure || !cookie.HttpOnly || cookie.SameSite != http.SameSiteStrictMode || cookie.Domain != "example.com" || cookie.Path != "/" {
+		t.Errorf("SessionCookie() = %v, want the configured options", cookie)
+	}
+
+	if expired := a.ExpiredSessionCookie(); expired.MaxAge >= 0 || expired.Domain != "example.com" || expired.Path != "/" {
+		t.Errorf("ExpiredSessionCookie() = %v, want a deleting cookie with the same domain and path", expired)
+	}
+}
+
+func TestAuth_ValidateCredentials(t *testing.T) {
+	ctx := context.Background()
+
+	a, err := NewAuth(AuthConfig{
+		Username:      "admin",
+		Password:      "adminpassword",
+		SecretKey:     "thisisaverylongsecretkeythatisatleast32characterslong",
+		TokenValidity: 60,
+		CookieName:    "testcookie",
+		Users:         &usersMock{},
+		Sessions:      newSessionsMock(),
+	})
+	if err != nil {
+		t.Fatalf("NewAuth() error = %v", err)
+	}
+
+	created, err := a.CreateInitialAdmin(ctx)
+	if err != nil || !created {
+		t.Fatalf("CreateInitialAdmin() = %v, %v, want true", created, err)
+	}
+
+	if created, _ := a.CreateInitialAdmin(ctx); created {
+		t.Errorf("CreateInitialAdmin() created a second admin")
+	}
+
+	user, err := a.ValidateCredentials(ctx, "admin", "adminpassword")
+	if err != nil || user.ID != 1 || user.Role != RoleAdmin {
+		t.Errorf("ValidateCredentials() = %+v, %v, want the admin", user, err)
+	}
+
+	if user.PasswordHash == "adminpassword" {
+		t.Errorf("CreateInitialAdmin() stored the password in plain text")
+	}
+
+	if _, err := a.ValidateCredentials(ctx, "admin", "wrongpassword"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("ValidateCredentials() with a wrong password error = %v, want %v", err, ErrInvalidCredentials)
+	}
+
+	if _, err := a.ValidateCredentials(ctx, "nobody", "adminpassword"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("ValidateCredentials() with a wrong username error = %v, want %v", err, ErrInvalidCredentials)
+	}
+}
+
+func TestUser_CanEditPost(t *testing.T) {
+	tests := []struct {
+		user     User
+		authorID int64
+		want     bool
+	}{
+		{user: User{ID: 1, Role: RoleAdmin}, authorID: 2, want: true},
+		{user: User{ID: 1, Role: RoleEditor}, authorID: 2, want: true},
+		{user: User{ID: 1, Role: RoleEditor}, authorID: 0, want: true},
+		{user: User{ID: 1, Role: RoleAuthor}, authorID: 1, want: true},
+		{user: User{ID: 1, Role: RoleAuthor}, authorID: 2, want: false},
+		{user: User{ID: 1, Role: RoleAuthor}, authorID: 0, want: false},
+		{user: User{ID: 1, Role: "unknown"}, authorID: 1, want: false},
 	}
 
 	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			a, _ := NewAuth(AuthConfig{
-				Username:      tt.fields.Username,
-				Password:      tt.fields.Password,
-				SecretKey:     tt.fields.SecretKey,
-				TokenValidity: tt.fields.TokenValidity,
-				CookieName:    tt.fields.CookieName,
-			})
-
-			token := a.GenerateToken(tt.fields.Username, time.Now().Unix()+tt.fields.TokenValidity)
-
-			valid, err := a.ValidateToken(token)
-			if err != nil {
-				t.Errorf("ValidateToken() error = %v", err)
-				return
-			}
+		if got := tt.user.CanEditPost(tt.authorID); got != tt.want {
+			t.Errorf("%s.CanEditPost(%d) = %v, want %v", tt.user.Role, tt.authorID, got, tt.want)
+		}
+	}
 
-			if !valid {
-				t.Errorf("ValidateToken() = %v, want %v", valid, true)
-			}
+	if (User{Role: RoleEditor}).CanManageUsers() || !(User{Role: RoleAdmin}).CanManageUsers() {
+		t.Errorf("CanManageUsers() should only allow admins")
+	}
 
-			username := strings.Split(token, ":")[0]
+	if (User{Role: RoleAuthor}).CanManageContent() || !(User{Role: RoleEditor}).CanManageContent() || !(User{Role: RoleAdmin}).CanManageContent() {
+		t.Errorf("CanManageContent() should only allow editors and admins")
+	}
 
-			if username != tt.fields.Username {
-				t.Errorf("ValidateToken() = %v, want %v", username, tt.fields.Username)
-			}
-		})
+	if (User{Role: RoleEditor}).CanWriteRawHTML() || !(User{Role: RoleAdmin}).CanWriteRawHTML() {
+		t.Errorf("CanWriteRawHTML() should only allow admins")
+	}
+}
+
+func TestAuth_CSRFToken(t *testing.T) {
+	a, err := NewAuth(AuthConfig{
+		SecretKey:     "thisisaverylongsecretkeythatisatleast32characterslong",
+		TokenValidity: 60,
+		CookieName:    "testcookie",
+		Users:         &usersMock{},
+		Sessions:      newSessionsMock(),
+	})
+	if err != nil {
+		t.Fatalf("NewAuth() error = %v", err)
+	}
+
+	token := a.CSRFToken("session")
+
+	if !a.ValidateCSRFToken("session", token) {
+		t.Errorf("ValidateCSRFToken() rejected the token of its session")
+	}
+
+	if a.ValidateCSRFToken("other session", token) {
+		t.Errorf("ValidateCSRFToken() accepted the token of another session")
+	}
+
+	if a.ValidateCSRFToken("session", "") || a.ValidateCSRFToken("", a.CSRFToken("")) {
+		t.Errorf("ValidateCSRFToken() accepted an empty token or session")
+	}
+
+	ctx := WithCSRFToken(context.Background(), token)
+	if got := CSRFTokenFromContext(ctx); got != token {
+		t.Errorf("CSRFTokenFromContext() = %q, want %q", got, token)
 	}
 }
@@ -0,0 +1,36 @@
+package auth
+
+import "context"
+
+// CSRFHeader is the request header carrying the CSRF token.
+const CSRFHeader = "X-CSRF-Token"
+
+// CSRFToken returns the CSRF token of a session. The session is the value of
+// the auth cookie, or of an anonymous cookie before logging in, so a token
+// stops working once its session ends.
+func (auth *Auth) CSRFToken(session string) string {
+	return auth.sign("csrf:" + session)
+}
+
+// ValidateCSRFToken reports whether token is the CSRF token of session.
+func (auth *Auth) ValidateCSRFToken(session, token string) bool {
+	if session == "" || token == "" {
+		return false
+	}
+
+	return auth.verify("csrf:"+session, token)
+}
+
+type csrfTokenKey struct{}
+
+// WithCSRFToken returns a copy of ctx carrying the CSRF token of the request,
+// for the templates to embed in the page.
+func WithCSRFToken(ctx context.Context, token string) context.Context {
+	return context.WithValue(ctx, csrfTokenKey{}, token)
+}
+
+// CSRFTokenFromContext returns the CSRF token stored by WithCSRFToken.
+func CSRFTokenFromContext(ctx context.Context) string {
+	token, _ := ctx.Value(csrfTokenKey{}).(string)
+	return token
+}
@@ -0,0 +1,298 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"strings"
+	"time"
+)
+
+// jwksRefreshInterval limits how often the keys of the provider are fetched
+// again for an unknown key ID, so forged tokens cannot flood the provider.
+const jwksRefreshInterval = time.Minute
+
+// jwks is the cached key set of the provider, by key ID.
+type jwks struct {
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+}
+
+// jsonWebKey is an RSA or P-256 public key of a JWK set.
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		if n.BitLen() < 2048 {
+			return nil, errors.New("RSA key shorter than 2048 bits")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !elliptic.P256().IsOnCurve(x, y) {
+			return nil, errors.New("EC point not on the curve")
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, errors.New("invalid key parameter")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// signingKey returns the key of the provider with the ID kid, fetching the
+// key set again when the provider may have rotated its keys. Tokens without
+// a key ID are accepted when the set has a single key.
+func (o *OIDC) signingKey(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	provider, err := o.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	o.mu.Lock()
+	cached := o.keys
+	o.mu.Unlock()
+
+	if key, ok := cached.lookup(kid); ok {
+		return key, nil
+	}
+
+	if cached != nil && o.now().Sub(cached.fetched) < jwksRefreshInterval {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	// The keys are fetched without holding the lock, so a slow provider does
+	// not hold up the logins checked with the cached keys
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := o.getJSON(ctx, provider.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("fetching the provider keys failed: %w", err)
+	}
+
+	keys := &jwks{keys: make(map[string]crypto.PublicKey), fetched: o.now()}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		// Keys of unsupported types are skipped, the provider may publish
+		// keys for other clients
+		key, err := k.publicKey()
+		if err != nil {
+			continue
+		}
+		keys.keys[k.Kid] = key
+	}
+
+	o.mu.Lock()
+	o.keys = keys
+	o.mu.Unlock()
+
+	if key, ok := keys.lookup(kid); ok {
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+func (s *jwks) lookup(kid string) (crypto.PublicKey, bool) {
+	if s == nil {
+		return nil, false
+	}
+
+	if kid == "" && len(s.keys) == 1 {
+		for _, key := range s.keys {
+			return key, true
+		}
+	}
+
+	key, ok := s.keys[kid]
+	return key, ok
+}
+
+// idTokenClaims are the claims of an ID token the login checks or uses.
+type idTokenClaims struct {
+	Issuer        string    `json:"iss"`
+	Subject       string    `json:"sub"`
+	Audience      audience  `json:"aud"`
+	AuthorizedBy  string    `json:"azp"`
+	Expiry        int64     `json:"exp"`
+	IssuedAt      int64     `json:"iat"`
+	Nonce         string    `json:"nonce"`
+	Email         string    `json:"email"`
+	EmailVerified claimBool `json:"email_verified"`
+}
+
+// audience is the "aud" claim, a string or an array of strings.
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return errors.New("invalid audience")
+	}
+	*a = multiple
+	return nil
+}
+
+// claimBool is a boolean claim, which some providers send as a string.
+type claimBool bool
+
+func (b *claimBool) UnmarshalJSON(data []byte) error {
+	switch string(data) {
+	case "true", `"true"`:
+		*b = true
+	default:
+		*b = false
+	}
+	return nil
+}
+
+// verifyIDToken checks the signature and claims of a compact JWS ID token
+// issued for the login with nonce, and returns its claims.
+func (o *OIDC) verifyIDToken(ctx context.Context, token, nonce string) (idTokenClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return idTokenClaims{}, errors.New("malformed ID token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return idTokenClaims{}, fmt.Errorf("invalid ID token header: %w", err)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return idTokenClaims{}, errors.New("invalid ID token signature")
+	}
+
+	// Only asymmetric algorithms are accepted, never "none" or HMAC with
+	// the public key as secret
+	if header.Alg != "RS256" && header.Alg != "ES256" {
+		return idTokenClaims{}, fmt.Errorf("unsupported ID token algorithm %q", header.Alg)
+	}
+
+	key, err := o.signingKey(ctx, header.Kid)
+	if err != nil {
+		return idTokenClaims{}, err
+	}
+
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if header.Alg != "RS256" || rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) != nil {
+			return idTokenClaims{}, errors.New("invalid ID token signature")
+		}
+	case *ecdsa.PublicKey:
+		if header.Alg != "ES256" || len(signature) != 64 {
+			return idTokenClaims{}, errors.New("invalid ID token signature")
+		}
+		r, s := new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(key, digest[:], r, s) {
+			return idTokenClaims{}, errors.New("invalid ID token signature")
+		}
+	default:
+		return idTokenClaims{}, errors.New("invalid ID token signature")
+	}
+
+	var claims idTokenClaims
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return idTokenClaims{}, fmt.Errorf("invalid ID token claims: %w", err)
+	}
+
+	if strings.TrimSuffix(claims.Issuer, "/") != o.issuer {
+		return idTokenClaims{}, fmt.Errorf("ID token issued by %q", claims.Issuer)
+	}
+
+	if !slices.Contains(claims.Audience, o.clientID) {
+		return idTokenClaims{}, errors.New("ID token issued for another client")
+	}
+
+	if len(claims.Audience) > 1 && claims.AuthorizedBy != o.clientID {
+		return idTokenClaims{}, errors.New("ID token authorized for another client")
+	}
+
+	now := o.now()
+	if claims.Expiry == 0 || now.Add(-oidcClockSkew).Unix() >= claims.Expiry {
+		return idTokenClaims{}, errors.New("expired ID token")
+	}
+
+	if claims.IssuedAt > now.Add(oidcClockSkew).Unix() {
+		return idTokenClaims{}, errors.New("ID token issued in the future")
+	}
+
+	if nonce == "" || claims.Nonce != nonce {
+		return idTokenClaims{}, errors.New("ID token issued for another login")
+	}
+
+	if claims.Subject == "" {
+		return idTokenClaims{}, errors.New("ID token without subject")
+	}
+
+	return claims, nil
+}
+
+func decodeJWTPart(part string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
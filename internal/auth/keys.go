@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// tokenVersion starts every signed token, so the format can change without
+// misreading older tokens.
+const tokenVersion = "v1"
+
+// signingKey is a secret key for signing tokens and the ID that tokens carry
+// to tell which key signed them.
+type signingKey struct {
+	id     string
+	secret []byte
+}
+
+// newSigningKey derives the ID of a key from a hash of it, so the ID does not
+// need to be configured and does not reveal the key.
+func newSigningKey(secret string) signingKey {
+	sum := sha256.Sum256([]byte("blogo key id:" + secret))
+	return signingKey{id: hex.EncodeToString(sum[:4]), secret: []byte(secret)}
+}
+
+func (k signingKey) sign(data string) string {
+	h := hmac.New(sha256.New, k.secret)
+	h.Write([]byte(data))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// GenerateSecretKey returns a random key of 256 bits to use in SecretKeys.
+func GenerateSecretKey() (string, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(key), nil
+}
+
+// sign returns the signature of data with the current key.
+func (auth *Auth) sign(data string) string {
+	return auth.keys[0].sign(data)
+}
+
+// verify reports whether signature is the signature of data with any of the keys.
+func (auth *Auth) verify(data, signature string) bool {
+	for _, key := range auth.keys {
+		if hmac.Equal([]byte(signature), []byte(key.sign(data))) {
+			return true
+		}
+	}
+	return false
+}
+
+// sealToken returns "v1:keyID:data:signature", signed with the current key.
+// The signature covers the purpose, so tokens issued for one purpose are
+// rejected for the others.
+func (auth *Auth) sealToken(purpose, data string) string {
+	key := auth.keys[0]
+	signed := tokenVersion + ":" + key.id + ":" + data
+	return signed + ":" + key.sign(purpose+signed)
+}
+
+// openToken checks a token from sealToken against the key it names and
+// returns its data. Tokens signed with any of the keys are accepted.
+func (auth *Auth) openToken(purpose, token string) (string, error) {
+	if token == "" {
+		return "", fmt.Errorf("empty Token")
+	}
+
+	version, rest, _ := strings.Cut(token, ":")
+	if version != tokenVersion {
+		return "", fmt.Errorf("unsupported token version")
+	}
+
+	keyID, rest, _ := strings.Cut(rest, ":")
+	i := strings.LastIndex(rest, ":")
+	if i <= 0 {
+		return "", fmt.Errorf("invalid Token")
+	}
+	data, signature := rest[:i], rest[i+1:]
+
+	for _, key := range auth.keys {
+		if key.id != keyID {
+			continue
+		}
+
+		signed := tokenVersion + ":" + key.id + ":" + data
+		if !hmac.Equal([]byte(signature), []byte(key.sign(purpose+signed))) {
+			return "", fmt.Errorf("Invalid Signature")
+		}
+
+		return data, nil
+	}
+
+	return "", fmt.Errorf("unknown signing key")
+}
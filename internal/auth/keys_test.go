@@ -0,0 +1,140 @@
+package auth
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	oldKey = "thisisaverylongsecretkeythatisatleast32characterslong"
+	newKey = "anotherverylongsecretkeythatisatleast32characterslong"
+)
+
+func newKeyAuth(t *testing.T, sessions *sessionsMock, keys ...string) *Auth {
+	t.Helper()
+
+	a, err := NewAuth(AuthConfig{
+		SecretKeys:    keys,
+		TokenValidity: 60,
+		CookieName:    "testcookie",
+		Users:         &usersMock{users: []User{{ID: 1, Username: "writer"}}},
+		Sessions:      sessions,
+	})
+	if err != nil {
+		t.Fatalf("NewAuth() error = %v", err)
+	}
+	return a
+}
+
+func TestAuth_KeyRotation(t *testing.T) {
+	ctx := context.Background()
+	sessions := newSessionsMock()
+
+	before := newKeyAuth(t, sessions, oldKey)
+
+	token, _, err := before.CreateSession(ctx, User{ID: 1}, false, "192.0.2.1", "test agent")
+	if err != nil {
+		t.Fatalf("CreateSession() error = %v", err)
+	}
+	challenge := before.GenerateChallenge(1, false, time.Now().Add(time.Minute).Unix())
+	csrf := before.CSRFToken(token)
+
+	if !strings.HasPrefix(token, "v1:"+newSigningKey(oldKey).id+":") {
+		t.Errorf("token = %q, want the version and the key ID first", token)
+	}
+
+	// The new key signs, the old one still verifies
+	rotated := newKeyAuth(t, sessions, newKey, oldKey)
+
+	if valid, err := rotated.ValidateToken(ctx, token); err != nil || !valid {
+		t.Errorf("ValidateToken() of a token signed with the old key = %v, %v, want true", valid, err)
+	}
+	if _, _, err := rotated.ParseChallenge(challenge); err != nil {
+		t.Errorf("ParseChallenge() of a challenge signed with the old key error = %v", err)
+	}
+	if !rotated.ValidateCSRFToken(token, csrf) {
+		t.Errorf("ValidateCSRFToken() rejected a token signed with the old key")
+	}
+
+	newToken, _, err := rotated.CreateSession(ctx, User{ID: 1}, false, "192.0.2.1", "test agent")
+	if err != nil {
+		t.Fatalf("CreateSession() error = %v", err)
+	}
+	if !strings.HasPrefix(newToken, "v1:"+newSigningKey(newKey).id+":") {
+		t.Errorf("token = %q, want it signed with the new key", newToken)
+	}
+
+	// Once the old key is removed, its tokens stop working
+	retired := newKeyAuth(t, sessions, newKey)
+
+	if valid, _ := retired.ValidateToken(ctx, token); valid {
+		t.Errorf("ValidateToken() accepted a token signed with a removed key")
+	}
+	if valid, err := retired.ValidateToken(ctx, newToken); err != nil || !valid {
+		t.Errorf("ValidateToken() = %v, %v, want true", valid, err)
+	}
+	if retired.ValidateCSRFToken(token, csrf) {
+		t.Errorf("ValidateCSRFToken() accepted a token signed with a removed key")
+	}
+}
+
+func TestAuth_OpenToken(t *testing.T) {
+	a := newKeyAuth(t, newSessionsMock(), newKey, oldKey)
+
+	token := a.sealToken("test:", "1:2")
+	if data, err := a.openToken("test:", token); err != nil || data != "1:2" {
+		t.Errorf("openToken() = %q, %v, want 1:2", data, err)
+	}
+
+	keyID := newSigningKey(newKey).id
+	otherID := newSigningKey(oldKey).id
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{name: "other purpose", token: a.sealToken("other:", "1:2")},
+		{name: "changed data", token: strings.Replace(token, ":1:2:", ":7:2:", 1)},
+		{name: "other key ID", token: strings.Replace(token, keyID, otherID, 1)},
+		{name: "unknown key ID", token: strings.Replace(token, keyID, "00000000", 1)},
+		{name: "unknown version", token: "v0" + strings.TrimPrefix(token, "v1")},
+		{name: "without version", token: strings.TrimPrefix(token, "v1:")},
+		{name: "empty", token: ""},
+	}
+
+	for _, tt := range tests {
+		if _, err := a.openToken("test:", tt.token); err == nil {
+			t.Errorf("openToken() accepted a token with %s", tt.name)
+		}
+	}
+}
+
+func TestNewAuth_SecretKeys(t *testing.T) {
+	config := AuthConfig{
+		SecretKeys:    []string{newKey, newKey},
+		TokenValidity: 60,
+		CookieName:    "testcookie",
+		Users:         &usersMock{},
+		Sessions:      newSessionsMock(),
+	}
+	if _, err := NewAuth(config); err == nil {
+		t.Errorf("NewAuth() accepted the same key twice")
+	}
+
+	config.SecretKeys = []string{newKey, "short"}
+	if _, err := NewAuth(config); err == nil {
+		t.Errorf("NewAuth() accepted a short previous key")
+	}
+
+	key, err := GenerateSecretKey()
+	if err != nil || len(key) < 32 {
+		t.Fatalf("GenerateSecretKey() = %q, %v, want at least 32 characters", key, err)
+	}
+
+	config.SecretKeys = []string{key, newKey}
+	if _, err := NewAuth(config); err != nil {
+		t.Errorf("NewAuth() with a generated key error = %v", err)
+	}
+}
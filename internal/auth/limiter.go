@@ -0,0 +1,222 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrNoAttempt is returned by an AttemptStore when a key has no failed attempts.
+var ErrNoAttempt = errors.New("no login attempts")
+
+// LoginAttempt records the login attempts of an IP address or username that
+// did not succeed, including those still being checked.
+type LoginAttempt struct {
+	Failures      int64
+	LastFailureAt time.Time
+	LockedUntil   time.Time
+}
+
+// AttemptStore persists the login attempts, so lockouts survive restarts.
+// AddAttempt, RemoveAttempt and Lock must be atomic, since concurrent logins
+// would otherwise overwrite each other's counts.
+type AttemptStore interface {
+	GetAttempt(ctx context.Context, key string) (LoginAttempt, error)
+	// AddAttempt counts an attempt of key made at now and returns the updated
+	// record. Attempts start over when the last was before resetBefore.
+	AddAttempt(ctx context.Context, key string, now, resetBefore time.Time) (LoginAttempt, error)
+	// RemoveAttempt takes back an attempt of key.
+	RemoveAttempt(ctx context.Context, key string) error
+	// Lock locks key until the given time unless it is still locked at now,
+	// and reports whether it did.
+	Lock(ctx context.Context, key string, now, until time.Time) (bool, error)
+	DeleteAttempt(ctx context.Context, key string) error
+}
+
+type LimiterConfig struct {
+	Store        AttemptStore  // Required
+	FreeAttempts int64         // Failures allowed before locking, defaults to 5
+	BaseLockout  time.Duration // Lockout after the first locking failure, doubled by each further failure, defaults to 30 seconds
+	MaxLockout   time.Duration // Longest lockout, defaults to 1 hour
+	ResetAfter   time.Duration // Failures are forgotten after this long without a new one, defaults to 24 hours
+	Prefix       string        // Prefix of the keys, so limiters of different things can share a store
+}
+
+// Limiter tracks failed logins per IP address and per username. Attempts are
+// counted before the credentials are checked, so concurrent ones cannot all
+// get through. Once a key has more than FreeAttempts, each further attempt
+// locks it first, for a duration that doubles every time up to MaxLockout.
+type Limiter struct {
+	store        AttemptStore
+	freeAttempts int64
+	baseLockout  time.Duration
+	maxLockout   time.Duration
+	resetAfter   time.Duration
+	prefix       string
+	now          func() time.Time
+}
+
+func NewLimiter(config LimiterConfig) (*Limiter, error) {
+	if config.Store == nil {
+		return nil, errors.New("an attempt store is required")
+	}
+
+	if config.FreeAttempts <= 0 {
+		config.FreeAttempts = 5
+	}
+
+	if config.BaseLockout <= 0 {
+		config.BaseLockout = 30 * time.Second
+	}
+
+	if config.MaxLockout <= 0 {
+		config.MaxLockout = time.Hour
+	}
+
+	if config.ResetAfter <= 0 {
+		config.ResetAfter = 24 * time.Hour
+	}
+
+	return &Limiter{
+		store:        config.Store,
+		freeAttempts: config.FreeAttempts,
+		baseLockout:  config.BaseLockout,
+		maxLockout:   config.MaxLockout,
+		resetAfter:   config.ResetAfter,
+		prefix:       config.Prefix,
+		now:          time.Now,
+	}, nil
+}
+
+// keys returns the keys the attempts of ip and username are stored under.
+func (l *Limiter) keys(ip, username string) []string {
+	return []string{l.prefix + "ip:" + ip, l.prefix + "user:" + username}
+}
+
+// Attempt counts a login attempt from ip to username, to be made before
+// checking their credentials. It returns how long they are locked, in which
+// case the attempt is turned away and not counted, or zero when it may go on.
+func (l *Limiter) Attempt(ctx context.Context, ip, username string) (time.Duration, error) {
+	now := l.now()
+
+	var wait time.Duration
+
+	keys := l.keys(ip, username)
+	for _, key := range keys {
+		attempt, err := l.store.AddAttempt(ctx, key, now, now.Add(-l.resetAfter))
+		if err != nil {
+			return 0, err
+		}
+
+		if attempt.LockedUntil.After(now) {
+			wait = max(wait, attempt.LockedUntil.Sub(now))
+			continue
+		}
+
+		lockout := l.lockout(attempt.Failures)
+		if lockout == 0 {
+			continue
+		}
+
+		locked, err := l.store.Lock(ctx, key, now, now.Add(lockout))
+		if err != nil {
+			return 0, err
+		}
+
+		// A concurrent attempt locked the key first
+		if !locked {
+			current, err := l.attempt(ctx, key)
+			if err != nil {
+				return 0, err
+			}
+			wait = max(wait, current.LockedUntil.Sub(now))
+		}
+	}
+
+	if wait > 0 {
+		for _, key := range keys {
+			if err := l.store.RemoveAttempt(ctx, key); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	return wait, nil
+}
+
+// Check returns how long logins from ip or to username are still locked, or
+// zero when they are not, like after an attempt failed.
+func (l *Limiter) Check(ctx context.Context, ip, username string) (time.Duration, error) {
+	now := l.now()
+
+	var wait time.Duration
+
+	for _, key := range l.keys(ip, username) {
+		attempt, err := l.attempt(ctx, key)
+		if err != nil {
+			return 0, err
+		}
+
+		wait = max(wait, attempt.LockedUntil.Sub(now))
+	}
+
+	return wait, nil
+}
+
+// Succeed records that the attempt from ip to username succeeded: it stops
+// counting against ip, and the failures of username are forgotten. The
+// failures of ip are kept, so logging in to one account does not allow
+// guessing the passwords of others from the same address.
+func (l *Limiter) Succeed(ctx context.Context, ip, username string) error {
+	keys := l.keys(ip, username)
+
+	if err := l.store.RemoveAttempt(ctx, keys[0]); err != nil {
+		return err
+	}
+
+	return l.store.DeleteAttempt(ctx, keys[1])
+}
+
+// PassFirstStep records that the first step of a login from ip to username
+// succeeded and the second is still to come, like the code of two-factor
+// authentication. The attempt stops counting against ip, as with Succeed, so
+// logging in many times does not lock the address, while the failures of
+// username are kept until Succeed is called for the second step.
+func (l *Limiter) PassFirstStep(ctx context.Context, ip, username string) error {
+	return l.store.RemoveAttempt(ctx, l.keys(ip, username)[0])
+}
+
+// attempt returns the failed attempts of key, or none when they are older
+// than resetAfter.
+func (l *Limiter) attempt(ctx context.Context, key string) (LoginAttempt, error) {
+	attempt, err := l.store.GetAttempt(ctx, key)
+	if errors.Is(err, ErrNoAttempt) {
+		return LoginAttempt{}, nil
+	}
+	if err != nil {
+		return LoginAttempt{}, err
+	}
+
+	if l.now().Sub(attempt.LastFailureAt) > l.resetAfter {
+		return LoginAttempt{}, nil
+	}
+
+	return attempt, nil
+}
+
+// lockout returns how long a key with the given number of failures is locked.
+func (l *Limiter) lockout(failures int64) time.Duration {
+	if failures <= l.freeAttempts {
+		return 0
+	}
+
+	lockout := l.baseLockout
+	for i := l.freeAttempts + 1; i < failures; i++ {
+		lockout *= 2
+		if lockout >= l.maxLockout {
+			return l.maxLockout
+		}
+	}
+
+	return min(lockout, l.maxLockout)
+}
@@ -0,0 +1,249 @@
+package auth
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+type attemptStoreMock struct {
+	mu       sync.Mutex
+	attempts map[string]LoginAttempt
+}
+
+func (m *attemptStoreMock) GetAttempt(ctx context.Context, key string) (LoginAttempt, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	attempt, ok := m.attempts[key]
+	if !ok {
+		return LoginAttempt{}, ErrNoAttempt
+	}
+	return attempt, nil
+}
+
+func (m *attemptStoreMock) AddAttempt(ctx context.Context, key string, now, resetBefore time.Time) (LoginAttempt, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	attempt, ok := m.attempts[key]
+	if !ok || attempt.LastFailureAt.Before(resetBefore) {
+		attempt = LoginAttempt{}
+	}
+
+	attempt.Failures++
+	attempt.LastFailureAt = now
+	m.attempts[key] = attempt
+
+	return attempt, nil
+}
+
+func (m *attemptStoreMock) RemoveAttempt(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if attempt, ok := m.attempts[key]; ok {
+		attempt.Failures = max(attempt.Failures-1, 0)
+		m.attempts[key] = attempt
+	}
+	return nil
+}
+
+func (m *attemptStoreMock) Lock(ctx context.Context, key string, now, until time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	attempt, ok := m.attempts[key]
+	if !ok || attempt.LockedUntil.After(now) {
+		return false, nil
+	}
+
+	attempt.LockedUntil = until
+	m.attempts[key] = attempt
+	return true, nil
+}
+
+func (m *attemptStoreMock) DeleteAttempt(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.attempts, key)
+	return nil
+}
+
+func newTestLimiter(t *testing.T, now *time.Time) (*Limiter, *attemptStoreMock) {
+	t.Helper()
+
+	store := &attemptStoreMock{attempts: map[string]LoginAttempt{}}
+	l, err := NewLimiter(LimiterConfig{
+		Store:        store,
+		FreeAttempts: 3,
+		BaseLockout:  time.Minute,
+		MaxLockout:   5 * time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("NewLimiter() error = %v", err)
+	}
+	l.now = func() time.Time { return *now }
+
+	return l, store
+}
+
+func TestLimiter(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	l, store := newTestLimiter(t, &now)
+
+	for i := 0; i < 3; i++ {
+		if wait, _ := l.Attempt(ctx, "10.0.0.1", "admin"); wait != 0 {
+			t.Fatalf("Attempt() #%d locked for %s, want no lockout", i+1, wait)
+		}
+		if wait, _ := l.Check(ctx, "10.0.0.1", "admin"); wait != 0 {
+			t.Fatalf("Check() after failure #%d = %s, want no lockout", i+1, wait)
+		}
+	}
+
+	// Each attempt past the free ones may go on, but locks the keys first for
+	// a duration that doubles every time, up to MaxLockout
+	for _, want := range []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute, 5 * time.Minute, 5 * time.Minute} {
+		if wait, _ := l.Attempt(ctx, "10.0.0.1", "admin"); wait != 0 {
+			t.Fatalf("Attempt() after the lockout was turned away for %s", wait)
+		}
+
+		if wait, _ := l.Check(ctx, "10.0.0.1", "admin"); wait != want {
+			t.Errorf("Check() after the failure = %s, want %s", wait, want)
+		}
+
+		if wait, _ := l.Attempt(ctx, "10.0.0.1", "admin"); wait != want {
+			t.Errorf("Attempt() during the lockout = %s, want %s", wait, want)
+		}
+
+		now = now.Add(want)
+	}
+
+	if got := store.attempts["ip:10.0.0.1"].Failures; got != 8 {
+		t.Errorf("Failures = %d, want 8, attempts turned away must not count", got)
+	}
+
+	now = now.Add(-time.Minute)
+
+	if wait, _ := l.Check(ctx, "10.0.0.2", "admin"); wait != time.Minute {
+		t.Errorf("Check() of the username from another IP = %s, want %s", wait, time.Minute)
+	}
+
+	if wait, _ := l.Check(ctx, "10.0.0.1", "other"); wait != time.Minute {
+		t.Errorf("Check() of the IP with another username = %s, want %s", wait, time.Minute)
+	}
+
+	if wait, _ := l.Check(ctx, "10.0.0.2", "other"); wait != 0 {
+		t.Errorf("Check() of an unrelated IP and username = %s, want 0", wait)
+	}
+
+	now = now.Add(time.Minute)
+
+	// A valid login to one account does not clear the failures of its IP
+	if wait, _ := l.Attempt(ctx, "10.0.0.1", "other"); wait != 0 {
+		t.Fatalf("Attempt() after the lockout was turned away for %s", wait)
+	}
+	if err := l.Succeed(ctx, "10.0.0.1", "other"); err != nil {
+		t.Fatalf("Succeed() error = %v", err)
+	}
+	if _, ok := store.attempts["user:other"]; ok {
+		t.Errorf("Succeed() kept the attempts of the username")
+	}
+	if got := store.attempts["ip:10.0.0.1"].Failures; got != 8 {
+		t.Errorf("Failures of the IP after Succeed() = %d, want 8", got)
+	}
+
+	// Old failures are forgotten after ResetAfter
+	now = now.Add(25 * time.Hour)
+	if wait, _ := l.Attempt(ctx, "10.0.0.1", "admin"); wait != 0 {
+		t.Errorf("Attempt() after ResetAfter was turned away for %s", wait)
+	}
+	if wait, _ := l.Check(ctx, "10.0.0.1", "admin"); wait != 0 {
+		t.Errorf("Check() after ResetAfter = %s, want no lockout", wait)
+	}
+}
+
+func TestLimiter_TwoStepLogins(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	l, store := newTestLimiter(t, &now)
+
+	// Logging in with a password and a code counts two attempts each time
+	for i := 0; i < 10; i++ {
+		if wait, _ := l.Attempt(ctx, "10.0.0.1", "admin"); wait != 0 {
+			t.Fatalf("password of login #%d turned away for %s", i+1, wait)
+		}
+		if err := l.PassFirstStep(ctx, "10.0.0.1", "admin"); err != nil {
+			t.Fatalf("PassFirstStep() error = %v", err)
+		}
+
+		if wait, _ := l.Attempt(ctx, "10.0.0.1", "admin"); wait != 0 {
+			t.Fatalf("code of login #%d turned away for %s", i+1, wait)
+		}
+		if err := l.Succeed(ctx, "10.0.0.1", "admin"); err != nil {
+			t.Fatalf("Succeed() error = %v", err)
+		}
+
+		now = now.Add(time.Minute)
+	}
+
+	if got := store.attempts["ip:10.0.0.1"].Failures; got != 0 {
+		t.Errorf("Failures of the IP after the logins = %d, want 0", got)
+	}
+
+	// Until the code is accepted, the password attempt still counts against
+	// the username
+	if wait, _ := l.Attempt(ctx, "10.0.0.1", "admin"); wait != 0 {
+		t.Fatalf("Attempt() turned away for %s", wait)
+	}
+	if err := l.PassFirstStep(ctx, "10.0.0.1", "admin"); err != nil {
+		t.Fatalf("PassFirstStep() error = %v", err)
+	}
+	if got := store.attempts["user:admin"].Failures; got != 1 {
+		t.Errorf("Failures of the username before the code = %d, want 1", got)
+	}
+}
+
+func TestLimiter_ConcurrentAttempts(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	l, _ := newTestLimiter(t, &now)
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		allowed int
+	)
+
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			wait, err := l.Attempt(ctx, "10.0.0.1", "admin")
+			if err != nil {
+				t.Errorf("Attempt() error = %v", err)
+				return
+			}
+
+			if wait == 0 {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	// The free attempts, and at most one that locks the keys
+	if allowed < 3 || allowed > 4 {
+		t.Errorf("%d concurrent attempts got through, want the 3 free ones and at most one more", allowed)
+	}
+}
@@ -0,0 +1,225 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/mail"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrInvalidLink is returned when a login link is forged, expired or was
+	// already used.
+	ErrInvalidLink = errors.New("invalid or expired login link")
+
+	// ErrInvalidEmail is returned for text that is not an email address.
+	ErrInvalidEmail = errors.New("invalid email address")
+)
+
+// Mailer sends plain text emails, like mail.SMTP.
+type Mailer interface {
+	SendMail(ctx context.Context, to, subject, body string) error
+}
+
+// MagicLinkStore persists the hashes of the login links, so each one works once.
+type MagicLinkStore interface {
+	CreateMagicLink(ctx context.Context, hash string, userID int64, expiresAt time.Time) error
+	// UseMagicLink marks the unused, unexpired link with the given hash as
+	// used and returns its user. It returns ErrInvalidLink when there is none.
+	UseMagicLink(ctx context.Context, hash string, now time.Time) (int64, error)
+	DeleteExpiredMagicLinks(ctx context.Context, now time.Time) error
+}
+
+type MagicLinkConfig struct {
+	Auth     *Auth          // Signs the links and looks up the users, required
+	Store    MagicLinkStore // Required
+	Mailer   Mailer         // Required
+	Attempts AttemptStore   // Counts the links sent per address and IP, required
+	BaseURL  string         // Public URL of the blog the links point to, like "https://blog.example.com", required
+	SiteName string         // Name in the subject of the emails, like the blog name
+	Validity time.Duration  // How long a link works, defaults to 15 minutes
+	Logger   *log.Logger    // Logs the links that could not be sent, defaults to the standard logger
+}
+
+// MagicLinks logs users in with single-use links sent to their email address.
+type MagicLinks struct {
+	auth     *Auth
+	store    MagicLinkStore
+	mailer   Mailer
+	limiter  *Limiter
+	baseURL  string
+	siteName string
+	validity time.Duration
+	logger   *log.Logger
+	now      func() time.Time
+
+	sending sync.WaitGroup // Links being sent in the background, see Wait
+}
+
+func NewMagicLinks(config MagicLinkConfig) (*MagicLinks, error) {
+	if config.Auth == nil || config.Store == nil || config.Mailer == nil || config.Attempts == nil {
+		return nil, errors.New("auth, a magic link store, a mailer and an attempt store are required")
+	}
+
+	baseURL, err := url.Parse(strings.TrimSuffix(config.BaseURL, "/"))
+	if err != nil || baseURL.Host == "" || (baseURL.Scheme != "http" && baseURL.Scheme != "https") {
+		return nil, fmt.Errorf("invalid base URL %q", config.BaseURL)
+	}
+
+	if config.SiteName == "" {
+		config.SiteName = "Blogo"
+	}
+
+	if config.Validity <= 0 {
+		config.Validity = 15 * time.Minute
+	}
+
+	if config.Logger == nil {
+		config.Logger = log.Default()
+	}
+
+	// Three links, then a lockout of 5 minutes that doubles up to an hour
+	limiter, err := NewLimiter(LimiterConfig{
+		Store:        config.Attempts,
+		FreeAttempts: 3,
+		BaseLockout:  5 * time.Minute,
+		MaxLockout:   time.Hour,
+		ResetAfter:   time.Hour,
+		Prefix:       "magic:",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &MagicLinks{
+		auth:     config.Auth,
+		store:    config.Store,
+		mailer:   config.Mailer,
+		limiter:  limiter,
+		baseURL:  baseURL.String(),
+		siteName: config.SiteName,
+		validity: config.Validity,
+		logger:   config.Logger,
+		now:      time.Now,
+	}, nil
+}
+
+// NormalizeEmail returns the bare, lowercased address of email, or an error
+// when it is not an address.
+func NormalizeEmail(email string) (string, error) {
+	address, err := mail.ParseAddress(strings.TrimSpace(email))
+	if err != nil {
+		return "", ErrInvalidEmail
+	}
+	return strings.ToLower(address.Address), nil
+}
+
+const magicLinkPurpose = "magic:"
+
+// Send emails a login link to the user with the address email, requested
+// from ip. It returns how long further requests for the address or the IP
+// are locked, without sending, when they asked for too many links.
+//
+// The link is created and sent in the background, and errors are only
+// logged. Nothing is sent to unknown addresses. Either way Send returns the
+// same, as fast, so the form does not reveal which addresses have accounts.
+func (ml *MagicLinks) Send(ctx context.Context, ip, email string) (time.Duration, error) {
+	email, err := NormalizeEmail(email)
+	if err != nil {
+		return 0, err
+	}
+
+	// Every request counts against the limit, sent or not
+	wait, err := ml.limiter.Attempt(ctx, ip, email)
+	if err != nil || wait > 0 {
+		return wait, err
+	}
+
+	// Nothing is checked before sending, so the request that locks the
+	// address is not sent either
+	wait, err = ml.limiter.Check(ctx, ip, email)
+	if err != nil || wait > 0 {
+		return wait, err
+	}
+
+	ml.sending.Add(1)
+	go func() {
+		defer ml.sending.Done()
+
+		// The request is done by the time the link is sent
+		if err := ml.send(context.WithoutCancel(ctx), email); err != nil {
+			ml.logger.Printf("Could not send a login link to %q: %v\n", email, err)
+		}
+	}()
+
+	return 0, nil
+}
+
+// Wait waits until the links that Send started sending in the background are
+// sent, so shutting down does not drop the ones already asked for. The mailer
+// bounds how long that takes.
+func (ml *MagicLinks) Wait() {
+	ml.sending.Wait()
+}
+
+// send emails a login link to the user with the address email, if any.
+func (ml *MagicLinks) send(ctx context.Context, email string) error {
+	user, err := ml.auth.users.GetUserByEmail(ctx, email)
+	if errors.Is(err, ErrUserNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	now := ml.now()
+	if err := ml.store.DeleteExpiredMagicLinks(ctx, now); err != nil {
+		return err
+	}
+
+	nonce := rand.Text()
+	expiry := now.Add(ml.validity)
+
+	if err := ml.store.CreateMagicLink(ctx, hashMagicLink(nonce), user.ID, expiry); err != nil {
+		return err
+	}
+
+	link := ml.baseURL + "/login/email/" + url.PathEscape(ml.auth.sealToken(magicLinkPurpose, nonce))
+
+	subject := "Log in to " + ml.siteName
+	body := fmt.Sprintf("Hi %s,\n\nOpen this link to log in to %s:\n\n%s\n\nIt works once, for the next %d minutes. If you did not ask for it, you can ignore this email.\n",
+		user.Username, ml.siteName, link, int(ml.validity.Minutes()))
+
+	return ml.mailer.SendMail(ctx, email, subject, body)
+}
+
+// Login uses the token of a link and returns the user it was sent to. It
+// returns ErrInvalidLink when the link is forged, expired or was used.
+func (ml *MagicLinks) Login(ctx context.Context, token string) (User, error) {
+	nonce, err := ml.auth.openToken(magicLinkPurpose, token)
+	if err != nil {
+		return User{}, ErrInvalidLink
+	}
+
+	userID, err := ml.store.UseMagicLink(ctx, hashMagicLink(nonce), ml.now())
+	if err != nil {
+		return User{}, err
+	}
+
+	return ml.auth.users.GetUserByID(ctx, userID)
+}
+
+// hashMagicLink hashes the random part of a link for storage, so the links
+// cannot be used from a copy of the database.
+func hashMagicLink(nonce string) string {
+	sum := sha256.Sum256([]byte(nonce))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,251 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type magicLinkStoreMock struct {
+	mu    sync.Mutex
+	links map[string]magicLinkMock // By hash
+}
+
+type magicLinkMock struct {
+	userID    int64
+	expiresAt time.Time
+	used      bool
+}
+
+func (m *magicLinkStoreMock) CreateMagicLink(ctx context.Context, hash string, userID int64, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.links[hash] = magicLinkMock{userID: userID, expiresAt: expiresAt}
+	return nil
+}
+
+func (m *magicLinkStoreMock) UseMagicLink(ctx context.Context, hash string, now time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	link, ok := m.links[hash]
+	if !ok || link.used || !now.Before(link.expiresAt) {
+		return 0, ErrInvalidLink
+	}
+	link.used = true
+	m.links[hash] = link
+	return link.userID, nil
+}
+
+func (m *magicLinkStoreMock) DeleteExpiredMagicLinks(ctx context.Context, now time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for hash, link := range m.links {
+		if !now.Before(link.expiresAt) {
+			delete(m.links, hash)
+		}
+	}
+	return nil
+}
+
+type sentMail struct {
+	to, subject, body string
+}
+
+type mailerMock struct {
+	mu   sync.Mutex
+	sent []sentMail
+	err  error
+}
+
+func (m *mailerMock) SendMail(ctx context.Context, to, subject, body string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.err != nil {
+		return m.err
+	}
+
+	m.sent = append(m.sent, sentMail{to: to, subject: subject, body: body})
+	return nil
+}
+
+// linkToken returns the token of the login link in an email.
+func linkToken(t *testing.T, body string) string {
+	t.Helper()
+
+	i := strings.Index(body, "https://blog.example.com/login/email/")
+	if i < 0 {
+		t.Fatalf("no login link in %q", body)
+	}
+
+	link, _, _ := strings.Cut(body[i:], "\n")
+	token, err := url.PathUnescape(strings.TrimPrefix(link, "https://blog.example.com/login/email/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func newTestMagicLinks(t *testing.T) (*MagicLinks, *mailerMock) {
+	t.Helper()
+
+	a, err := NewAuth(AuthConfig{
+		SecretKey:     "thisisaverylongsecretkeythatisatleast32characterslong",
+		TokenValidity: 60,
+		CookieName:    "testcookie",
+		Users:         &usersMock{users: []User{{ID: 1, Username: "writer", Email: "writer@example.com"}}},
+		Sessions:      newSessionsMock(),
+	})
+	if err != nil {
+		t.Fatalf("NewAuth() error = %v", err)
+	}
+
+	mailer := &mailerMock{}
+	ml, err := NewMagicLinks(MagicLinkConfig{
+		Auth:     a,
+		Store:    &magicLinkStoreMock{links: map[string]magicLinkMock{}},
+		Mailer:   mailer,
+		Attempts: &attemptStoreMock{attempts: map[string]LoginAttempt{}},
+		BaseURL:  "https://blog.example.com/",
+		SiteName: "Test Blog",
+		Logger:   log.New(io.Discard, "", 0),
+	})
+	if err != nil {
+		t.Fatalf("NewMagicLinks() error = %v", err)
+	}
+
+	now := time.Now()
+	ml.limiter.now = func() time.Time { return now }
+
+	return ml, mailer
+}
+
+func TestMagicLinks(t *testing.T) {
+	ctx := context.Background()
+	ml, mailer := newTestMagicLinks(t)
+
+	if wait, err := ml.Send(ctx, "192.0.2.1", " Writer@Example.com "); err != nil || wait != 0 {
+		t.Fatalf("Send() = %v, %v, want 0", wait, err)
+	}
+
+	ml.Wait()
+
+	if len(mailer.sent) != 1 || mailer.sent[0].to != "writer@example.com" || mailer.sent[0].subject != "Log in to Test Blog" {
+		t.Fatalf("sent %+v, want one email to writer@example.com", mailer.sent)
+	}
+
+	token := linkToken(t, mailer.sent[0].body)
+
+	user, err := ml.Login(ctx, token)
+	if err != nil || user.Username != "writer" {
+		t.Fatalf("Login() = %v, %v, want writer", user, err)
+	}
+
+	if _, err := ml.Login(ctx, token); !errors.Is(err, ErrInvalidLink) {
+		t.Errorf("Login() a second time error = %v, want %v", err, ErrInvalidLink)
+	}
+
+	if _, err := ml.Login(ctx, ml.auth.sealToken(magicLinkPurpose, "FORGED")); !errors.Is(err, ErrInvalidLink) {
+		t.Errorf("Login() with an unknown link error = %v, want %v", err, ErrInvalidLink)
+	}
+
+	if _, err := ml.Login(ctx, ml.auth.sessionToken("SESSION")); !errors.Is(err, ErrInvalidLink) {
+		t.Errorf("Login() with a session token error = %v, want %v", err, ErrInvalidLink)
+	}
+}
+
+func TestMagicLinks_Expired(t *testing.T) {
+	ctx := context.Background()
+	ml, mailer := newTestMagicLinks(t)
+
+	now := time.Now()
+	ml.now = func() time.Time { return now }
+
+	if _, err := ml.Send(ctx, "192.0.2.1", "writer@example.com"); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+
+	ml.Wait()
+
+	ml.now = func() time.Time { return now.Add(15 * time.Minute) }
+
+	if _, err := ml.Login(ctx, linkToken(t, mailer.sent[0].body)); !errors.Is(err, ErrInvalidLink) {
+		t.Errorf("Login() with an expired link error = %v, want %v", err, ErrInvalidLink)
+	}
+}
+
+func TestMagicLinks_UnknownAddress(t *testing.T) {
+	ml, mailer := newTestMagicLinks(t)
+
+	if wait, err := ml.Send(context.Background(), "192.0.2.1", "nobody@example.com"); err != nil || wait != 0 {
+		t.Errorf("Send() = %v, %v, want 0 like for a known address", wait, err)
+	}
+
+	ml.Wait()
+
+	if len(mailer.sent) != 0 {
+		t.Errorf("sent %+v to an unknown address", mailer.sent)
+	}
+
+	// Errors of the mailer only happen for known addresses, so they are not returned either
+	mailer.err = errors.New("connection refused")
+	if wait, err := ml.Send(context.Background(), "192.0.2.1", "writer@example.com"); err != nil || wait != 0 {
+		t.Errorf("Send() with a failing mailer = %v, %v, want 0 like for an unknown address", wait, err)
+	}
+
+	ml.Wait()
+
+	if _, err := ml.Send(context.Background(), "192.0.2.1", "not an address"); !errors.Is(err, ErrInvalidEmail) {
+		t.Errorf("Send() with an invalid address error = %v, want %v", err, ErrInvalidEmail)
+	}
+}
+
+func TestMagicLinks_RateLimit(t *testing.T) {
+	ctx := context.Background()
+	ml, mailer := newTestMagicLinks(t)
+
+	for i := range 3 {
+		if wait, err := ml.Send(ctx, "192.0.2."+string(rune('1'+i)), "writer@example.com"); err != nil || wait != 0 {
+			t.Fatalf("Send() #%d = %v, %v, want 0", i+1, wait, err)
+		}
+	}
+
+	// The address is locked, whichever IP asks
+	wait, err := ml.Send(ctx, "192.0.2.9", "writer@example.com")
+	if err != nil || wait != 5*time.Minute {
+		t.Errorf("Send() #4 = %v, %v, want a 5 minute lockout", wait, err)
+	}
+
+	ml.Wait()
+
+	if len(mailer.sent) != 3 {
+		t.Errorf("sent %d emails, want 3", len(mailer.sent))
+	}
+}
+
+func TestNewMagicLinks(t *testing.T) {
+	ml, _ := newTestMagicLinks(t)
+
+	config := MagicLinkConfig{
+		Auth:     ml.auth,
+		Store:    ml.store,
+		Mailer:   ml.mailer,
+		Attempts: &attemptStoreMock{},
+	}
+
+	for _, baseURL := range []string{"", "blog.example.com", "ftp://blog.example.com"} {
+		config.BaseURL = baseURL
+		if _, err := NewMagicLinks(config); err == nil {
+			t.Errorf("NewMagicLinks() accepted the base URL %q", baseURL)
+		}
+	}
+}
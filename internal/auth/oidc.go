@@ -0,0 +1,425 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrOIDCNotAllowed is returned when the identity provider signed the user in
+// but they are not on the allowlist or have no local account.
+var ErrOIDCNotAllowed = errors.New("this account may not log in")
+
+// ErrOIDCState is returned when the callback does not belong to the login
+// started by the browser, or the login took too long.
+var ErrOIDCState = errors.New("invalid or expired login state")
+
+const (
+	oidcStateValidity = 10 * time.Minute
+	oidcClockSkew     = time.Minute // Leeway for the clocks of the provider and the blog
+	oidcStatePurpose  = "oidc:"
+)
+
+// OIDCIdentities links the subjects of the identity provider to local users,
+// so users keep their account when their email address changes.
+type OIDCIdentities interface {
+	// GetOIDCIdentity returns the ID of the user linked to the subject, or
+	// ErrUserNotFound when there is none.
+	GetOIDCIdentity(ctx context.Context, issuer, subject string) (int64, error)
+	LinkOIDCIdentity(ctx context.Context, userID int64, issuer, subject string, linkedAt time.Time) error
+}
+
+type OIDCConfig struct {
+	Auth         *Auth          // Signs the login state and looks up the users, required
+	Identities   OIDCIdentities // Required
+	Issuer       string         // URL of the identity provider, like "https://id.example.com", required
+	ClientID     string         // Required
+	ClientSecret string         // Empty for public clients, which rely on PKCE alone
+	RedirectURL  string         // Callback registered with the provider, like "https://blog.example.com/login/oidc/callback", required
+	Scopes       []string       // Scopes to request, defaults to "openid email profile"
+	Allowlist    []string       // Email addresses, "@domain" suffixes or subjects allowed to log in, anyone with a local account when empty
+	Name         string         // Name of the provider on the login page, defaults to "single sign-on"
+	HTTPClient   *http.Client   // Client for the provider, defaults to one with a 10 second timeout
+}
+
+// OIDC logs users in with the authorization code flow of an OpenID Connect
+// provider, using PKCE. Users are mapped to local accounts by the subject
+// they were linked to, or else by their verified email address.
+type OIDC struct {
+	auth         *Auth
+	identities   OIDCIdentities
+	issuer       string
+	clientID     string
+	clientSecret string
+	redirectURL  string
+	scopes       []string
+	allowlist    []string
+	name         string
+	client       *http.Client
+	now          func() time.Time
+
+	mu       sync.Mutex
+	provider *oidcProvider // Discovered on first use, so the blog starts while the provider is down
+	keys     *jwks
+}
+
+// oidcProvider is the part of the discovery document the login uses.
+type oidcProvider struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+func NewOIDC(config OIDCConfig) (*OIDC, error) {
+	if config.Auth == nil || config.Identities == nil {
+		return nil, errors.New("auth and an identity store are required")
+	}
+
+	if config.Issuer == "" || config.ClientID == "" || config.RedirectURL == "" {
+		return nil, errors.New("an issuer, a client ID and a redirect URL are required")
+	}
+
+	issuer, err := url.Parse(config.Issuer)
+	if err != nil || issuer.Host == "" || (issuer.Scheme != "http" && issuer.Scheme != "https") {
+		return nil, fmt.Errorf("invalid issuer %q", config.Issuer)
+	}
+
+	redirectURL, err := url.Parse(config.RedirectURL)
+	if err != nil || redirectURL.Host == "" || (redirectURL.Scheme != "http" && redirectURL.Scheme != "https") {
+		return nil, fmt.Errorf("invalid redirect URL %q", config.RedirectURL)
+	}
+
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"openid", "email", "profile"}
+	}
+	if !slices.Contains(config.Scopes, "openid") {
+		config.Scopes = append([]string{"openid"}, config.Scopes...)
+	}
+
+	if config.Name == "" {
+		config.Name = "single sign-on"
+	}
+
+	if config.HTTPClient == nil {
+		config.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	allowlist := make([]string, 0, len(config.Allowlist))
+	for _, entry := range config.Allowlist {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			allowlist = append(allowlist, entry)
+		}
+	}
+
+	return &OIDC{
+		auth:         config.Auth,
+		identities:   config.Identities,
+		issuer:       strings.TrimSuffix(config.Issuer, "/"),
+		clientID:     config.ClientID,
+		clientSecret: config.ClientSecret,
+		redirectURL:  config.RedirectURL,
+		scopes:       config.Scopes,
+		allowlist:    allowlist,
+		name:         config.Name,
+		client:       config.HTTPClient,
+		now:          time.Now,
+	}, nil
+}
+
+// Name returns the name of the provider to show on the login page.
+func (o *OIDC) Name() string {
+	return o.name
+}
+
+// AuthCodeURL starts a login. It returns the URL of the provider to send the
+// browser to, and the state to keep in a cookie until the callback, which
+// binds the callback to this browser. remember is whether the session should
+// use the "remember me" validity.
+func (o *OIDC) AuthCodeURL(ctx context.Context, remember bool) (string, string, error) {
+	provider, err := o.discover(ctx)
+	if err != nil {
+		return "", "", err
+	}
+
+	state := rand.Text()
+	nonce := rand.Text()
+	verifier := rand.Text() + rand.Text() // PKCE needs at least 43 characters
+
+	challenge := sha256.Sum256([]byte(verifier))
+
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {o.clientID},
+		"redirect_uri":          {o.redirectURL},
+		"scope":                 {strings.Join(o.scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+
+	authURL, err := url.Parse(provider.AuthorizationEndpoint)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid authorization endpoint: %w", err)
+	}
+
+	query := authURL.Query()
+	for key, values := range params {
+		query[key] = values
+	}
+	authURL.RawQuery = query.Encode()
+
+	rememberFlag := "0"
+	if remember {
+		rememberFlag = "1"
+	}
+
+	expiry := o.now().Add(oidcStateValidity).Unix()
+	cookie := o.auth.sealToken(oidcStatePurpose, strings.Join([]string{state, nonce, verifier, rememberFlag, strconv.FormatInt(expiry, 10)}, ":"))
+
+	return authURL.String(), cookie, nil
+}
+
+// Exchange completes a login with the state cookie of AuthCodeURL and the
+// state and code of the callback. It returns the local user and whether they
+// asked to be remembered.
+func (o *OIDC) Exchange(ctx context.Context, cookie, state, code string) (User, bool, error) {
+	data, err := o.auth.openToken(oidcStatePurpose, cookie)
+	if err != nil {
+		return User{}, false, ErrOIDCState
+	}
+
+	fields := strings.Split(data, ":")
+	if len(fields) != 5 {
+		return User{}, false, ErrOIDCState
+	}
+
+	expiry, err := strconv.ParseInt(fields[4], 10, 64)
+	if err != nil || o.now().Unix() > expiry {
+		return User{}, false, ErrOIDCState
+	}
+
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(fields[0])) != 1 {
+		return User{}, false, ErrOIDCState
+	}
+
+	nonce, verifier, remember := fields[1], fields[2], fields[3] == "1"
+
+	if code == "" {
+		return User{}, false, errors.New("the provider returned no authorization code")
+	}
+
+	rawIDToken, err := o.redeem(ctx, code, verifier)
+	if err != nil {
+		return User{}, false, err
+	}
+
+	claims, err := o.verifyIDToken(ctx, rawIDToken, nonce)
+	if err != nil {
+		return User{}, false, err
+	}
+
+	user, err := o.localUser(ctx, claims)
+	if err != nil {
+		return User{}, false, err
+	}
+
+	return user, remember, nil
+}
+
+// redeem exchanges the authorization code for the tokens of the user and
+// returns the ID token.
+func (o *OIDC) redeem(ctx context.Context, code, verifier string) (string, error) {
+	provider, err := o.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {o.redirectURL},
+		"client_id":     {o.clientID},
+		"code_verifier": {verifier},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, provider.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	if o.clientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(o.clientID), url.QueryEscape(o.clientSecret))
+	}
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var tokens struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&tokens); err != nil {
+		return "", fmt.Errorf("invalid token response (status %d): %w", resp.StatusCode, err)
+	}
+
+	if resp.StatusCode != http.StatusOK || tokens.Error != "" {
+		return "", fmt.Errorf("token request failed (status %d): %s %s", resp.StatusCode, tokens.Error, tokens.ErrorDescription)
+	}
+
+	if tokens.IDToken == "" {
+		return "", errors.New("the token response has no ID token")
+	}
+
+	return tokens.IDToken, nil
+}
+
+// localUser returns the local account of the user the provider signed in.
+// The first login links the subject to the account with the same verified
+// email address, later logins find the account by the subject.
+func (o *OIDC) localUser(ctx context.Context, claims idTokenClaims) (User, error) {
+	if !o.allowed(claims) {
+		return User{}, ErrOIDCNotAllowed
+	}
+
+	userID, err := o.identities.GetOIDCIdentity(ctx, o.issuer, claims.Subject)
+	if err == nil {
+		user, err := o.auth.users.GetUserByID(ctx, userID)
+		if errors.Is(err, ErrUserNotFound) {
+			return User{}, ErrOIDCNotAllowed
+		}
+		return user, err
+	}
+	if !errors.Is(err, ErrUserNotFound) {
+		return User{}, err
+	}
+
+	if claims.Email == "" || !claims.EmailVerified {
+		return User{}, ErrOIDCNotAllowed
+	}
+
+	email, err := NormalizeEmail(claims.Email)
+	if err != nil {
+		return User{}, ErrOIDCNotAllowed
+	}
+
+	user, err := o.auth.users.GetUserByEmail(ctx, email)
+	if errors.Is(err, ErrUserNotFound) {
+		return User{}, ErrOIDCNotAllowed
+	}
+	if err != nil {
+		return User{}, err
+	}
+
+	if err := o.identities.LinkOIDCIdentity(ctx, user.ID, o.issuer, claims.Subject, o.now()); err != nil {
+		return User{}, err
+	}
+
+	return user, nil
+}
+
+// allowed reports whether the allowlist lets the user of claims log in.
+// Email entries only match verified addresses.
+func (o *OIDC) allowed(claims idTokenClaims) bool {
+	if len(o.allowlist) == 0 {
+		return true
+	}
+
+	email := ""
+	if claims.EmailVerified {
+		email = strings.ToLower(claims.Email)
+	}
+
+	for _, entry := range o.allowlist {
+		switch {
+		case entry == claims.Subject:
+			return true
+		case email == "":
+			continue
+		case strings.HasPrefix(entry, "@") && strings.HasSuffix(email, strings.ToLower(entry)):
+			return true
+		case strings.EqualFold(entry, email):
+			return true
+		}
+	}
+
+	return false
+}
+
+// discover fetches the discovery document of the issuer once, and again
+// after a failure. It is fetched without holding the lock, so concurrent
+// logins may fetch it more than once, but a slow provider does not block
+// the ones that found it.
+func (o *OIDC) discover(ctx context.Context) (*oidcProvider, error) {
+	o.mu.Lock()
+	cached := o.provider
+	o.mu.Unlock()
+
+	if cached != nil {
+		return cached, nil
+	}
+
+	var provider oidcProvider
+	if err := o.getJSON(ctx, o.issuer+"/.well-known/openid-configuration", &provider); err != nil {
+		return nil, fmt.Errorf("OIDC discovery failed: %w", err)
+	}
+
+	// The document must be for the configured issuer, or its tokens would be
+	// checked against the wrong issuer
+	if strings.TrimSuffix(provider.Issuer, "/") != o.issuer {
+		return nil, fmt.Errorf("OIDC discovery returned the issuer %q, want %q", provider.Issuer, o.issuer)
+	}
+
+	if provider.AuthorizationEndpoint == "" || provider.TokenEndpoint == "" || provider.JWKSURI == "" {
+		return nil, errors.New("OIDC discovery document lacks an endpoint")
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.provider == nil {
+		o.provider = &provider
+	}
+	return o.provider, nil
+}
+
+func (o *OIDC) getJSON(ctx context.Context, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: status %d", url, resp.StatusCode)
+	}
+
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
@@ -0,0 +1,556 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"maps"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mockProvider is a local OpenID Connect provider. Its authorization
+// endpoint signs in a fixed user right away and redirects back with a code.
+type mockProvider struct {
+	server       *httptest.Server
+	clientID     string
+	clientSecret string
+
+	mu          sync.Mutex
+	signer      crypto.Signer
+	alg         string
+	kid         string
+	claims      map[string]any // Claims of the ID tokens, on top of the standard ones
+	codes       map[string]mockAuthorization
+	jwksFetches int
+	stalled     chan struct{} // Receives once a key fetch waits for release, when set
+	release     chan struct{} // Closed to let the waiting key fetches go on
+}
+
+type mockAuthorization struct {
+	nonce       string
+	challenge   string
+	redirectURI string
+}
+
+func newMockProvider(t *testing.T, alg string) *mockProvider {
+	t.Helper()
+
+	p := &mockProvider{
+		clientID:     "blogo",
+		clientSecret: "client secret",
+		codes:        map[string]mockAuthorization{},
+		claims: map[string]any{
+			"sub":            "subject-1",
+			"email":          "Writer@Example.com",
+			"email_verified": true,
+		},
+	}
+	p.rotateKey(t, alg)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("GET /jwks", p.jwks)
+	mux.HandleFunc("GET /authorize", p.authorize)
+	mux.HandleFunc("POST /token", p.token)
+
+	p.server = httptest.NewServer(mux)
+	t.Cleanup(p.server.Close)
+
+	return p
+}
+
+// rotateKey replaces the signing key with a new one of the algorithm alg.
+func (p *mockProvider) rotateKey(t *testing.T, alg string) {
+	t.Helper()
+
+	var signer crypto.Signer
+	var err error
+	switch alg {
+	case "RS256":
+		signer, err = rsa.GenerateKey(rand.Reader, 2048)
+	case "ES256":
+		signer, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.signer, p.alg, p.kid = signer, alg, rand.Text()[:8]
+}
+
+func (p *mockProvider) discovery(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]string{
+		"issuer":                 p.server.URL,
+		"authorization_endpoint": p.server.URL + "/authorize",
+		"token_endpoint":         p.server.URL + "/token",
+		"jwks_uri":               p.server.URL + "/jwks",
+	})
+}
+
+func (p *mockProvider) jwks(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	stalled, release := p.stalled, p.release
+	p.mu.Unlock()
+
+	if stalled != nil {
+		stalled <- struct{}{}
+		<-release
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.jwksFetches++
+
+	b64 := base64.RawURLEncoding.EncodeToString
+	key := map[string]string{"kid": p.kid, "use": "sig"}
+
+	switch pub := p.signer.Public().(type) {
+	case *rsa.PublicKey:
+		key["kty"], key["n"], key["e"] = "RSA", b64(pub.N.Bytes()), b64(big.NewInt(int64(pub.E)).Bytes())
+	case *ecdsa.PublicKey:
+		key["kty"], key["crv"], key["x"], key["y"] = "EC", "P-256", b64(pub.X.FillBytes(make([]byte, 32))), b64(pub.Y.FillBytes(make([]byte, 32)))
+	}
+
+	json.NewEncoder(w).Encode(map[string]any{"keys": []any{key}})
+}
+
+func (p *mockProvider) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	if query.Get("response_type") != "code" || query.Get("client_id") != p.clientID || query.Get("code_challenge_method") != "S256" ||
+		query.Get("code_challenge") == "" || !strings.Contains(query.Get("scope"), "openid") {
+		http.Error(w, "invalid_request", http.StatusBadRequest)
+		return
+	}
+
+	code := rand.Text()
+
+	p.mu.Lock()
+	p.codes[code] = mockAuthorization{
+		nonce:       query.Get("nonce"),
+		challenge:   query.Get("code_challenge"),
+		redirectURI: query.Get("redirect_uri"),
+	}
+	p.mu.Unlock()
+
+	http.Redirect(w, r, query.Get("redirect_uri")+"?"+url.Values{"code": {code}, "state": {query.Get("state")}}.Encode(), http.StatusFound)
+}
+
+func (p *mockProvider) token(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	fail := func(code string) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": code})
+	}
+
+	clientID, clientSecret, _ := r.BasicAuth()
+	if clientID != url.QueryEscape(p.clientID) || clientSecret != url.QueryEscape(p.clientSecret) {
+		fail("invalid_client")
+		return
+	}
+
+	auth, ok := p.codes[r.FormValue("code")]
+	delete(p.codes, r.FormValue("code"))
+
+	challenge := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+	if !ok || r.FormValue("grant_type") != "authorization_code" || r.FormValue("redirect_uri") != auth.redirectURI ||
+		base64.RawURLEncoding.EncodeToString(challenge[:]) != auth.challenge {
+		fail("invalid_grant")
+		return
+	}
+
+	now := time.Now()
+	claims := map[string]any{
+		"iss":   p.server.URL,
+		"aud":   p.clientID,
+		"iat":   now.Unix(),
+		"exp":   now.Add(5 * time.Minute).Unix(),
+		"nonce": auth.nonce,
+	}
+	maps.Copy(claims, p.claims)
+
+	json.NewEncoder(w).Encode(map[string]string{
+		"access_token": rand.Text(),
+		"token_type":   "Bearer",
+		"id_token":     p.sign(claims),
+	})
+}
+
+// sign returns a compact JWS of claims with the current key.
+func (p *mockProvider) sign(claims map[string]any) string {
+	header, _ := json.Marshal(map[string]string{"alg": p.alg, "kid": p.kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	switch key := p.signer.(type) {
+	case *rsa.PrivateKey:
+		signature, _ = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	case *ecdsa.PrivateKey:
+		r, s, _ := ecdsa.Sign(rand.Reader, key, digest[:])
+		signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+type oidcIdentitiesMock struct {
+	identities map[string]int64 // By issuer and subject
+}
+
+func (m *oidcIdentitiesMock) GetOIDCIdentity(ctx context.Context, issuer, subject string) (int64, error) {
+	userID, ok := m.identities[issuer+" "+subject]
+	if !ok {
+		return 0, ErrUserNotFound
+	}
+	return userID, nil
+}
+
+func (m *oidcIdentitiesMock) LinkOIDCIdentity(ctx context.Context, userID int64, issuer, subject string, linkedAt time.Time) error {
+	m.identities[issuer+" "+subject] = userID
+	return nil
+}
+
+func newTestOIDC(t *testing.T, p *mockProvider, allowlist ...string) *OIDC {
+	t.Helper()
+
+	a, err := NewAuth(AuthConfig{
+		SecretKey:     "thisisaverylongsecretkeythatisatleast32characterslong",
+		TokenValidity: 60,
+		CookieName:    "testcookie",
+		Users: &usersMock{users: []User{
+			{ID: 1, Username: "writer", Email: "writer@example.com"},
+			{ID: 2, Username: "editor", Email: "editor@example.org"},
+		}},
+		Sessions: newSessionsMock(),
+	})
+	if err != nil {
+		t.Fatalf("NewAuth() error = %v", err)
+	}
+
+	o, err := NewOIDC(OIDCConfig{
+		Auth:         a,
+		Identities:   &oidcIdentitiesMock{identities: map[string]int64{}},
+		Issuer:       p.server.URL,
+		ClientID:     p.clientID,
+		ClientSecret: p.clientSecret,
+		RedirectURL:  "https://blog.example.com/login/oidc/callback",
+		Allowlist:    allowlist,
+		HTTPClient:   p.server.Client(),
+	})
+	if err != nil {
+		t.Fatalf("NewOIDC() error = %v", err)
+	}
+
+	return o
+}
+
+// authorize starts a login and follows the browser to the provider. It
+// returns the state cookie and the query of the callback.
+func authorize(t *testing.T, o *OIDC, remember bool) (string, url.Values) {
+	t.Helper()
+
+	authURL, cookie, err := o.AuthCodeURL(context.Background(), remember)
+	if err != nil {
+		t.Fatalf("AuthCodeURL() error = %v", err)
+	}
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+
+	resp, err := client.Get(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("authorization endpoint status = %d, want %d", resp.StatusCode, http.StatusFound)
+	}
+
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || !strings.HasPrefix(callback.String(), "https://blog.example.com/login/oidc/callback?") {
+		t.Fatalf("redirected to %q, want the callback", resp.Header.Get("Location"))
+	}
+
+	return cookie, callback.Query()
+}
+
+func login(t *testing.T, o *OIDC, remember bool) (User, bool, error) {
+	t.Helper()
+
+	cookie, callback := authorize(t, o, remember)
+	return o.Exchange(context.Background(), cookie, callback.Get("state"), callback.Get("code"))
+}
+
+func TestOIDC(t *testing.T) {
+	for _, alg := range []string{"RS256", "ES256"} {
+		t.Run(alg, func(t *testing.T) {
+			p := newMockProvider(t, alg)
+			o := newTestOIDC(t, p)
+
+			user, remember, err := login(t, o, true)
+			if err != nil || user.Username != "writer" || !remember {
+				t.Fatalf("Exchange() = %v, %v, %v, want writer remembered", user.Username, remember, err)
+			}
+
+			// The subject stays linked when the address changes at the provider
+			p.claims["email"] = "new-address@example.com"
+
+			user, remember, err = login(t, o, false)
+			if err != nil || user.Username != "writer" || remember {
+				t.Fatalf("Exchange() after an address change = %v, %v, %v, want writer", user.Username, remember, err)
+			}
+		})
+	}
+}
+
+func TestOIDC_Rejected(t *testing.T) {
+	tests := []struct {
+		name    string
+		claims  map[string]any
+		wantErr error
+	}{
+		{name: "unverified email", claims: map[string]any{"email_verified": false}, wantErr: ErrOIDCNotAllowed},
+		{name: "no local account", claims: map[string]any{"email": "stranger@example.com"}, wantErr: ErrOIDCNotAllowed},
+		{name: "other audience", claims: map[string]any{"aud": "other-client"}},
+		{name: "several audiences", claims: map[string]any{"aud": []string{"blogo", "other-client"}, "azp": "other-client"}},
+		{name: "other issuer", claims: map[string]any{"iss": "https://evil.example.com"}},
+		{name: "expired", claims: map[string]any{"exp": time.Now().Add(-time.Hour).Unix()}},
+		{name: "issued in the future", claims: map[string]any{"iat": time.Now().Add(time.Hour).Unix()}},
+		{name: "other nonce", claims: map[string]any{"nonce": "replayed"}},
+		{name: "no subject", claims: map[string]any{"sub": ""}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newMockProvider(t, "RS256")
+			maps.Copy(p.claims, tt.claims)
+			o := newTestOIDC(t, p)
+
+			_, _, err := login(t, o, false)
+			if err == nil {
+				t.Fatalf("Exchange() accepted the login")
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("Exchange() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestOIDC_Allowlist(t *testing.T) {
+	tests := []struct {
+		name      string
+		allowlist []string
+		allowed   bool
+	}{
+		{name: "address", allowlist: []string{"writer@example.com"}, allowed: true},
+		{name: "domain", allowlist: []string{"@example.com"}, allowed: true},
+		{name: "subject", allowlist: []string{"subject-1"}, allowed: true},
+		{name: "other domain", allowlist: []string{"@example.org"}, allowed: false},
+		{name: "suffix without @", allowlist: []string{"@ample.com"}, allowed: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newMockProvider(t, "RS256")
+			o := newTestOIDC(t, p, tt.allowlist...)
+
+			_, _, err := login(t, o, false)
+			if tt.allowed && err != nil {
+				t.Errorf("Exchange() error = %v, want allowed", err)
+			}
+			if !tt.allowed && !errors.Is(err, ErrOIDCNotAllowed) {
+				t.Errorf("Exchange() error = %v, want %v", err, ErrOIDCNotAllowed)
+			}
+		})
+	}
+}
+
+func TestOIDC_State(t *testing.T) {
+	p := newMockProvider(t, "RS256")
+	o := newTestOIDC(t, p)
+	ctx := context.Background()
+
+	cookie, callback := authorize(t, o, false)
+	otherCookie, otherCallback := authorize(t, o, false)
+
+	if _, _, err := o.Exchange(ctx, cookie, otherCallback.Get("state"), callback.Get("code")); !errors.Is(err, ErrOIDCState) {
+		t.Errorf("Exchange() with the state of another login error = %v, want %v", err, ErrOIDCState)
+	}
+
+	if _, _, err := o.Exchange(ctx, cookie+"0", callback.Get("state"), callback.Get("code")); !errors.Is(err, ErrOIDCState) {
+		t.Errorf("Exchange() with a forged cookie error = %v, want %v", err, ErrOIDCState)
+	}
+
+	// The code is bound to the PKCE challenge of its own login
+	if _, _, err := o.Exchange(ctx, otherCookie, otherCallback.Get("state"), callback.Get("code")); err == nil {
+		t.Errorf("Exchange() accepted the code of another login")
+	}
+
+	now := time.Now()
+	o.now = func() time.Time { return now.Add(oidcStateValidity + time.Minute) }
+
+	if _, _, err := o.Exchange(ctx, cookie, callback.Get("state"), callback.Get("code")); !errors.Is(err, ErrOIDCState) {
+		t.Errorf("Exchange() after the state expired error = %v, want %v", err, ErrOIDCState)
+	}
+}
+
+func TestOIDC_KeyRotation(t *testing.T) {
+	p := newMockProvider(t, "RS256")
+	o := newTestOIDC(t, p)
+
+	if _, _, err := login(t, o, false); err != nil {
+		t.Fatalf("Exchange() error = %v", err)
+	}
+
+	p.rotateKey(t, "RS256")
+
+	// Unknown keys are not fetched again right away
+	if _, _, err := login(t, o, false); err == nil {
+		t.Fatalf("Exchange() accepted a key fetched too early")
+	}
+
+	now := time.Now()
+	o.now = func() time.Time { return now.Add(jwksRefreshInterval) }
+
+	if _, _, err := login(t, o, false); err != nil {
+		t.Fatalf("Exchange() after a key rotation error = %v", err)
+	}
+
+	if p.jwksFetches != 2 {
+		t.Errorf("fetched the keys %d times, want 2", p.jwksFetches)
+	}
+}
+
+func TestOIDC_SlowProvider(t *testing.T) {
+	p := newMockProvider(t, "RS256")
+	o := newTestOIDC(t, p)
+
+	if _, _, err := login(t, o, false); err != nil {
+		t.Fatalf("Exchange() error = %v", err)
+	}
+
+	p.rotateKey(t, "RS256")
+
+	now := time.Now()
+	o.now = func() time.Time { return now.Add(jwksRefreshInterval) }
+
+	p.mu.Lock()
+	p.stalled, p.release = make(chan struct{}), make(chan struct{})
+	kid := p.kid
+	p.mu.Unlock()
+
+	fetched := make(chan error)
+	go func() {
+		_, err := o.signingKey(context.Background(), kid)
+		fetched <- err
+	}()
+
+	<-p.stalled
+
+	// Logins can start while the keys are being fetched
+	started := make(chan error)
+	go func() {
+		_, _, err := o.AuthCodeURL(context.Background(), false)
+		started <- err
+	}()
+
+	select {
+	case err := <-started:
+		if err != nil {
+			t.Errorf("AuthCodeURL() error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("AuthCodeURL() waited for the keys to be fetched")
+	}
+
+	close(p.release)
+
+	if err := <-fetched; err != nil {
+		t.Errorf("signingKey() error = %v", err)
+	}
+}
+
+func TestOIDC_ForgedSignature(t *testing.T) {
+	p := newMockProvider(t, "RS256")
+	o := newTestOIDC(t, p)
+
+	if _, _, err := login(t, o, false); err != nil {
+		t.Fatalf("Exchange() error = %v", err)
+	}
+
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","kid":"` + p.kid + `"}`))
+	payload := base64.RawURLEncoding.EncodeToString([]byte(`{"iss":"` + p.server.URL + `","aud":"blogo","sub":"subject-1","exp":9999999999,"nonce":"n"}`))
+
+	for _, token := range []string{
+		header + "." + payload + ".",
+		strings.Replace(p.sign(map[string]any{"iss": p.server.URL, "aud": "blogo", "sub": "subject-1", "exp": 9999999999, "nonce": "n"}), "eyJ", "eyK", 1),
+	} {
+		if _, err := o.verifyIDToken(context.Background(), token, "n"); err == nil {
+			t.Errorf("verifyIDToken() accepted %q", token)
+		}
+	}
+}
+
+func TestNewOIDC(t *testing.T) {
+	p := newMockProvider(t, "RS256")
+	o := newTestOIDC(t, p)
+
+	config := OIDCConfig{
+		Auth:        o.auth,
+		Identities:  o.identities,
+		Issuer:      p.server.URL,
+		ClientID:    "blogo",
+		RedirectURL: "https://blog.example.com/login/oidc/callback",
+	}
+
+	if _, err := NewOIDC(config); err != nil {
+		t.Fatalf("NewOIDC() error = %v", err)
+	}
+
+	for _, issuer := range []string{"", "id.example.com", "ftp://id.example.com"} {
+		invalid := config
+		invalid.Issuer = issuer
+		if _, err := NewOIDC(invalid); err == nil {
+			t.Errorf("NewOIDC() accepted the issuer %q", issuer)
+		}
+	}
+
+	invalid := config
+	invalid.RedirectURL = "/login/oidc/callback"
+	if _, err := NewOIDC(invalid); err == nil {
+		t.Errorf("NewOIDC() accepted a relative redirect URL")
+	}
+
+	// The discovery document must name the configured issuer
+	mismatched := config
+	mismatched.Issuer = strings.Replace(p.server.URL, "127.0.0.1", "localhost", 1)
+	o, err := NewOIDC(mismatched)
+	if err != nil {
+		t.Fatalf("NewOIDC() error = %v", err)
+	}
+	if _, _, err := o.AuthCodeURL(context.Background(), false); err == nil {
+		t.Errorf("AuthCodeURL() accepted the discovery document of another issuer")
+	}
+}
@@ -0,0 +1,135 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrInvalidResetToken is returned for password reset links that are forged,
+// expired, or already used.
+var ErrInvalidResetToken = errors.New("invalid or expired password reset link")
+
+// ErrPasswordUnchanged is returned when the new password is the current one.
+var ErrPasswordUnchanged = errors.New("the new password must be different")
+
+// PasswordResetValidity is how long a password reset link works.
+const PasswordResetValidity = 24 * time.Hour
+
+const passwordResetPurpose = "reset:"
+
+// ChangePassword sets the password of the user with ID userID after checking
+// their current one, and ends their sessions but the one with ID
+// keepSessionID, so a stolen session stops working. It returns
+// ErrInvalidCredentials if current is wrong.
+func (auth *Auth) ChangePassword(ctx context.Context, userID int64, current, password, keepSessionID string) error {
+	user, err := auth.users.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(current)) != nil {
+		return ErrInvalidCredentials
+	}
+
+	if current == password {
+		return ErrPasswordUnchanged
+	}
+
+	if err := auth.updatePassword(ctx, user, password); err != nil {
+		return err
+	}
+
+	return auth.RevokeOtherSessions(ctx, user.ID, keepSessionID)
+}
+
+// SetPassword sets the password of user without their current one, for
+// reset links and recovery from the command line, and ends all their
+// sessions.
+func (auth *Auth) SetPassword(ctx context.Context, user User, password string) error {
+	if err := auth.updatePassword(ctx, user, password); err != nil {
+		return err
+	}
+
+	return auth.RevokeUserSessions(ctx, user.ID)
+}
+
+func (auth *Auth) updatePassword(ctx context.Context, user User, password string) error {
+	if err := ValidateUser(user.Username, password); err != nil {
+		return err
+	}
+
+	hash, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	return auth.users.UpdatePassword(ctx, user.ID, hash)
+}
+
+// RevokeOtherSessions ends the sessions of the user with ID userID but the
+// one with ID keepSessionID.
+func (auth *Auth) RevokeOtherSessions(ctx context.Context, userID int64, keepSessionID string) error {
+	return auth.sessions.DeleteUserSessionsExcept(ctx, userID, keepSessionID)
+}
+
+// PasswordResetToken returns a token that sets a new password for user
+// without the current one, valid for PasswordResetValidity. It carries a
+// fingerprint of the current password hash, so it stops working once the
+// password changes, which makes it single use.
+func (auth *Auth) PasswordResetToken(user User) string {
+	expiry := time.Now().Add(PasswordResetValidity).Unix()
+	return auth.sealToken(passwordResetPurpose, fmt.Sprintf("%d:%d:%s", user.ID, expiry, passwordFingerprint(user.PasswordHash)))
+}
+
+// UserFromPasswordReset returns the user a token from PasswordResetToken was
+// issued to. It returns ErrInvalidResetToken if the token is not valid
+// anymore.
+func (auth *Auth) UserFromPasswordReset(ctx context.Context, token string) (User, error) {
+	data, err := auth.openToken(passwordResetPurpose, token)
+	if err != nil {
+		return User{}, ErrInvalidResetToken
+	}
+
+	parts := strings.Split(data, ":")
+	if len(parts) != 3 {
+		return User{}, ErrInvalidResetToken
+	}
+
+	userID, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return User{}, ErrInvalidResetToken
+	}
+
+	expiry, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || time.Now().Unix() > expiry {
+		return User{}, ErrInvalidResetToken
+	}
+
+	user, err := auth.users.GetUserByID(ctx, userID)
+	if errors.Is(err, ErrUserNotFound) {
+		return User{}, ErrInvalidResetToken
+	}
+	if err != nil {
+		return User{}, err
+	}
+
+	if parts[2] != passwordFingerprint(user.PasswordHash) {
+		return User{}, ErrInvalidResetToken
+	}
+
+	return user, nil
+}
+
+// passwordFingerprint identifies a password hash without revealing it.
+func passwordFingerprint(hash string) string {
+	sum := sha256.Sum256([]byte(hash))
+	return hex.EncodeToString(sum[:8])
+}
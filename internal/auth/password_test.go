@@ -0,0 +1,122 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func newPasswordTestAuth(t *testing.T) (*Auth, *sessionsMock) {
+	t.Helper()
+
+	sessions := newSessionsMock()
+
+	a, err := NewAuth(AuthConfig{
+		Username:      "admin",
+		Password:      "adminpassword",
+		SecretKey:     "thisisaverylongsecretkeythatisatleast32characterslong",
+		TokenValidity: 60,
+		CookieName:    "testcookie",
+		Users:         &usersMock{},
+		Sessions:      sessions,
+	})
+	if err != nil {
+		t.Fatalf("NewAuth() error = %v", err)
+	}
+
+	if _, err := a.CreateInitialAdmin(context.Background()); err != nil {
+		t.Fatalf("CreateInitialAdmin() error = %v", err)
+	}
+
+	return a, sessions
+}
+
+func TestAuth_ChangePassword(t *testing.T) {
+	ctx := context.Background()
+	a, _ := newPasswordTestAuth(t)
+
+	admin, _ := a.ValidateCredentials(ctx, "admin", "adminpassword")
+
+	current, _, err := a.CreateSession(ctx, admin, false, "192.0.2.1", "test agent")
+	if err != nil {
+		t.Fatalf("CreateSession() error = %v", err)
+	}
+	other, _, err := a.CreateSession(ctx, admin, false, "192.0.2.2", "test agent")
+	if err != nil {
+		t.Fatalf("CreateSession() error = %v", err)
+	}
+	currentID, _ := a.ParseToken(current)
+
+	if err := a.ChangePassword(ctx, admin.ID, "wrongpassword", "newpassword", currentID); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("ChangePassword() with a wrong password error = %v, want %v", err, ErrInvalidCredentials)
+	}
+
+	if err := a.ChangePassword(ctx, admin.ID, "adminpassword", "short", currentID); err == nil {
+		t.Errorf("ChangePassword() accepted a short password")
+	}
+
+	if valid, _ := a.ValidateToken(ctx, other); !valid {
+		t.Fatalf("ChangePassword() ended the sessions after failing")
+	}
+
+	if err := a.ChangePassword(ctx, admin.ID, "adminpassword", "newpassword", currentID); err != nil {
+		t.Fatalf("ChangePassword() error = %v", err)
+	}
+
+	if _, err := a.ValidateCredentials(ctx, "admin", "newpassword"); err != nil {
+		t.Errorf("ValidateCredentials() with the new password error = %v", err)
+	}
+	if _, err := a.ValidateCredentials(ctx, "admin", "adminpassword"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("ValidateCredentials() with the old password error = %v, want %v", err, ErrInvalidCredentials)
+	}
+
+	if valid, _ := a.ValidateToken(ctx, current); !valid {
+		t.Errorf("ChangePassword() ended the session it was changed from")
+	}
+	if valid, _ := a.ValidateToken(ctx, other); valid {
+		t.Errorf("ChangePassword() kept the other sessions")
+	}
+}
+
+func TestAuth_PasswordReset(t *testing.T) {
+	ctx := context.Background()
+	a, _ := newPasswordTestAuth(t)
+
+	admin, _ := a.ValidateCredentials(ctx, "admin", "adminpassword")
+
+	session, _, err := a.CreateSession(ctx, admin, false, "192.0.2.1", "test agent")
+	if err != nil {
+		t.Fatalf("CreateSession() error = %v", err)
+	}
+
+	token := a.PasswordResetToken(admin)
+
+	user, err := a.UserFromPasswordReset(ctx, token)
+	if err != nil || user.ID != admin.ID {
+		t.Fatalf("UserFromPasswordReset() = %+v, %v, want the admin", user, err)
+	}
+
+	if _, err := a.UserFromPasswordReset(ctx, token+"x"); !errors.Is(err, ErrInvalidResetToken) {
+		t.Errorf("UserFromPasswordReset() with a changed token error = %v, want %v", err, ErrInvalidResetToken)
+	}
+
+	if _, err := a.UserFromPasswordReset(ctx, a.GenerateChallenge(admin.ID, false, 1<<40)); !errors.Is(err, ErrInvalidResetToken) {
+		t.Errorf("UserFromPasswordReset() accepted a token issued for another purpose")
+	}
+
+	if err := a.SetPassword(ctx, user, "resetpassword"); err != nil {
+		t.Fatalf("SetPassword() error = %v", err)
+	}
+
+	if _, err := a.ValidateCredentials(ctx, "admin", "resetpassword"); err != nil {
+		t.Errorf("ValidateCredentials() with the reset password error = %v", err)
+	}
+
+	if valid, _ := a.ValidateToken(ctx, session); valid {
+		t.Errorf("SetPassword() kept the sessions of the user")
+	}
+
+	if _, err := a.UserFromPasswordReset(ctx, token); !errors.Is(err, ErrInvalidResetToken) {
+		t.Errorf("UserFromPasswordReset() accepted a used token, error = %v", err)
+	}
+}
@@ -0,0 +1,232 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ErrSessionNotFound is returned by Sessions when no session has the ID, and
+// when validating a token of a session that was revoked or expired.
+var ErrSessionNotFound = errors.New("session not found")
+
+// touchInterval is how often the last seen time of a session is updated, so
+// every request does not write to the database.
+const touchInterval = time.Minute
+
+// Session is a signed in browser.
+type Session struct {
+	ID         string
+	UserID     int64
+	Username   string // Only set by ListSessions
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+	ExpiresAt  time.Time
+	IP         string
+	UserAgent  string
+	Remember   bool // Whether the session uses the "remember me" validity
+}
+
+// Sessions stores the signed in sessions, so they can be revoked before they expire.
+type Sessions interface {
+	CreateSession(ctx context.Context, session Session) error
+	GetSession(ctx context.Context, id string) (Session, error)
+	TouchSession(ctx context.Context, id string, lastSeenAt time.Time) error
+	RenewSession(ctx context.Context, id string, expiresAt time.Time) error
+	// ListSessions returns the sessions that have not expired at now, the
+	// most recently seen first.
+	ListSessions(ctx context.Context, now time.Time) ([]Session, error)
+	DeleteSession(ctx context.Context, id string) error
+	DeleteUserSessions(ctx context.Context, userID int64) error
+	// DeleteUserSessionsExcept deletes the sessions of the user with ID
+	// userID but the one with ID keepID.
+	DeleteUserSessionsExcept(ctx context.Context, userID int64, keepID string) error
+	DeleteExpiredSessions(ctx context.Context, now time.Time) error
+}
+
+// CreateSession starts a session for user, signed in from ip with userAgent,
+// and returns its token and expiry. Sessions with remember set last for the
+// "remember me" validity instead of the token validity.
+func (auth *Auth) CreateSession(ctx context.Context, user User, remember bool, ip, userAgent string) (string, time.Time, error) {
+	now := time.Now()
+	expiry := now.Add(auth.validity(remember))
+
+	if err := auth.sessions.DeleteExpiredSessions(ctx, now); err != nil {
+		return "", time.Time{}, err
+	}
+
+	session := Session{
+		ID:         rand.Text(),
+		UserID:     user.ID,
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  expiry,
+		IP:         ip,
+		UserAgent:  userAgent,
+		Remember:   remember,
+	}
+
+	if err := auth.sessions.CreateSession(ctx, session); err != nil {
+		return "", time.Time{}, err
+	}
+
+	return auth.sessionToken(session.ID), expiry, nil
+}
+
+// validity returns how long a session lasts without being renewed.
+func (auth *Auth) validity(remember bool) time.Duration {
+	if remember {
+		return time.Duration(auth.rememberValidity) * time.Second
+	}
+	return time.Duration(auth.tokenValidity) * time.Second
+}
+
+// sessionToken returns the token of a session, sealed with its ID.
+func (auth *Auth) sessionToken(sessionID string) string {
+	return auth.sealToken(sessionPurpose, sessionID)
+}
+
+const sessionPurpose = "session:"
+
+// ParseToken checks the signature of a session token and returns the ID of
+// its session. It does not check that the session still exists.
+func (auth *Auth) ParseToken(token string) (string, error) {
+	sessionID, err := auth.openToken(sessionPurpose, token)
+	if err != nil {
+		return "", err
+	}
+
+	if sessionID == "" || strings.Contains(sessionID, ":") {
+		return "", fmt.Errorf("invalid Token")
+	}
+
+	return sessionID, nil
+}
+
+// Session returns the session of a token. It returns ErrSessionNotFound when
+// the session was revoked or has expired.
+func (auth *Auth) Session(ctx context.Context, token string) (Session, error) {
+	sessionID, err := auth.ParseToken(token)
+	if err != nil {
+		return Session{}, err
+	}
+
+	session, err := auth.sessions.GetSession(ctx, sessionID)
+	if err != nil {
+		return Session{}, err
+	}
+
+	now := time.Now()
+	if !now.Before(session.ExpiresAt) {
+		return Session{}, ErrSessionNotFound
+	}
+
+	if now.Sub(session.LastSeenAt) >= touchInterval {
+		if err := auth.sessions.TouchSession(ctx, session.ID, now); err != nil {
+			return Session{}, err
+		}
+		session.LastSeenAt = now
+	}
+
+	return session, nil
+}
+
+func (auth *Auth) ValidateToken(ctx context.Context, token string) (bool, error) {
+	if _, err := auth.Session(ctx, token); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// UserFromToken validates a token and returns the user it was issued to.
+func (auth *Auth) UserFromToken(ctx context.Context, token string) (User, error) {
+	session, err := auth.Session(ctx, token)
+	if err != nil {
+		return User{}, err
+	}
+
+	return auth.users.GetUserByID(ctx, session.UserID)
+}
+
+// RenewSession extends the session of a token once it is past half its
+// validity, so active users are not signed out. It returns the new expiry and
+// whether the session was renewed, in which case the cookie must be reissued.
+func (auth *Auth) RenewSession(ctx context.Context, token string) (time.Time, bool, error) {
+	session, err := auth.Session(ctx, token)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+
+	validity := auth.validity(session.Remember)
+	if time.Until(session.ExpiresAt) > validity/2 {
+		return session.ExpiresAt, false, nil
+	}
+
+	expiry := time.Now().Add(validity)
+	if err := auth.sessions.RenewSession(ctx, session.ID, expiry); err != nil {
+		return time.Time{}, false, err
+	}
+
+	return expiry, true, nil
+}
+
+// RevokeToken ends the session of a token, so it cannot be used again even
+// if the cookie was copied.
+func (auth *Auth) RevokeToken(ctx context.Context, token string) error {
+	sessionID, err := auth.ParseToken(token)
+	if err != nil {
+		return err
+	}
+
+	return auth.sessions.DeleteSession(ctx, sessionID)
+}
+
+// RevokeSession ends the session with the given ID.
+func (auth *Auth) RevokeSession(ctx context.Context, sessionID string) error {
+	return auth.sessions.DeleteSession(ctx, sessionID)
+}
+
+// RevokeUserSessions ends every session of a user, signing them out everywhere.
+func (auth *Auth) RevokeUserSessions(ctx context.Context, userID int64) error {
+	return auth.sessions.DeleteUserSessions(ctx, userID)
+}
+
+// ActiveSessions returns the sessions that have not expired, the most
+// recently seen first.
+func (auth *Auth) ActiveSessions(ctx context.Context) ([]Session, error) {
+	return auth.sessions.ListSessions(ctx, time.Now())
+}
+
+// SessionCookie returns the cookie carrying a session token until expiry,
+// with the configured cookie options.
+func (auth *Auth) SessionCookie(token string, expiry time.Time) *http.Cookie {
+	return &http.Cookie{
+		Name:     auth.cookieName,
+		Value:    token,
+		Path:     auth.cookiePath,
+		Domain:   auth.cookieDomain,
+		Expires:  expiry,
+		Secure:   auth.cookieSecure,
+		HttpOnly: true,
+		SameSite: auth.cookieSameSite,
+	}
+}
+
+// ExpiredSessionCookie returns a cookie that makes the browser delete the
+// session cookie.
+func (auth *Auth) ExpiredSessionCookie() *http.Cookie {
+	cookie := auth.SessionCookie("", time.Time{})
+	cookie.MaxAge = -1
+	return cookie
+}
+
+// SecureCookies reports whether cookies must only be sent over HTTPS, for
+// the other cookies set along the session cookie.
+func (auth *Auth) SecureCookies() bool {
+	return auth.cookieSecure
+}
@@ -0,0 +1,307 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ErrInvalidCode is returned when a TOTP or recovery code is wrong.
+var ErrInvalidCode = errors.New("invalid code")
+
+const (
+	totpPeriod = 30 // Seconds each code is valid for
+	totpDigits = 6
+	totpSkew   = 1 // Periods before and after the current one that are accepted
+
+	recoveryCodeCount = 10
+)
+
+var base32NoPadding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateTOTPSecret returns a random 160 bit secret, encoded in base32 as
+// authenticator apps expect.
+func GenerateTOTPSecret() (string, error) {
+	secret := make([]byte, 20)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return base32NoPadding.EncodeToString(secret), nil
+}
+
+// TOTPCode returns the RFC 6238 code of secret for the period containing t,
+// using HMAC-SHA1, 6 digits and 30 second periods.
+func TOTPCode(secret string, t time.Time) (string, error) {
+	return totpCode(secret, totpCounter(t))
+}
+
+func totpCounter(t time.Time) int64 {
+	return t.Unix() / totpPeriod
+}
+
+func totpCode(secret string, counter int64) (string, error) {
+	key, err := base32NoPadding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if err != nil {
+		return "", fmt.Errorf("invalid TOTP secret: %w", err)
+	}
+
+	var message [8]byte
+	binary.BigEndian.PutUint64(message[:], uint64(counter))
+
+	h := hmac.New(sha1.New, key)
+	h.Write(message[:])
+	sum := h.Sum(nil)
+
+	// Dynamic truncation, RFC 4226 section 5.3
+	offset := sum[len(sum)-1] & 0x0f
+	code := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", totpDigits, code%1_000_000), nil
+}
+
+// ValidateTOTP checks code against the periods around t and returns the
+// counter of the matching period. Codes of a period at or before lastCounter
+// are rejected, so a code cannot be used twice.
+func ValidateTOTP(secret, code string, t time.Time, lastCounter int64) (int64, error) {
+	code = strings.TrimSpace(code)
+	if len(code) != totpDigits {
+		return 0, ErrInvalidCode
+	}
+
+	current := totpCounter(t)
+	for counter := current - totpSkew; counter <= current+totpSkew; counter++ {
+		if counter <= lastCounter {
+			continue
+		}
+
+		expected, err := totpCode(secret, counter)
+		if err != nil {
+			return 0, err
+		}
+
+		if hmac.Equal([]byte(expected), []byte(code)) {
+			return counter, nil
+		}
+	}
+
+	return 0, ErrInvalidCode
+}
+
+// TOTPURI returns the otpauth:// URI that authenticator apps scan to add an account.
+func TOTPURI(issuer, username, secret string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(totpDigits))
+	query.Set("period", fmt.Sprint(totpPeriod))
+
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(username)
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// TOTP is the two-factor state of a user. The secret is kept while enrolling,
+// before Enabled is set.
+type TOTP struct {
+	Secret      string
+	Enabled     bool
+	LastCounter int64 // Counter of the last accepted code
+}
+
+// TwoFactorStore persists the TOTP state and the hashed recovery codes of the users.
+type TwoFactorStore interface {
+	GetTOTP(ctx context.Context, userID int64) (TOTP, error)
+	SaveTOTP(ctx context.Context, userID int64, totp TOTP) error
+	ReplaceRecoveryCodes(ctx context.Context, userID int64, hashes []string) error
+	// UseRecoveryCode marks the unused code with the given hash as used and
+	// reports whether there was one.
+	UseRecoveryCode(ctx context.Context, userID int64, hash string) (bool, error)
+}
+
+type TwoFactorConfig struct {
+	Store  TwoFactorStore // Required
+	Issuer string         // Name shown by authenticator apps, like the blog name
+}
+
+// TwoFactor enrolls users in TOTP two-factor authentication and verifies
+// their codes.
+type TwoFactor struct {
+	store  TwoFactorStore
+	issuer string
+	now    func() time.Time
+}
+
+func NewTwoFactor(config TwoFactorConfig) (*TwoFactor, error) {
+	if config.Store == nil {
+		return nil, errors.New("a two-factor store is required")
+	}
+
+	if config.Issuer == "" {
+		config.Issuer = "Blogo"
+	}
+
+	return &TwoFactor{
+		store:  config.Store,
+		issuer: config.Issuer,
+		now:    time.Now,
+	}, nil
+}
+
+// Enabled reports whether the user has to enter a code when logging in.
+func (tf *TwoFactor) Enabled(ctx context.Context, userID int64) (bool, error) {
+	totp, err := tf.store.GetTOTP(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	return totp.Enabled, nil
+}
+
+// Begin starts the enrollment of a user with a new secret and returns the
+// secret and its otpauth:// URI. Two-factor authentication is only enabled
+// once Confirm receives a valid code. Until then, the secret of the pending
+// enrollment is returned again, so showing it twice does not invalidate the
+// one already added to an authenticator app.
+func (tf *TwoFactor) Begin(ctx context.Context, user User) (string, string, error) {
+	totp, err := tf.store.GetTOTP(ctx, user.ID)
+	if err != nil {
+		return "", "", err
+	}
+	if totp.Enabled {
+		return "", "", errors.New("two-factor authentication is already enabled")
+	}
+
+	if totp.Secret != "" {
+		return totp.Secret, TOTPURI(tf.issuer, user.Username, totp.Secret), nil
+	}
+
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		return "", "", err
+	}
+
+	if err := tf.store.SaveTOTP(ctx, user.ID, TOTP{Secret: secret}); err != nil {
+		return "", "", err
+	}
+
+	return secret, TOTPURI(tf.issuer, user.Username, secret), nil
+}
+
+// Confirm enables two-factor authentication when code matches the secret
+// from Begin, and returns new recovery codes. They are only stored hashed,
+// so this is the only time they can be shown.
+func (tf *TwoFactor) Confirm(ctx context.Context, userID int64, code string) ([]string, error) {
+	totp, err := tf.store.GetTOTP(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if totp.Enabled || totp.Secret == "" {
+		return nil, errors.New("no two-factor enrollment in progress")
+	}
+
+	counter, err := ValidateTOTP(totp.Secret, code, tf.now(), totp.LastCounter)
+	if err != nil {
+		return nil, err
+	}
+
+	codes, err := tf.RegenerateRecoveryCodes(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	totp.Enabled = true
+	totp.LastCounter = counter
+	if err := tf.store.SaveTOTP(ctx, userID, totp); err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// Verify checks a TOTP code, or an unused recovery code, of a user with
+// two-factor authentication enabled. It returns ErrInvalidCode when neither matches.
+func (tf *TwoFactor) Verify(ctx context.Context, userID int64, code string) error {
+	totp, err := tf.store.GetTOTP(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if !totp.Enabled {
+		return ErrInvalidCode
+	}
+
+	counter, err := ValidateTOTP(totp.Secret, code, tf.now(), totp.LastCounter)
+	if err == nil {
+		totp.LastCounter = counter
+		return tf.store.SaveTOTP(ctx, userID, totp)
+	}
+	if !errors.Is(err, ErrInvalidCode) {
+		return err
+	}
+
+	used, err := tf.store.UseRecoveryCode(ctx, userID, hashRecoveryCode(code))
+	if err != nil {
+		return err
+	}
+	if !used {
+		return ErrInvalidCode
+	}
+
+	return nil
+}
+
+// Disable turns two-factor authentication off and deletes the recovery codes.
+func (tf *TwoFactor) Disable(ctx context.Context, userID int64) error {
+	if err := tf.store.ReplaceRecoveryCodes(ctx, userID, nil); err != nil {
+		return err
+	}
+	return tf.store.SaveTOTP(ctx, userID, TOTP{})
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of a user with new ones.
+func (tf *TwoFactor) RegenerateRecoveryCodes(ctx context.Context, userID int64) ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = code
+		hashes[i] = hashRecoveryCode(code)
+	}
+
+	if err := tf.store.ReplaceRecoveryCodes(ctx, userID, hashes); err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// generateRecoveryCode returns a random code like "7KQ2M-XD4PA-9ZC3T", 75
+// bits of entropy.
+func generateRecoveryCode() (string, error) {
+	random := make([]byte, 10)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+
+	code := base32NoPadding.EncodeToString(random)[:15]
+	return code[0:5] + "-" + code[5:10] + "-" + code[10:15], nil
+}
+
+// hashRecoveryCode hashes a code for storage. Recovery codes are random, so a
+// fast hash is enough, unlike passwords.
+func hashRecoveryCode(code string) string {
+	code = strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,216 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The SHA1 test vectors of RFC 6238 appendix B, truncated to 6 digits.
+func TestTOTPCode(t *testing.T) {
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ" // "12345678901234567890"
+
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{unix: 59, want: "287082"},
+		{unix: 1111111109, want: "081804"},
+		{unix: 1111111111, want: "050471"},
+		{unix: 1234567890, want: "005924"},
+		{unix: 2000000000, want: "279037"},
+		{unix: 20000000000, want: "353130"},
+	}
+
+	for _, tt := range tests {
+		got, err := TOTPCode(secret, time.Unix(tt.unix, 0))
+		if err != nil {
+			t.Fatalf("TOTPCode() error = %v", err)
+		}
+		if got != tt.want {
+			t.Errorf("TOTPCode(%d) = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	now := time.Unix(1111111111, 0)
+
+	previous, _ := TOTPCode(secret, now.Add(-30*time.Second))
+	old, _ := TOTPCode(secret, now.Add(-90*time.Second))
+
+	counter, err := ValidateTOTP(secret, previous, now, 0)
+	if err != nil {
+		t.Errorf("ValidateTOTP() rejected the code of the previous period: %v", err)
+	}
+
+	if _, err := ValidateTOTP(secret, previous, now, counter); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("ValidateTOTP() accepted a code twice")
+	}
+
+	if _, err := ValidateTOTP(secret, old, now, 0); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("ValidateTOTP() accepted a code three periods old")
+	}
+
+	if _, err := ValidateTOTP(secret, "12345", now, 0); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("ValidateTOTP() accepted a code with 5 digits")
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	got := TOTPURI("My Blog", "admin", "ABC")
+	if !strings.HasPrefix(got, "otpauth://totp/My%20Blog:admin?") || !strings.Contains(got, "secret=ABC") {
+		t.Errorf("TOTPURI() = %s", got)
+	}
+}
+
+type twoFactorStoreMock struct {
+	totp  map[int64]TOTP
+	codes map[int64][]string
+}
+
+func (m *twoFactorStoreMock) GetTOTP(ctx context.Context, userID int64) (TOTP, error) {
+	return m.totp[userID], nil
+}
+
+func (m *twoFactorStoreMock) SaveTOTP(ctx context.Context, userID int64, totp TOTP) error {
+	m.totp[userID] = totp
+	return nil
+}
+
+func (m *twoFactorStoreMock) ReplaceRecoveryCodes(ctx context.Context, userID int64, hashes []string) error {
+	m.codes[userID] = hashes
+	return nil
+}
+
+func (m *twoFactorStoreMock) UseRecoveryCode(ctx context.Context, userID int64, hash string) (bool, error) {
+	for i, h := range m.codes[userID] {
+		if h == hash {
+			m.codes[userID] = append(m.codes[userID][:i], m.codes[userID][i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func TestTwoFactor(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	store := &twoFactorStoreMock{totp: map[int64]TOTP{}, codes: map[int64][]string{}}
+	tf, err := NewTwoFactor(TwoFactorConfig{Store: store, Issuer: "Blog"})
+	if err != nil {
+		t.Fatalf("NewTwoFactor() error = %v", err)
+	}
+	tf.now = func() time.Time { return now }
+
+	user := User{ID: 1, Username: "admin"}
+
+	secret, uri, err := tf.Begin(ctx, user)
+	if err != nil {
+		t.Fatalf("Begin() error = %v", err)
+	}
+	if !strings.Contains(uri, "secret="+secret) {
+		t.Errorf("Begin() uri = %s, want the secret %s", uri, secret)
+	}
+
+	// Showing the enrollment again keeps the secret the app may have scanned
+	if again, _, err := tf.Begin(ctx, user); err != nil || again != secret {
+		t.Errorf("Begin() again = %q, %v, want the pending secret %q", again, err, secret)
+	}
+
+	if enabled, _ := tf.Enabled(ctx, user.ID); enabled {
+		t.Errorf("Enabled() = true before confirming")
+	}
+
+	if _, err := tf.Confirm(ctx, user.ID, "000000"); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("Confirm() with a wrong code error = %v, want %v", err, ErrInvalidCode)
+	}
+
+	code, _ := TOTPCode(secret, now)
+	recoveryCodes, err := tf.Confirm(ctx, user.ID, code)
+	if err != nil {
+		t.Fatalf("Confirm() error = %v", err)
+	}
+	if len(recoveryCodes) != recoveryCodeCount {
+		t.Errorf("Confirm() returned %d recovery codes, want %d", len(recoveryCodes), recoveryCodeCount)
+	}
+	for _, hash := range store.codes[user.ID] {
+		for _, code := range recoveryCodes {
+			if strings.Contains(hash, code) {
+				t.Fatalf("recovery code %s is stored in plain text", code)
+			}
+		}
+	}
+
+	if enabled, _ := tf.Enabled(ctx, user.ID); !enabled {
+		t.Errorf("Enabled() = false after confirming")
+	}
+
+	// The code used to confirm cannot be used again
+	if err := tf.Verify(ctx, user.ID, code); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("Verify() with the confirmation code error = %v, want %v", err, ErrInvalidCode)
+	}
+
+	now = now.Add(30 * time.Second)
+	code, _ = TOTPCode(secret, now)
+	if err := tf.Verify(ctx, user.ID, code); err != nil {
+		t.Errorf("Verify() error = %v", err)
+	}
+
+	recovery := strings.ToLower(recoveryCodes[0])
+	if err := tf.Verify(ctx, user.ID, recovery); err != nil {
+		t.Errorf("Verify() with a recovery code error = %v", err)
+	}
+	if err := tf.Verify(ctx, user.ID, recovery); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("Verify() accepted a recovery code twice")
+	}
+
+	if err := tf.Disable(ctx, user.ID); err != nil {
+		t.Fatalf("Disable() error = %v", err)
+	}
+	if enabled, _ := tf.Enabled(ctx, user.ID); enabled {
+		t.Errorf("Enabled() = true after disabling")
+	}
+}
+
+func TestAuth_Challenge(t *testing.T) {
+	a, err := NewAuth(AuthConfig{
+		SecretKey:     "thisisaverylongsecretkeythatisatleast32characterslong",
+		TokenValidity: 60,
+		CookieName:    "testcookie",
+		Users:         &usersMock{},
+		Sessions:      newSessionsMock(),
+	})
+	if err != nil {
+		t.Fatalf("NewAuth() error = %v", err)
+	}
+
+	expiry := time.Now().Add(time.Minute).Unix()
+
+	challenge := a.GenerateChallenge(7, false, expiry)
+	if userID, remember, err := a.ParseChallenge(challenge); err != nil || userID != 7 || remember {
+		t.Errorf("ParseChallenge() = %d, %v, %v, want 7, false", userID, remember, err)
+	}
+
+	remembered := a.GenerateChallenge(7, true, expiry)
+	if userID, remember, err := a.ParseChallenge(remembered); err != nil || userID != 7 || !remember {
+		t.Errorf("ParseChallenge() = %d, %v, %v, want 7, true", userID, remember, err)
+	}
+
+	if _, err := a.ParseToken(challenge); err == nil {
+		t.Errorf("ParseToken() accepted a challenge as a session token")
+	}
+
+	token, _, err := a.CreateSession(context.Background(), User{ID: 7}, false, "192.0.2.1", "test agent")
+	if err != nil {
+		t.Fatalf("CreateSession() error = %v", err)
+	}
+
+	if _, _, err := a.ParseChallenge(token); err == nil {
+		t.Errorf("ParseChallenge() accepted a session token")
+	}
+}
@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/luizgustavojunqueira/Blogo/internal/auth"
+	"github.com/luizgustavojunqueira/Blogo/internal/repository"
+	"github.com/luizgustavojunqueira/Blogo/internal/templates/pages"
+)
+
+type APITokenRepository interface {
+	CreateAPIToken(ctx context.Context, arg repository.CreateAPITokenParams) (repository.APIToken, error)
+	GetAPITokenByHash(ctx context.Context, tokenHash string) (repository.APIToken, error)
+	GetUserAPITokens(ctx context.Context, userID int64) ([]repository.APIToken, error)
+	TouchAPIToken(ctx context.Context, arg repository.TouchAPITokenParams) error
+	DeleteAPIToken(ctx context.Context, arg repository.DeleteAPITokenParams) (int64, error)
+}
+
+type apiTokenStore struct {
+	repo     APITokenRepository
+	location *time.Location
+}
+
+// NewAPITokenStore returns an auth.APITokens that keeps the tokens in the
+// api_tokens table, with their scopes comma separated.
+func NewAPITokenStore(repo APITokenRepository, location *time.Location) auth.APITokens {
+	return &apiTokenStore{repo: repo, location: location}
+}
+
+func toAuthAPIToken(token repository.APIToken) auth.APIToken {
+	return auth.APIToken{
+		ID:         token.ID,
+		UserID:     token.UserID,
+		Name:       token.Name,
+		Scopes:     strings.Split(token.Scopes, ","),
+		CreatedAt:  token.CreatedAt,
+		LastUsedAt: token.LastUsedAt.Time,
+	}
+}
+
+func (s *apiTokenStore) CreateAPIToken(ctx context.Context, token auth.APIToken, hash string) (auth.APIToken, error) {
+	created, err := s.repo.CreateAPIToken(ctx, repository.CreateAPITokenParams{
+		UserID:    token.UserID,
+		Name:      token.Name,
+		TokenHash: hash,
+		Scopes:    strings.Join(token.Scopes, ","),
+		CreatedAt: token.CreatedAt.In(s.location),
+	})
+	if err != nil {
+		return auth.APIToken{}, err
+	}
+
+	return toAuthAPIToken(created), nil
+}
+
+func (s *apiTokenStore) GetAPITokenByHash(ctx context.Context, hash string) (auth.APIToken, error) {
+	token, err := s.repo.GetAPITokenByHash(ctx, hash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return auth.APIToken{}, auth.ErrAPITokenNotFound
+	}
+	if err != nil {
+		return auth.APIToken{}, err
+	}
+
+	return toAuthAPIToken(token), nil
+}
+
+func (s *apiTokenStore) TouchAPIToken(ctx context.Context, id int64, lastUsedAt time.Time) error {
+	return s.repo.TouchAPIToken(ctx, repository.TouchAPITokenParams{
+		LastUsedAt: sql.NullTime{Time: lastUsedAt.In(s.location), Valid: true},
+		ID:         id,
+	})
+}
+
+func (s *apiTokenStore) ListAPITokens(ctx context.Context, userID int64) ([]auth.APIToken, error) {
+	rows, err := s.repo.GetUserAPITokens(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := make([]auth.APIToken, 0, len(rows))
+	for _, row := range rows {
+		tokens = append(tokens, toAuthAPIToken(row))
+	}
+
+	return tokens, nil
+}
+
+func (s *apiTokenStore) DeleteAPIToken(ctx context.Context, userID, id int64) error {
+	rows, err := s.repo.DeleteAPIToken(ctx, repository.DeleteAPITokenParams{ID: id, UserID: userID})
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return auth.ErrAPITokenNotFound
+	}
+
+	return nil
+}
+
+type APITokensHandler struct {
+	auth      *auth.Auth
+	audit     *Auditor
+	logger    *log.Logger
+	blogName  string
+	pagetitle string
+}
+
+func NewAPITokensHandler(auth *auth.Auth, audit *Auditor, logger *log.Logger, blogName, pagetitle string) *APITokensHandler {
+	return &APITokensHandler{
+		auth:      auth,
+		audit:     audit,
+		logger:    logger,
+		blogName:  blogName,
+		pagetitle: pagetitle,
+	}
+}
+
+// List shows the API tokens of the signed in user, with the form to create one.
+func (h *APITokensHandler) List(w http.ResponseWriter, r *http.Request) {
+	user, _ := requestUser(r)
+
+	ctx := r.Context()
+
+	tokens, err := h.auth.APITokens(ctx, user.ID)
+	if err != nil {
+		h.logger.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	page := pages.Root(h.blogName, pages.APITokensPage(h.blogName, h.pagetitle, tokens, auth.Scopes))
+	page.Render(ctx, w)
+}
+
+// Create adds a token with the name and scopes of the form and answers with
+// the token, which is only shown this once.
+func (h *APITokensHandler) Create(w http.ResponseWriter, r *http.Request) {
+	user, _ := requestUser(r)
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	token, created, err := h.auth.CreateAPIToken(r.Context(), user, r.FormValue("name"), r.Form["scopes"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	h.logger.Printf("Created the API token %q for %s\n", created.Name, user.Username)
+	h.audit.Record(r, user, AuditAPITokenCreate, "token:"+strconv.FormatInt(created.ID, 10), nil, apiTokenSummary{Name: created.Name, Scopes: created.Scopes})
+
+	pages.NewAPIToken(token).Render(r.Context(), w)
+}
+
+// Delete revokes a token of the signed in user.
+func (h *APITokensHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	user, _ := requestUser(r)
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid token id", http.StatusBadRequest)
+		return
+	}
+
+	err = h.auth.RevokeAPIToken(r.Context(), user.ID, id)
+	if errors.Is(err, auth.ErrAPITokenNotFound) {
+		http.Error(w, "Token not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		h.logger.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.logger.Printf("Revoked an API token of %s\n", user.Username)
+	h.audit.Record(r, user, AuditAPITokenRevoke, "token:"+strconv.FormatInt(id, 10), nil, nil)
+
+	w.Header().Set("HX-Location", "/account/tokens")
+}
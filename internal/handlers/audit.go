@@ -0,0 +1,299 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/luizgustavojunqueira/Blogo/internal/auth"
+	"github.com/luizgustavojunqueira/Blogo/internal/repository"
+	"github.com/luizgustavojunqueira/Blogo/internal/templates/pages"
+)
+
+// Actions of the audit events, named "<target kind>.<verb>".
+const (
+	AuditPostCreate         = "post.create"
+	AuditPostUpdate         = "post.update"
+	AuditPostDelete         = "post.delete"
+	AuditTagCreate          = "tag.create"
+	AuditLogin              = "auth.login"
+	AuditLoginFailed        = "auth.login_failed"
+	AuditLogout             = "auth.logout"
+	AuditUserCreate         = "user.create"
+	AuditUserRoleUpdate     = "user.role"
+	AuditUserEmailUpdate    = "user.email"
+	AuditUserPasswordUpdate = "user.password"
+	AuditUserPasswordReset  = "user.password_reset"
+	AuditUserResetLink      = "user.password_link"
+	AuditTwoFactorEnable    = "user.2fa_enable"
+	AuditTwoFactorDisable   = "user.2fa_disable"
+	AuditSessionRevoke      = "session.revoke"
+	AuditSessionRevokeUser  = "session.revoke_user"
+	AuditAPITokenCreate     = "token.create"
+	AuditAPITokenRevoke     = "token.revoke"
+	AuditMediaDelete        = "media.delete"
+)
+
+// AuditCategories are the action prefixes the audit log can be filtered by.
+var AuditCategories = []string{"post.", "tag.", "media.", "auth.", "user.", "session.", "token."}
+
+const (
+	auditPageSize   = 200
+	auditExportSize = 100000
+)
+
+type AuditRepository interface {
+	CreateAuditEvent(ctx context.Context, arg repository.CreateAuditEventParams) error
+	GetAuditEvents(ctx context.Context, arg repository.GetAuditEventsParams) ([]repository.AuditEvent, error)
+}
+
+// Auditor records who did what to which post, tag, file or account. A nil
+// Auditor records nothing.
+type Auditor struct {
+	repo           AuditRepository
+	location       *time.Location
+	trustedProxies []netip.Prefix
+	logger         *log.Logger
+}
+
+func NewAuditor(repo AuditRepository, location *time.Location, trustedProxies []netip.Prefix, logger *log.Logger) *Auditor {
+	return &Auditor{
+		repo:           repo,
+		location:       location,
+		trustedProxies: trustedProxies,
+		logger:         logger,
+	}
+}
+
+// Record appends an event for action on target, done by actor from the
+// client of r. before and after summarize the target around the change and
+// are stored as JSON, nil when there is nothing to summarize. actor is the
+// zero User for anonymous requests, like failed logins. The action already
+// happened, so failing to record it is logged rather than returned.
+func (a *Auditor) Record(r *http.Request, actor auth.User, action, target string, before, after any) {
+	if a == nil {
+		return
+	}
+
+	err := a.repo.CreateAuditEvent(r.Context(), repository.CreateAuditEventParams{
+		CreatedAt: time.Now().In(a.location),
+		ActorID:   sql.NullInt64{Int64: actor.ID, Valid: actor.ID != 0},
+		Actor:     actor.Username,
+		Action:    action,
+		Target:    target,
+		Ip:        clientIP(r, a.trustedProxies),
+		Before:    a.summary(before),
+		After:     a.summary(after),
+	})
+	if err != nil {
+		a.logger.Printf("Error recording the audit event %s on %s: %v\n", action, target, err)
+	}
+}
+
+func (a *Auditor) summary(v any) sql.NullString {
+	if v == nil {
+		return sql.NullString{}
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		a.logger.Println("Error encoding an audit summary:", err)
+		return sql.NullString{}
+	}
+
+	return sql.NullString{String: string(data), Valid: true}
+}
+
+// postSummary is the state of a post recorded around its changes.
+type postSummary struct {
+	Title       string   `json:"title"`
+	Slug        string   `json:"slug"`
+	Description string   `json:"description,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+	CoverImage  string   `json:"cover_image,omitempty"`
+	Words       int64    `json:"words"`
+}
+
+func newPostSummary(post repository.Post, tags []repository.Tag) postSummary {
+	summary := postSummary{
+		Title:       post.Title,
+		Slug:        post.Slug,
+		Description: post.Description.String,
+		CoverImage:  post.CoverImage.String,
+		Words:       post.Words.Int64,
+	}
+
+	for _, tag := range tags {
+		summary.Tags = append(summary.Tags, tag.Name)
+	}
+
+	return summary
+}
+
+// apiTokenSummary is an API token recorded when it is created, without the
+// token itself.
+type apiTokenSummary struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+// mediaSummary is an uploaded file recorded when it is deleted.
+type mediaSummary struct {
+	OriginalName string `json:"original_name"`
+	MimeType     string `json:"mime_type"`
+	Size         int64  `json:"size"`
+	UsedBy       int    `json:"used_by,omitempty"` // Posts still referencing it, when the deletion was forced
+}
+
+type AuditHandler struct {
+	repository AuditRepository
+	location   *time.Location
+	logger     *log.Logger
+	blogName   string
+	pagetitle  string
+}
+
+func NewAuditHandler(repo AuditRepository, location *time.Location, logger *log.Logger, blogName, pagetitle string) *AuditHandler {
+	return &AuditHandler{
+		repository: repo,
+		location:   location,
+		logger:     logger,
+		blogName:   blogName,
+		pagetitle:  pagetitle,
+	}
+}
+
+// admin reports whether the request is from an admin, who may read the audit
+// log. Otherwise it writes the response.
+func (h *AuditHandler) admin(w http.ResponseWriter, r *http.Request) bool {
+	user, _ := requestUser(r)
+	if !user.CanManageUsers() {
+		http.Error(w, "Only admins can read the audit log", http.StatusForbidden)
+		return false
+	}
+
+	return true
+}
+
+// filter reads the filters of the query: the actor, an action or action
+// prefix, part of the target, and the first and last day, as 2006-01-02.
+func (h *AuditHandler) filter(r *http.Request, limit int64) (pages.AuditFilter, repository.GetAuditEventsParams) {
+	filter := pages.AuditFilter{
+		Actor:  strings.TrimSpace(r.FormValue("actor")),
+		Action: strings.TrimSpace(r.FormValue("action")),
+		Target: strings.TrimSpace(r.FormValue("target")),
+		Since:  r.FormValue("since"),
+		Until:  r.FormValue("until"),
+	}
+
+	params := repository.GetAuditEventsParams{
+		Actor:  sql.NullString{String: filter.Actor, Valid: filter.Actor != ""},
+		Action: sql.NullString{String: filter.Action, Valid: filter.Action != ""},
+		Target: sql.NullString{String: filter.Target, Valid: filter.Target != ""},
+		Limit:  limit,
+	}
+
+	if since, err := time.ParseInLocation(time.DateOnly, filter.Since, h.location); err == nil {
+		params.Since = sql.NullTime{Time: since, Valid: true}
+	} else {
+		filter.Since = ""
+	}
+
+	if until, err := time.ParseInLocation(time.DateOnly, filter.Until, h.location); err == nil {
+		params.Until = sql.NullTime{Time: until.AddDate(0, 0, 1), Valid: true}
+	} else {
+		filter.Until = ""
+	}
+
+	return filter, params
+}
+
+// List shows the latest audit events matching the filters of the query.
+func (h *AuditHandler) List(w http.ResponseWriter, r *http.Request) {
+	if !h.admin(w, r) {
+		return
+	}
+
+	ctx := r.Context()
+
+	filter, params := h.filter(r, auditPageSize)
+
+	events, err := h.repository.GetAuditEvents(ctx, params)
+	if err != nil {
+		h.logger.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	auditPage := pages.AuditPage(h.blogName, h.pagetitle, events, filter, AuditCategories)
+
+	page := pages.Root(h.blogName, auditPage)
+	page.Render(ctx, w)
+}
+
+// auditEventJSON is an audit event in the JSON export, with the summaries
+// embedded as JSON.
+type auditEventJSON struct {
+	ID        int64           `json:"id"`
+	CreatedAt time.Time       `json:"created_at"`
+	ActorID   *int64          `json:"actor_id"`
+	Actor     string          `json:"actor"`
+	Action    string          `json:"action"`
+	Target    string          `json:"target"`
+	IP        string          `json:"ip"`
+	Before    json.RawMessage `json:"before"`
+	After     json.RawMessage `json:"after"`
+}
+
+// Export downloads the audit events matching the filters of the query as a
+// JSON array, newest first.
+func (h *AuditHandler) Export(w http.ResponseWriter, r *http.Request) {
+	if !h.admin(w, r) {
+		return
+	}
+
+	_, params := h.filter(r, auditExportSize)
+
+	events, err := h.repository.GetAuditEvents(r.Context(), params)
+	if err != nil {
+		h.logger.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	export := make([]auditEventJSON, 0, len(events))
+	for _, event := range events {
+		e := auditEventJSON{
+			ID:        event.ID,
+			CreatedAt: event.CreatedAt,
+			Actor:     event.Actor,
+			Action:    event.Action,
+			Target:    event.Target,
+			IP:        event.Ip,
+			Before:    json.RawMessage("null"),
+			After:     json.RawMessage("null"),
+		}
+		if event.ActorID.Valid {
+			e.ActorID = &event.ActorID.Int64
+		}
+		if event.Before.Valid {
+			e.Before = json.RawMessage(event.Before.String)
+		}
+		if event.After.Valid {
+			e.After = json.RawMessage(event.After.String)
+		}
+		export = append(export, e)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="audit-events.json"`)
+
+	if err := json.NewEncoder(w).Encode(export); err != nil {
+		h.logger.Println("Error encoding audit events to JSON:", err)
+	}
+}
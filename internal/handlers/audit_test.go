@@ -0,0 +1,245 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/luizgustavojunqueira/Blogo/internal/auth"
+	"github.com/luizgustavojunqueira/Blogo/internal/repository"
+)
+
+// auditRepositoryMock keeps the events in memory and filters them like the
+// GetAuditEvents query.
+type auditRepositoryMock struct {
+	events []repository.AuditEvent
+	err    error
+}
+
+func (m *auditRepositoryMock) CreateAuditEvent(ctx context.Context, arg repository.CreateAuditEventParams) error {
+	if m.err != nil {
+		return m.err
+	}
+
+	m.events = append(m.events, repository.AuditEvent{
+		ID:        int64(len(m.events) + 1),
+		CreatedAt: arg.CreatedAt,
+		ActorID:   arg.ActorID,
+		Actor:     arg.Actor,
+		Action:    arg.Action,
+		Target:    arg.Target,
+		Ip:        arg.Ip,
+		Before:    arg.Before,
+		After:     arg.After,
+	})
+	return nil
+}
+
+func (m *auditRepositoryMock) GetAuditEvents(ctx context.Context, arg repository.GetAuditEventsParams) ([]repository.AuditEvent, error) {
+	events := []repository.AuditEvent{}
+	for i := len(m.events) - 1; i >= 0 && int64(len(events)) < arg.Limit; i-- {
+		event := m.events[i]
+
+		switch {
+		case arg.Actor.Valid && event.Actor != arg.Actor.String:
+		case arg.Action.Valid && !strings.HasPrefix(event.Action, arg.Action.String):
+		case arg.Target.Valid && !strings.Contains(strings.ToLower(event.Target), strings.ToLower(arg.Target.String)):
+		case arg.Since.Valid && event.CreatedAt.Before(arg.Since.Time):
+		case arg.Until.Valid && !event.CreatedAt.Before(arg.Until.Time):
+		default:
+			events = append(events, event)
+		}
+	}
+
+	return events, nil
+}
+
+func TestAuditor_Record(t *testing.T) {
+	repo := &auditRepositoryMock{}
+	auditor := NewAuditor(repo, time.UTC, nil, log.New(io.Discard, "", 0))
+
+	r := httptest.NewRequest(http.MethodPost, "/editor", nil)
+	r.RemoteAddr = "192.0.2.1:51234"
+
+	writer := auth.User{ID: 7, Username: "writer"}
+	auditor.Record(r, writer, AuditPostUpdate, "post:hello", postSummary{Title: "Hello", Slug: "hello"}, nil)
+	auditor.Record(r, auth.User{}, AuditLoginFailed, "user:admin", nil, loginSummary{Method: loginPassword})
+
+	if len(repo.events) != 2 {
+		t.Fatalf("recorded %d events, want 2", len(repo.events))
+	}
+
+	update := repo.events[0]
+	if !update.ActorID.Valid || update.ActorID.Int64 != 7 || update.Actor != "writer" {
+		t.Errorf("actor = %v %q, want 7 writer", update.ActorID, update.Actor)
+	}
+	if update.Action != AuditPostUpdate || update.Target != "post:hello" || update.Ip != "192.0.2.1" {
+		t.Errorf("event = %s on %s from %s, want %s on post:hello from 192.0.2.1", update.Action, update.Target, update.Ip, AuditPostUpdate)
+	}
+	if want := `{"title":"Hello","slug":"hello","words":0}`; !update.Before.Valid || update.Before.String != want {
+		t.Errorf("before = %v, want %s", update.Before, want)
+	}
+	if update.After.Valid {
+		t.Errorf("after = %v, want NULL", update.After)
+	}
+	if time.Since(update.CreatedAt) > time.Minute {
+		t.Errorf("created at %s, want now", update.CreatedAt)
+	}
+
+	failed := repo.events[1]
+	if failed.ActorID.Valid || failed.Actor != "" {
+		t.Errorf("actor of an anonymous request = %v %q, want none", failed.ActorID, failed.Actor)
+	}
+	if want := `{"method":"password"}`; failed.After.String != want {
+		t.Errorf("after = %q, want %s", failed.After.String, want)
+	}
+
+	// Failing to record does not fail the action that was already done
+	repo.err = errors.New("database is locked")
+	auditor.Record(r, writer, AuditPostDelete, "post:hello", nil, nil)
+
+	var none *Auditor
+	none.Record(r, writer, AuditPostDelete, "post:hello", nil, nil)
+}
+
+func TestAuditHandler_filter(t *testing.T) {
+	location := time.FixedZone("UTC-3", -3*60*60)
+	h := NewAuditHandler(&auditRepositoryMock{}, location, log.New(io.Discard, "", 0), "Blog", "Blog")
+
+	tests := []struct {
+		name       string
+		query      string
+		wantFilter string
+		wantSince  time.Time
+		wantUntil  time.Time
+	}{
+		{
+			name:       "No filters",
+			query:      "",
+			wantFilter: "    ",
+		},
+		{
+			name:       "Every filter",
+			query:      "actor=+writer+&action=post.&target=hello&since=2025-01-02&until=2025-01-03",
+			wantFilter: "writer post. hello 2025-01-02 2025-01-03",
+			wantSince:  time.Date(2025, 1, 2, 0, 0, 0, 0, location),
+			wantUntil:  time.Date(2025, 1, 4, 0, 0, 0, 0, location),
+		},
+		{
+			name:       "Invalid dates are dropped",
+			query:      "since=yesterday&until=2025-02-30",
+			wantFilter: "    ",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/admin/audit?"+tt.query, nil)
+
+			filter, params := h.filter(r, 50)
+
+			got := strings.Join([]string{filter.Actor, filter.Action, filter.Target, filter.Since, filter.Until}, " ")
+			if got != tt.wantFilter {
+				t.Errorf("filter = %q, want %q", got, tt.wantFilter)
+			}
+
+			if params.Actor.Valid != (filter.Actor != "") || params.Actor.String != filter.Actor {
+				t.Errorf("actor = %v, want %q", params.Actor, filter.Actor)
+			}
+			if params.Action.Valid != (filter.Action != "") || params.Action.String != filter.Action {
+				t.Errorf("action = %v, want %q", params.Action, filter.Action)
+			}
+			if params.Target.Valid != (filter.Target != "") || params.Target.String != filter.Target {
+				t.Errorf("target = %v, want %q", params.Target, filter.Target)
+			}
+
+			if params.Since.Valid != !tt.wantSince.IsZero() || !params.Since.Time.Equal(tt.wantSince) {
+				t.Errorf("since = %v, want %v", params.Since, tt.wantSince)
+			}
+			if params.Until.Valid != !tt.wantUntil.IsZero() || !params.Until.Time.Equal(tt.wantUntil) {
+				t.Errorf("until = %v, want %v", params.Until, tt.wantUntil)
+			}
+
+			if params.Limit != 50 {
+				t.Errorf("limit = %d, want 50", params.Limit)
+			}
+		})
+	}
+}
+
+func TestAuditHandler_Export(t *testing.T) {
+	location := time.UTC
+	at := func(day, hour int) time.Time { return time.Date(2025, 1, day, hour, 0, 0, 0, location) }
+
+	repo := &auditRepositoryMock{events: []repository.AuditEvent{
+		{ID: 1, CreatedAt: at(1, 23), Actor: "writer", Action: AuditPostCreate, Target: "post:first"},
+		{ID: 2, CreatedAt: at(2, 0), Actor: "writer", Action: AuditPostUpdate, Target: "post:first"},
+		{ID: 3, CreatedAt: at(2, 12), Actor: "admin", Action: AuditUserRoleUpdate, Target: "user:writer"},
+		{ID: 4, CreatedAt: at(3, 23), Actor: "writer", Action: AuditPostDelete, Target: "post:first"},
+		{ID: 5, CreatedAt: at(4, 0), Actor: "writer", Action: AuditPostCreate, Target: "post:second"},
+	}}
+	h := NewAuditHandler(repo, location, log.New(io.Discard, "", 0), "Blog", "Blog")
+
+	export := func(t *testing.T, user auth.User, query string) (*httptest.ResponseRecorder, []int64) {
+		t.Helper()
+
+		r := httptest.NewRequest(http.MethodGet, "/admin/audit/export?"+query, nil)
+		r = r.WithContext(auth.WithUser(r.Context(), user))
+
+		w := httptest.NewRecorder()
+		h.Export(w, r)
+
+		var events []auditEventJSON
+		if w.Code == http.StatusOK {
+			if err := json.NewDecoder(w.Body).Decode(&events); err != nil {
+				t.Fatalf("decoding the export error = %v", err)
+			}
+		}
+
+		ids := make([]int64, 0, len(events))
+		for _, event := range events {
+			ids = append(ids, event.ID)
+		}
+		return w, ids
+	}
+
+	admin := auth.User{ID: 1, Username: "admin", Role: auth.RoleAdmin}
+
+	tests := []struct {
+		name  string
+		query string
+		want  []int64
+	}{
+		{name: "Everything, newest first", query: "", want: []int64{5, 4, 3, 2, 1}},
+		{name: "Action prefix", query: "action=post.", want: []int64{5, 4, 2, 1}},
+		{name: "Exact action", query: "action=post.create", want: []int64{5, 1}},
+		{name: "Both bounds include their whole day", query: "since=2025-01-02&until=2025-01-03", want: []int64{4, 3, 2}},
+		{name: "Prefix and dates", query: "action=post.&since=2025-01-02&until=2025-01-02", want: []int64{2}},
+		{name: "Actor and target", query: "actor=writer&target=SECOND", want: []int64{5}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, got := export(t, admin, tt.query)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+			}
+
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("exported %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if w, _ := export(t, auth.User{ID: 2, Username: "editor", Role: auth.RoleEditor}, ""); w.Code != http.StatusForbidden {
+		t.Errorf("status for an editor = %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
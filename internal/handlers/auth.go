@@ -1,27 +1,50 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"net/netip"
+	"strconv"
 	"time"
 
 	"github.com/luizgustavojunqueira/Blogo/internal/auth"
 	"github.com/luizgustavojunqueira/Blogo/internal/templates/pages"
 )
 
+// challengeValidity is how long the second login step can be completed after
+// the password was accepted.
+const challengeValidity = 5 * time.Minute
+
 type AuthHandler struct {
-	auth      *auth.Auth
-	logger    *log.Logger
-	blogName  string
-	pagetitle string
+	auth           *auth.Auth
+	twoFactor      *auth.TwoFactor
+	limiter        *auth.Limiter
+	magicLinks     *auth.MagicLinks
+	oidc           *auth.OIDC
+	trustedProxies []netip.Prefix
+	audit          *Auditor
+	logger         *log.Logger
+	blogName       string
+	pagetitle      string
 }
 
-func NewAuthHandler(auth *auth.Auth, logger *log.Logger, blogName, pagetitle string) *AuthHandler {
+// NewAuthHandler returns the login handlers. magicLinks is nil when logging
+// in with emailed links is disabled, and oidc when logging in with an
+// OpenID Connect provider is.
+func NewAuthHandler(auth *auth.Auth, twoFactor *auth.TwoFactor, limiter *auth.Limiter, magicLinks *auth.MagicLinks, oidc *auth.OIDC, trustedProxies []netip.Prefix, audit *Auditor, logger *log.Logger, blogName, pagetitle string) *AuthHandler {
 	return &AuthHandler{
-		auth:      auth,
-		logger:    logger,
-		blogName:  blogName,
-		pagetitle: pagetitle,
+		auth:           auth,
+		twoFactor:      twoFactor,
+		limiter:        limiter,
+		magicLinks:     magicLinks,
+		oidc:           oidc,
+		trustedProxies: trustedProxies,
+		audit:          audit,
+		logger:         logger,
+		blogName:       blogName,
+		pagetitle:      pagetitle,
 	}
 }
 
@@ -30,7 +53,12 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 
 		ctx := r.Context()
 
-		loginPage := pages.LoginPage(h.blogName, h.pagetitle)
+		oidcName := ""
+		if h.oidc != nil {
+			oidcName = h.oidc.Name()
+		}
+
+		loginPage := pages.LoginPage(h.blogName, h.pagetitle, h.magicLinks != nil, oidcName)
 
 		page := pages.Root(h.blogName, loginPage)
 		page.Render(ctx, w)
@@ -46,33 +74,410 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 
 	username := r.FormValue("username")
 	password := r.FormValue("password")
+	remember := r.FormValue("remember") != ""
+	ip := clientIP(r, h.trustedProxies)
+
+	wait, err := h.limiter.Attempt(r.Context(), ip, username)
+	if err != nil {
+		h.logger.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if wait > 0 {
+		h.logger.Printf("Blocked login for %q from %s, locked for %s\n", username, ip, wait.Round(time.Second))
+		h.locked(w, wait)
+		return
+	}
+
+	user, err := h.auth.ValidateCredentials(r.Context(), username, password)
+	if errors.Is(err, auth.ErrInvalidCredentials) {
+		h.logger.Printf("Failed login for %q from %s\n", username, ip)
+		h.audit.Record(r, auth.User{}, AuditLoginFailed, "user:"+username, nil, loginSummary{Method: loginPassword})
+
+		// The attempt was already counted, and may have locked them
+		wait, err := h.limiter.Check(r.Context(), ip, username)
+		if err != nil {
+			h.logger.Println(err)
+		}
+		if wait > 0 {
+			h.locked(w, wait)
+			return
+		}
 
-	if h.auth.ValidateCredentials(username, password) == false {
 		http.Error(w, "Invalid username or password", http.StatusUnauthorized)
 		return
 	}
+	if err != nil {
+		h.logger.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.signIn(w, r, user, ip, username, loginPassword, remember)
+}
 
-	expiry := time.Now().Unix() + h.auth.GetTokenValidity()
+// signIn continues the login of user once their password, login link or
+// provider was accepted: with the second step of two-factor authentication
+// when they enabled it, otherwise by starting their session. attempt is the
+// name the attempt was counted under by the limiter, empty when it was not
+// counted, and method how the user logged in.
+func (h *AuthHandler) signIn(w http.ResponseWriter, r *http.Request, user auth.User, ip, attempt, method string, remember bool) {
+	twoFactor, err := h.twoFactor.Enabled(r.Context(), user.ID)
+	if err != nil {
+		h.logger.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
-	token := h.auth.GenerateToken(username, expiry)
+	// The failed attempts of the username are kept until the second step
+	// succeeds, so the codes cannot be guessed faster than passwords. The
+	// attempt no longer counts against the IP address, since the code is
+	// counted again.
+	if twoFactor {
+		if attempt != "" {
+			if err := h.limiter.PassFirstStep(r.Context(), ip, attempt); err != nil {
+				h.logger.Println(err)
+			}
+		}
 
-	cookie := http.Cookie{
-		Name:     h.auth.GetCookieName(),
-		Value:    token,
-		Expires:  time.Unix(expiry, 0),
+		expiry := time.Now().Add(challengeValidity)
+
+		// Lax, since the provider redirecting back is a navigation from
+		// another site, and a strict cookie would not be sent to the page
+		// asking for the code. The code is only accepted from its form.
+		http.SetCookie(w, &http.Cookie{
+			Name:     h.challengeCookieName(),
+			Value:    h.auth.GenerateChallenge(user.ID, remember, expiry.Unix()),
+			Path:     "/login",
+			Expires:  expiry,
+			Secure:   h.auth.SecureCookies(),
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+
+		if r.Header.Get("HX-Request") == "" {
+			http.Redirect(w, r, "/login/2fa", http.StatusSeeOther)
+			return
+		}
+		w.Header().Set("HX-Location", "/login/2fa")
+		return
+	}
+
+	if attempt != "" {
+		if err := h.limiter.Succeed(r.Context(), ip, attempt); err != nil {
+			h.logger.Println(err)
+		}
+	}
+
+	h.startSession(w, r, user, method, remember)
+}
+
+// LoginEmail emails a login link to the address of the form. The answer is
+// the same whether or not an account uses the address.
+func (h *AuthHandler) LoginEmail(w http.ResponseWriter, r *http.Request) {
+	if h.magicLinks == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	ctx := r.Context()
+
+	if r.Method != http.MethodPost {
+		page := pages.Root(h.blogName, pages.LoginEmailPage(h.blogName, h.pagetitle))
+		page.Render(ctx, w)
+		return
+	}
+
+	ip := clientIP(r, h.trustedProxies)
+
+	wait, err := h.magicLinks.Send(ctx, ip, r.FormValue("email"))
+	if errors.Is(err, auth.ErrInvalidEmail) {
+		http.Error(w, "Enter a valid email address", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		h.logger.Println(err)
+		http.Error(w, "Could not send the login link, try again later", http.StatusInternalServerError)
+		return
+	}
+	if wait > 0 {
+		h.logger.Printf("Blocked login link for %q from %s, locked for %s\n", r.FormValue("email"), ip, wait.Round(time.Second))
+		h.locked(w, wait)
+		return
+	}
+
+	fmt.Fprint(w, "If an account uses this address, a login link is on its way. Check your inbox.")
+}
+
+// LoginLink logs in with the token of an emailed link. Opening the link only
+// asks for a confirmation, which uses the link, so that mail scanners
+// following it do not use it up.
+func (h *AuthHandler) LoginLink(w http.ResponseWriter, r *http.Request) {
+	if h.magicLinks == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	ctx := r.Context()
+	token := r.PathValue("token")
+
+	if r.Method != http.MethodPost {
+		page := pages.Root(h.blogName, pages.LoginLinkPage(h.blogName, h.pagetitle, token))
+		page.Render(ctx, w)
+		return
+	}
+
+	user, err := h.magicLinks.Login(ctx, token)
+	if errors.Is(err, auth.ErrInvalidLink) || errors.Is(err, auth.ErrUserNotFound) {
+		h.logger.Printf("Invalid login link from %s\n", clientIP(r, h.trustedProxies))
+		h.audit.Record(r, auth.User{}, AuditLoginFailed, "login link", nil, loginSummary{Method: loginEmailLink})
+		http.Error(w, "This login link is invalid, expired or was already used", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		h.logger.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Login links are limited when they are sent, not when they are used
+	h.signIn(w, r, user, clientIP(r, h.trustedProxies), "", loginEmailLink, false)
+}
+
+// LoginCode is the second login step of users with two-factor authentication,
+// which asks for a TOTP or recovery code before issuing the session.
+func (h *AuthHandler) LoginCode(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	cookie, err := r.Cookie(h.challengeCookieName())
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+
+	user, remember, err := h.auth.UserFromChallenge(ctx, cookie.Value)
+	if err != nil {
+		h.logger.Println(err)
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		page := pages.Root(h.blogName, pages.LoginCodePage(h.blogName, h.pagetitle))
+		page.Render(ctx, w)
+		return
+	}
+
+	ip := clientIP(r, h.trustedProxies)
+
+	wait, err := h.limiter.Attempt(ctx, ip, user.Username)
+	if err != nil {
+		h.logger.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if wait > 0 {
+		h.logger.Printf("Blocked login code for %q from %s, locked for %s\n", user.Username, ip, wait.Round(time.Second))
+		h.locked(w, wait)
+		return
+	}
+
+	err = h.twoFactor.Verify(ctx, user.ID, r.FormValue("code"))
+	if errors.Is(err, auth.ErrInvalidCode) {
+		h.logger.Printf("Failed login code for %q from %s\n", user.Username, ip)
+		h.audit.Record(r, auth.User{}, AuditLoginFailed, "user:"+user.Username, nil, loginSummary{Method: loginTwoFactor})
+
+		wait, err := h.limiter.Check(ctx, ip, user.Username)
+		if err != nil {
+			h.logger.Println(err)
+		}
+		if wait > 0 {
+			h.locked(w, wait)
+			return
+		}
+
+		http.Error(w, "Invalid code", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		h.logger.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.limiter.Succeed(ctx, ip, user.Username); err != nil {
+		h.logger.Println(err)
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     h.challengeCookieName(),
+		Path:     "/login",
+		MaxAge:   -1,
+		Secure:   h.auth.SecureCookies(),
 		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	h.startSession(w, r, user, loginTwoFactor, remember)
+}
+
+// LoginOIDC sends the browser to the OpenID Connect provider to log in. The
+// state of the login is kept in a cookie until the provider redirects back.
+func (h *AuthHandler) LoginOIDC(w http.ResponseWriter, r *http.Request) {
+	if h.oidc == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	authURL, state, err := h.oidc.AuthCodeURL(r.Context(), r.FormValue("remember") != "")
+	if err != nil {
+		h.logger.Println(err)
+		http.Error(w, "The login provider is unavailable, try again later", http.StatusBadGateway)
+		return
+	}
+
+	// Lax, as the provider redirects back with a cross-site navigation
+	http.SetCookie(w, &http.Cookie{
+		Name:     h.oidcCookieName(),
+		Value:    state,
+		Path:     "/login/oidc",
+		MaxAge:   int((10 * time.Minute).Seconds()),
+		Secure:   h.auth.SecureCookies(),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// OIDCCallback completes a login when the provider redirects back. Users who
+// enabled two-factor authentication are asked for their code as well, since
+// the blog cannot tell whether the provider checked a second factor.
+func (h *AuthHandler) OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if h.oidc == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	ctx := r.Context()
+	ip := clientIP(r, h.trustedProxies)
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     h.oidcCookieName(),
+		Path:     "/login/oidc",
+		MaxAge:   -1,
+		Secure:   h.auth.SecureCookies(),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	if providerErr := r.FormValue("error"); providerErr != "" {
+		h.logger.Printf("OIDC login from %s refused by the provider: %s %s\n", ip, providerErr, r.FormValue("error_description"))
+		http.Error(w, "The login provider refused the login", http.StatusUnauthorized)
+		return
+	}
+
+	cookie, err := r.Cookie(h.oidcCookieName())
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+
+	user, remember, err := h.oidc.Exchange(ctx, cookie.Value, r.FormValue("state"), r.FormValue("code"))
+	if errors.Is(err, auth.ErrOIDCState) {
+		h.logger.Printf("Invalid OIDC login state from %s\n", ip)
+		http.Error(w, "The login expired or was started in another browser, try again", http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, auth.ErrOIDCNotAllowed) {
+		h.logger.Printf("OIDC login from %s not allowed\n", ip)
+		h.audit.Record(r, auth.User{}, AuditLoginFailed, "oidc", nil, loginSummary{Method: loginOIDC})
+		http.Error(w, "This account may not log in to this blog", http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		h.logger.Println(err)
+		http.Error(w, "The login could not be completed, try again", http.StatusBadGateway)
+		return
+	}
+
+	h.signIn(w, r, user, ip, "", loginOIDC, remember)
+}
+
+func (h *AuthHandler) oidcCookieName() string {
+	return h.auth.GetCookieName() + "_oidc"
+}
+
+// Login methods recorded in the audit log.
+const (
+	loginPassword  = "password"
+	loginTwoFactor = "two-factor"
+	loginEmailLink = "email link"
+	loginOIDC      = "oidc"
+)
+
+// loginSummary is the audit summary of a login.
+type loginSummary struct {
+	Method   string `json:"method"`
+	Remember bool   `json:"remember,omitempty"`
+}
+
+// startSession signs user in, sets the auth cookie and sends them to the home
+// page. With remember, the session lasts for the "remember me" validity.
+// method is how they logged in, for the audit log.
+func (h *AuthHandler) startSession(w http.ResponseWriter, r *http.Request, user auth.User, method string, remember bool) {
+	token, expiry, err := h.auth.CreateSession(r.Context(), user, remember, clientIP(r, h.trustedProxies), r.UserAgent())
+	if err != nil {
+		h.logger.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
-	http.SetCookie(w, &cookie)
+	h.audit.Record(r, user, AuditLogin, "user:"+user.Username, nil, loginSummary{Method: method, Remember: remember})
+
+	http.SetCookie(w, h.auth.SessionCookie(token, expiry))
+
+	// Logins outside of htmx, like the provider redirecting back, get a
+	// plain redirect
+	if r.Header.Get("HX-Request") == "" {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
 	w.Header().Set("HX-Location", "/")
 }
 
+func (h *AuthHandler) challengeCookieName() string {
+	return h.auth.GetCookieName() + "_2fa"
+}
+
+// Logout ends the session of the request, so its token stops working even
+// if the cookie was copied, and deletes the cookie. It only answers POST, so
+// the CSRF middleware checks it and other sites cannot log writers out with
+// a link or an image.
 func (h *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
-	cookie := http.Cookie{
-		Name:   h.auth.GetCookieName(),
-		MaxAge: -1,
+	if cookie, err := r.Cookie(h.auth.GetCookieName()); err == nil {
+		if user, err := h.auth.UserFromToken(r.Context(), cookie.Value); err == nil {
+			h.audit.Record(r, user, AuditLogout, "user:"+user.Username, nil, nil)
+		}
+
+		if err := h.auth.RevokeToken(r.Context(), cookie.Value); err != nil {
+			h.logger.Println(err)
+		}
+	}
+
+	http.SetCookie(w, h.auth.ExpiredSessionCookie())
+
+	if r.Header.Get("HX-Request") == "" {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
 	}
+	w.Header().Set("HX-Location", "/")
+}
+
+// locked answers a login attempt while the account or address is locked.
+func (h *AuthHandler) locked(w http.ResponseWriter, wait time.Duration) {
+	wait = (wait + time.Second - 1).Truncate(time.Second)
 
-	http.SetCookie(w, &cookie)
-	http.Redirect(w, r, "/", http.StatusSeeOther)
+	w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())))
+	http.Error(w, fmt.Sprintf("Too many failed login attempts, the account is locked. Try again in %s.", wait), http.StatusTooManyRequests)
 }
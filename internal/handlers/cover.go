@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// coverImage is the optional cover image of a post: the name of an uploaded
+// file, its alt text and a caption.
+type coverImage struct {
+	image   sql.NullString
+	alt     sql.NullString
+	caption sql.NullString
+}
+
+// coverFromForm reads the cover image fields sent by the editor.
+func coverFromForm(r *http.Request) coverImage {
+	value := func(key string) sql.NullString {
+		v := strings.TrimSpace(r.FormValue(key))
+		return sql.NullString{String: v, Valid: v != ""}
+	}
+
+	cover := coverImage{
+		image:   value("cover_image"),
+		alt:     value("cover_alt"),
+		caption: value("cover_caption"),
+	}
+
+	if !cover.image.Valid {
+		return coverImage{}
+	}
+
+	return cover
+}
+
+// validateCover checks that the cover image was uploaded and has alt text.
+func (h *PostHandler) validateCover(ctx context.Context, cover coverImage) error {
+	if !cover.image.Valid {
+		return nil
+	}
+
+	if !cover.alt.Valid {
+		return fmt.Errorf("the cover image needs an alt text")
+	}
+
+	_, err := h.mediaRepo.GetMediaByName(ctx, cover.image.String)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("cover image %s not found in the media library", cover.image.String)
+	}
+
+	return err
+}
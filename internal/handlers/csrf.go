@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"log"
+	"net/http"
+
+	"github.com/luizgustavojunqueira/Blogo/internal/auth"
+)
+
+type CSRFAuth interface {
+	GetCookieName() string
+	CSRFToken(session string) string
+	ValidateCSRFToken(session, token string) bool
+	SecureCookies() bool
+}
+
+// CSRF rejects state-changing requests that do not send the CSRF token of
+// their session in the X-CSRF-Token header. The token of every request is
+// added to its context, so pages.Root can hand it to htmx. Requests with a
+// bearer token are not checked, since browsers never add one on their own
+// and the session cookie is then ignored.
+func CSRF(authenticator CSRFAuth, logger *log.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := auth.BearerToken(r); ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		session := csrfSession(w, r, authenticator)
+
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			if !authenticator.ValidateCSRFToken(session, r.Header.Get(auth.CSRFHeader)) {
+				logger.Printf("Invalid CSRF token on %s %s\n", r.Method, r.URL.Path)
+				http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+				return
+			}
+		}
+
+		ctx := auth.WithCSRFToken(r.Context(), authenticator.CSRFToken(session))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// csrfSession returns the session the CSRF token is bound to: the auth cookie
+// when logged in, or an anonymous cookie, created on the first visit, so the
+// login form is protected as well.
+func csrfSession(w http.ResponseWriter, r *http.Request, authenticator CSRFAuth) string {
+	if cookie, err := r.Cookie(authenticator.GetCookieName()); err == nil && cookie.Value != "" {
+		return cookie.Value
+	}
+
+	name := authenticator.GetCookieName() + "_csrf"
+	if cookie, err := r.Cookie(name); err == nil && cookie.Value != "" {
+		return cookie.Value
+	}
+
+	session := rand.Text()
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    session,
+		Path:     "/",
+		Secure:   authenticator.SecureCookies(),
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+
+	return session
+}
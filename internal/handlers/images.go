@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/luizgustavojunqueira/Blogo/internal/media"
+)
+
+type ImageVariants interface {
+	Path(ctx context.Context, src string, width int) (string, error)
+}
+
+type ImageHandler struct {
+	variants ImageVariants
+	logger   *log.Logger
+}
+
+func NewImageHandler(variants ImageVariants, logger *log.Logger) *ImageHandler {
+	return &ImageHandler{
+		variants: variants,
+		logger:   logger,
+	}
+}
+
+// ServeVariant sends a resized variant of a local image, generating it on the
+// first request.
+func (h *ImageHandler) ServeVariant(w http.ResponseWriter, r *http.Request) {
+	width, err := strconv.Atoi(r.PathValue("width"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	path, err := h.variants.Path(r.Context(), "/"+r.PathValue("src"), width)
+	if errors.Is(err, media.ErrNoVariant) || errors.Is(err, media.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		h.logger.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	http.ServeFile(w, r, path)
+}
@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/luizgustavojunqueira/Blogo/internal/linkcheck"
+	"github.com/luizgustavojunqueira/Blogo/internal/repository"
+	"github.com/luizgustavojunqueira/Blogo/internal/templates/pages"
+)
+
+type LinkCheckRepository interface {
+	GetPosts(ctx context.Context) ([]repository.Post, error)
+	AddLinkCheck(ctx context.Context, arg repository.AddLinkCheckParams) error
+	ClearLinkChecks(ctx context.Context, postID int64) error
+	GetLinkChecks(ctx context.Context) ([]repository.GetLinkChecksRow, error)
+}
+
+type LinkChecker interface {
+	Reset()
+	CheckHTML(ctx context.Context, html string) []linkcheck.Result
+}
+
+type LinkCheckHandler struct {
+	repository LinkCheckRepository
+	checker    LinkChecker
+	location   *time.Location
+	logger     *log.Logger
+	blogName   string
+	pagetitle  string
+	running    atomic.Bool
+}
+
+func NewLinkCheckHandler(repo LinkCheckRepository, checker LinkChecker, location *time.Location, logger *log.Logger, blogName, pagetitle string) *LinkCheckHandler {
+	return &LinkCheckHandler{
+		repository: repo,
+		checker:    checker,
+		location:   location,
+		logger:     logger,
+		blogName:   blogName,
+		pagetitle:  pagetitle,
+	}
+}
+
+// Report shows the result of the last link check.
+func (h *LinkCheckHandler) Report(w http.ResponseWriter, r *http.Request) {
+	if user, _ := requestUser(r); !user.CanManageContent() {
+		http.Error(w, "Only editors and admins can see the link report", http.StatusForbidden)
+		return
+	}
+
+	ctx := r.Context()
+
+	checks, err := h.repository.GetLinkChecks(ctx)
+	if err != nil {
+		h.logger.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	reportPage := pages.LinkReportPage(h.blogName, h.pagetitle, checks, h.running.Load())
+
+	page := pages.Root(h.blogName, reportPage)
+	page.Render(ctx, w)
+}
+
+// Check starts checking the links of every post in the background.
+func (h *LinkCheckHandler) Check(w http.ResponseWriter, r *http.Request) {
+	if user, _ := requestUser(r); !user.CanManageContent() {
+		http.Error(w, "Only editors and admins can check links", http.StatusForbidden)
+		return
+	}
+
+	if h.running.CompareAndSwap(false, true) {
+		go func() {
+			defer h.running.Store(false)
+
+			if err := h.CheckAll(context.Background()); err != nil {
+				h.logger.Println("Error checking links:", err)
+			}
+		}()
+	}
+
+	w.Header().Set("HX-Location", "/admin/links")
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// CheckAll checks the links of every post and stores the results.
+func (h *LinkCheckHandler) CheckAll(ctx context.Context) error {
+	posts, err := h.repository.GetPosts(ctx)
+	if err != nil {
+		return err
+	}
+
+	h.checker.Reset()
+
+	for _, post := range posts {
+		results := h.checker.CheckHTML(ctx, post.ParsedContent)
+
+		if err := h.repository.ClearLinkChecks(ctx, post.ID); err != nil {
+			return err
+		}
+
+		for _, result := range results {
+			err := h.repository.AddLinkCheck(ctx, repository.AddLinkCheckParams{
+				PostID:     post.ID,
+				Url:        result.URL,
+				Status:     result.Status,
+				StatusCode: sql.NullInt64{Int64: int64(result.StatusCode), Valid: result.StatusCode != 0},
+				Message:    sql.NullString{String: result.Message, Valid: result.Message != ""},
+				CheckedAt:  sql.NullTime{Time: time.Now().In(h.location), Valid: true},
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		h.logger.Printf("Checked %d links in post %s\n", len(results), post.Slug)
+	}
+
+	return nil
+}
+
+type linkCheckSite struct {
+	posts PostRepository
+	tags  TagRepository
+	media MediaRepository
+}
+
+// NewLinkCheckSite returns a linkcheck.Site that looks up posts, tags and
+// uploaded media in the given repositories.
+func NewLinkCheckSite(posts PostRepository, tags TagRepository, media MediaRepository) linkcheck.Site {
+	return &linkCheckSite{posts: posts, tags: tags, media: media}
+}
+
+func (s *linkCheckSite) PostExists(ctx context.Context, slug string) (bool, error) {
+	_, err := s.posts.GetPostBySlug(ctx, slug)
+	return found(err)
+}
+
+func (s *linkCheckSite) TagExists(ctx context.Context, name string) (bool, error) {
+	_, err := s.tags.GetTagByName(ctx, name)
+	return found(err)
+}
+
+func (s *linkCheckSite) MediaExists(ctx context.Context, name string) (bool, error) {
+	_, err := s.media.GetMediaByName(ctx, name)
+	return found(err)
+}
+
+func found(err error) (bool, error) {
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return err == nil, err
+}
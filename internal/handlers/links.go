@@ -0,0 +1,205 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/luizgustavojunqueira/Blogo/internal/markdown"
+	"github.com/luizgustavojunqueira/Blogo/internal/repository"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+)
+
+type LinkRepository interface {
+	AddPostLink(ctx context.Context, arg repository.AddPostLinkParams) error
+	ClearPostLinks(ctx context.Context, sourcePostID int64) error
+	GetBacklinks(ctx context.Context, targetPostID sql.NullInt64) ([]repository.GetBacklinksRow, error)
+	GetPostsLinkingTo(ctx context.Context, targetSlug string) ([]repository.Post, error)
+	UpdatePostContent(ctx context.Context, arg repository.UpdatePostContentParams) error
+}
+
+// postResolver resolves wiki links against the posts table and keeps the IDs
+// of the posts it found, so the link graph can be stored after rendering.
+type postResolver struct {
+	ctx     context.Context
+	repo    PostRepository
+	logger  *log.Logger
+	targets map[string]int64
+}
+
+func (r *postResolver) ResolveSlug(slug string) (string, bool) {
+	post, err := r.repo.GetPostBySlug(r.ctx, slug)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			r.logger.Println("Error resolving wiki link:", err)
+		}
+		return "", false
+	}
+
+	r.targets[slug] = post.ID
+	return post.Title, true
+}
+
+type renderedMarkdown struct {
+	html     string
+	readTime markdown.ReadTime
+	excerpt  string
+	links    []markdown.LinkRef
+	targets  map[string]int64
+}
+
+// convertMarkdown renders content to HTML, resolving wiki links against the
+// posts table. The reading time and excerpt come from the same parsed document.
+// Raw HTML is only rendered with rawHTML, for the posts of admins.
+func (h *PostHandler) convertMarkdown(ctx context.Context, content string, rawHTML bool) (renderedMarkdown, error) {
+	resolver := &postResolver{
+		ctx:     ctx,
+		repo:    h.repository,
+		logger:  h.logger,
+		targets: make(map[string]int64),
+	}
+
+	pc := markdown.NewContext(resolver)
+	src := []byte(content)
+
+	md := h.md
+	if rawHTML {
+		md = h.trustedMD
+	}
+
+	doc := md.Parser().Parse(text.NewReader(src), parser.WithContext(pc))
+
+	var buf bytes.Buffer
+	if err := md.Renderer().Render(&buf, src, doc); err != nil {
+		return renderedMarkdown{}, err
+	}
+
+	return renderedMarkdown{
+		html:     buf.String(),
+		readTime: h.readTime.Estimate(doc, src),
+		excerpt:  markdown.Excerpt(doc, src, markdown.DefaultExcerptLength),
+		links:    markdown.LinksFromContext(pc),
+		targets:  resolver.targets,
+	}, nil
+}
+
+// savePostLinks replaces the stored outgoing links of a post.
+func (h *PostHandler) savePostLinks(ctx context.Context, postID int64, rendered renderedMarkdown) error {
+	if err := h.linksRepo.ClearPostLinks(ctx, postID); err != nil {
+		return err
+	}
+
+	for _, link := range rendered.links {
+		targetID, ok := rendered.targets[link.Slug]
+
+		err := h.linksRepo.AddPostLink(ctx, repository.AddPostLinkParams{
+			SourcePostID: postID,
+			TargetSlug:   link.Slug,
+			TargetPostID: sql.NullInt64{Int64: targetID, Valid: ok},
+			CreatedAt:    sql.NullTime{Time: time.Now().In(h.location), Valid: true},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// updateLinkingPosts re-renders the posts that link to oldSlug. When the slug
+// changed, their wiki links are rewritten to newSlug first, so renaming a post
+// does not break the links pointing to it.
+func (h *PostHandler) updateLinkingPosts(ctx context.Context, oldSlug, newSlug string) error {
+	posts, err := h.linksRepo.GetPostsLinkingTo(ctx, oldSlug)
+	if err != nil {
+		return err
+	}
+
+	for _, post := range posts {
+		content := post.Content
+		if oldSlug != newSlug {
+			content = markdown.RenameWikiLinks(content, oldSlug, newSlug)
+		}
+
+		rawHTML, err := h.rawHTMLAllowed(ctx, post.AuthorID)
+		if err != nil {
+			return err
+		}
+
+		rendered, err := h.convertMarkdown(ctx, content, rawHTML)
+		if err != nil {
+			return err
+		}
+
+		toc, err := getPostToc(h.md, []byte(content))
+		if err != nil {
+			return err
+		}
+
+		err = h.linksRepo.UpdatePostContent(ctx, repository.UpdatePostContentParams{
+			Content:       content,
+			Toc:           toc,
+			ParsedContent: rendered.html,
+			Excerpt:       sql.NullString{String: rendered.excerpt, Valid: rendered.excerpt != ""},
+			ID:            post.ID,
+		})
+		if err != nil {
+			return err
+		}
+
+		if err := h.savePostLinks(ctx, post.ID, rendered); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RerenderPosts renders every post again with the current pipeline, for posts
+// saved before the Markdown extensions or the highlighting output changed.
+func (h *PostHandler) RerenderPosts(ctx context.Context) error {
+	posts, err := h.repository.GetPosts(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, post := range posts {
+		rawHTML, err := h.rawHTMLAllowed(ctx, post.AuthorID)
+		if err != nil {
+			return err
+		}
+
+		rendered, err := h.convertMarkdown(ctx, post.Content, rawHTML)
+		if err != nil {
+			return err
+		}
+
+		toc, err := getPostToc(h.md, []byte(post.Content))
+		if err != nil {
+			return err
+		}
+
+		err = h.linksRepo.UpdatePostContent(ctx, repository.UpdatePostContentParams{
+			Content:       post.Content,
+			Toc:           toc,
+			ParsedContent: rendered.html,
+			Excerpt:       sql.NullString{String: rendered.excerpt, Valid: rendered.excerpt != ""},
+			ID:            post.ID,
+		})
+		if err != nil {
+			return err
+		}
+
+		if err := h.savePostLinks(ctx, post.ID, rendered); err != nil {
+			return err
+		}
+
+		h.logger.Printf("Rendered post %s\n", post.Slug)
+	}
+
+	return nil
+}
@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/luizgustavojunqueira/Blogo/internal/auth"
+	"github.com/luizgustavojunqueira/Blogo/internal/repository"
+)
+
+type LoginAttemptRepository interface {
+	GetLoginAttempt(ctx context.Context, key string) (repository.LoginAttempt, error)
+	AddLoginAttempt(ctx context.Context, arg repository.AddLoginAttemptParams) (repository.LoginAttempt, error)
+	RemoveLoginAttempt(ctx context.Context, key string) error
+	LockLoginAttempt(ctx context.Context, arg repository.LockLoginAttemptParams) (int64, error)
+	DeleteLoginAttempt(ctx context.Context, key string) error
+}
+
+type attemptStore struct {
+	repo LoginAttemptRepository
+}
+
+// NewAttemptStore returns an auth.AttemptStore that keeps the failed logins in
+// the login_attempts table. Times are stored in UTC, since the queries compare
+// them as text.
+func NewAttemptStore(repo LoginAttemptRepository) auth.AttemptStore {
+	return &attemptStore{repo: repo}
+}
+
+func (s *attemptStore) GetAttempt(ctx context.Context, key string) (auth.LoginAttempt, error) {
+	attempt, err := s.repo.GetLoginAttempt(ctx, key)
+	if errors.Is(err, sql.ErrNoRows) {
+		return auth.LoginAttempt{}, auth.ErrNoAttempt
+	}
+	if err != nil {
+		return auth.LoginAttempt{}, err
+	}
+
+	return toAuthAttempt(attempt), nil
+}
+
+func (s *attemptStore) AddAttempt(ctx context.Context, key string, now, resetBefore time.Time) (auth.LoginAttempt, error) {
+	attempt, err := s.repo.AddLoginAttempt(ctx, repository.AddLoginAttemptParams{
+		Key:         key,
+		Now:         now.UTC(),
+		ResetBefore: resetBefore.UTC(),
+	})
+	if err != nil {
+		return auth.LoginAttempt{}, err
+	}
+
+	return toAuthAttempt(attempt), nil
+}
+
+func (s *attemptStore) RemoveAttempt(ctx context.Context, key string) error {
+	return s.repo.RemoveLoginAttempt(ctx, key)
+}
+
+func (s *attemptStore) Lock(ctx context.Context, key string, now, until time.Time) (bool, error) {
+	rows, err := s.repo.LockLoginAttempt(ctx, repository.LockLoginAttemptParams{
+		LockedUntil: sql.NullTime{Time: until.UTC(), Valid: true},
+		Key:         key,
+		Now:         sql.NullTime{Time: now.UTC(), Valid: true},
+	})
+	return rows > 0, err
+}
+
+func (s *attemptStore) DeleteAttempt(ctx context.Context, key string) error {
+	return s.repo.DeleteLoginAttempt(ctx, key)
+}
+
+func toAuthAttempt(attempt repository.LoginAttempt) auth.LoginAttempt {
+	return auth.LoginAttempt{
+		Failures:      attempt.Failures,
+		LastFailureAt: attempt.LastFailureAt,
+		LockedUntil:   attempt.LockedUntil.Time,
+	}
+}
+
+// ParseTrustedProxies parses a list of IP addresses and CIDR ranges, like
+// "10.0.0.1" or "10.0.0.0/8".
+func ParseTrustedProxies(proxies []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(proxies))
+
+	for _, proxy := range proxies {
+		if strings.Contains(proxy, "/") {
+			prefix, err := netip.ParsePrefix(proxy)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+
+		addr, err := netip.ParseAddr(proxy)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+	}
+
+	return prefixes, nil
+}
+
+func isTrustedProxy(addr netip.Addr, trusted []netip.Prefix) bool {
+	for _, prefix := range trusted {
+		if prefix.Contains(addr.Unmap()) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the IP address of the client. X-Forwarded-For is only read
+// when the request comes from a trusted proxy, and the client is then the
+// rightmost address that is not a trusted proxy, since the addresses on its
+// left can be forged.
+func clientIP(r *http.Request, trusted []netip.Prefix) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	addr, err := netip.ParseAddr(host)
+	if err != nil || !isTrustedProxy(addr, trusted) {
+		return host
+	}
+
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		ip, err := netip.ParseAddr(strings.TrimSpace(forwarded[i]))
+		if err != nil {
+			break
+		}
+
+		addr = ip.Unmap()
+		if !isTrustedProxy(addr, trusted) {
+			break
+		}
+	}
+
+	return addr.String()
+}
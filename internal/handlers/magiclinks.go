@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/luizgustavojunqueira/Blogo/internal/auth"
+	"github.com/luizgustavojunqueira/Blogo/internal/repository"
+)
+
+type MagicLinkRepository interface {
+	CreateMagicLink(ctx context.Context, arg repository.CreateMagicLinkParams) error
+	UseMagicLink(ctx context.Context, arg repository.UseMagicLinkParams) (int64, error)
+	DeleteExpiredMagicLinks(ctx context.Context, now time.Time) error
+}
+
+type magicLinkStore struct {
+	repo     MagicLinkRepository
+	location *time.Location
+}
+
+// NewMagicLinkStore returns an auth.MagicLinkStore that keeps the hashes of
+// the login links in the magic_links table.
+func NewMagicLinkStore(repo MagicLinkRepository, location *time.Location) auth.MagicLinkStore {
+	return &magicLinkStore{repo: repo, location: location}
+}
+
+func (s *magicLinkStore) CreateMagicLink(ctx context.Context, hash string, userID int64, expiresAt time.Time) error {
+	return s.repo.CreateMagicLink(ctx, repository.CreateMagicLinkParams{
+		TokenHash: hash,
+		UserID:    userID,
+		CreatedAt: time.Now().In(s.location),
+		ExpiresAt: expiresAt.In(s.location),
+	})
+}
+
+func (s *magicLinkStore) UseMagicLink(ctx context.Context, hash string, now time.Time) (int64, error) {
+	userID, err := s.repo.UseMagicLink(ctx, repository.UseMagicLinkParams{
+		UsedAt:    sql.NullTime{Time: now.In(s.location), Valid: true},
+		TokenHash: hash,
+		Now:       now.In(s.location),
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, auth.ErrInvalidLink
+	}
+
+	return userID, err
+}
+
+func (s *magicLinkStore) DeleteExpiredMagicLinks(ctx context.Context, now time.Time) error {
+	return s.repo.DeleteExpiredMagicLinks(ctx, now.In(s.location))
+}
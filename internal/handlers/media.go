@@ -0,0 +1,313 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/luizgustavojunqueira/Blogo/internal/media"
+	"github.com/luizgustavojunqueira/Blogo/internal/repository"
+	"github.com/luizgustavojunqueira/Blogo/internal/templates/pages"
+)
+
+type MediaRepository interface {
+	CreateMedia(ctx context.Context, arg repository.CreateMediaParams) (repository.Media, error)
+	GetMediaByName(ctx context.Context, name string) (repository.Media, error)
+	GetMediaByID(ctx context.Context, id int64) (repository.Media, error)
+	SearchMedia(ctx context.Context, search sql.NullString) ([]repository.Media, error)
+	DeleteMedia(ctx context.Context, id int64) error
+	GetPostsReferencingMedia(ctx context.Context, name sql.NullString) ([]repository.GetPostsReferencingMediaRow, error)
+}
+
+// VariantPurger removes the resized variants of an image.
+type VariantPurger interface {
+	Purge(src string) error
+}
+
+type MediaHandler struct {
+	repository MediaRepository
+	storage    media.Storage
+	variants   VariantPurger
+	maxSize    int64
+	location   *time.Location
+	audit      *Auditor
+	logger     *log.Logger
+	blogName   string
+	pagetitle  string
+}
+
+// mediaResponse is the JSON returned by Upload.
+type mediaResponse struct {
+	ID           int64  `json:"id"`
+	Name         string `json:"name"`
+	URL          string `json:"url"`
+	OriginalName string `json:"originalName"`
+	MimeType     string `json:"mimeType"`
+	Size         int64  `json:"size"`
+	Width        int64  `json:"width"`
+	Height       int64  `json:"height"`
+	Markdown     string `json:"markdown"`
+}
+
+func NewMediaHandler(repo MediaRepository, storage media.Storage, variants VariantPurger, maxSize int64, location *time.Location, audit *Auditor, logger *log.Logger, blogName, pagetitle string) *MediaHandler {
+	return &MediaHandler{
+		repository: repo,
+		storage:    storage,
+		variants:   variants,
+		maxSize:    maxSize,
+		location:   location,
+		audit:      audit,
+		logger:     logger,
+		blogName:   blogName,
+		pagetitle:  pagetitle,
+	}
+}
+
+// Upload stores an uploaded image and answers with its details as JSON.
+// Uploading a file that already exists returns the existing item.
+func (h *MediaHandler) Upload(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	tooLarge := fmt.Sprintf("file must be smaller than %d MB", h.maxSize>>20)
+
+	// Leave room for the multipart headers around the file
+	r.Body = http.MaxBytesReader(w, r.Body, h.maxSize+1<<20)
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			http.Error(w, tooLarge, http.StatusRequestEntityTooLarge)
+			return
+		}
+		h.logger.Println(err)
+		http.Error(w, "a file is required", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, h.maxSize+1))
+	if err != nil {
+		h.logger.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if int64(len(data)) > h.maxSize {
+		http.Error(w, tooLarge, http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	info, err := media.Inspect(data)
+	if err != nil {
+		h.logger.Println(err)
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	}
+
+	item, err := h.repository.GetMediaByName(ctx, info.Name)
+	if errors.Is(err, sql.ErrNoRows) {
+		item, err = h.store(ctx, info, header.Filename, data)
+	}
+	if err != nil {
+		h.logger.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.logger.Printf("Uploaded media %s (%s)\n", item.Name, item.OriginalName)
+
+	if r.Header.Get("HX-Request") != "" {
+		w.Header().Set("HX-Location", "/admin/media")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+
+	if err := json.NewEncoder(w).Encode(newMediaResponse(item)); err != nil {
+		h.logger.Println("Error encoding media to JSON:", err)
+	}
+}
+
+func (h *MediaHandler) store(ctx context.Context, info media.Info, originalName string, data []byte) (repository.Media, error) {
+	if err := h.storage.Put(ctx, info.Name, bytes.NewReader(data), info.Size, info.MimeType); err != nil {
+		return repository.Media{}, err
+	}
+
+	return h.repository.CreateMedia(ctx, repository.CreateMediaParams{
+		Name:         info.Name,
+		OriginalName: originalName,
+		MimeType:     info.MimeType,
+		Size:         info.Size,
+		Width:        int64(info.Width),
+		Height:       int64(info.Height),
+		CreatedAt:    sql.NullTime{Time: time.Now().In(h.location), Valid: true},
+	})
+}
+
+func newMediaResponse(item repository.Media) mediaResponse {
+	return mediaResponse{
+		ID:           item.ID,
+		Name:         item.Name,
+		URL:          media.URL(item.Name),
+		OriginalName: item.OriginalName,
+		MimeType:     item.MimeType,
+		Size:         item.Size,
+		Width:        item.Width,
+		Height:       item.Height,
+		Markdown:     fmt.Sprintf("![%s](%s)", item.OriginalName, media.URL(item.Name)),
+	}
+}
+
+// Serve sends a stored file. Names are content hashes, so files never change
+// and can be cached forever. Storages that link to files directly, like S3
+// with a public or presigned URL, get a redirect instead.
+func (h *MediaHandler) Serve(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	name := r.PathValue("name")
+
+	item, err := h.repository.GetMediaByName(ctx, name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	if linker, ok := h.storage.(media.Linker); ok {
+		link, err := linker.Link(ctx, item.Name)
+		if err != nil {
+			h.logger.Println(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if link != "" {
+			http.Redirect(w, r, link, http.StatusFound)
+			return
+		}
+	}
+
+	file, err := h.storage.Open(ctx, item.Name)
+	if err != nil {
+		h.logger.Println(err)
+		http.NotFound(w, r)
+		return
+	}
+	defer file.Close()
+
+	w.Header().Set("Content-Type", item.MimeType)
+	w.Header().Set("Content-Length", strconv.FormatInt(item.Size, 10))
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+
+	if _, err := io.Copy(w, file); err != nil {
+		h.logger.Println("Error sending media:", err)
+	}
+}
+
+// Library lists the uploaded media, optionally filtered by the "q" parameter.
+func (h *MediaHandler) Library(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	search := r.URL.Query().Get("q")
+
+	items, err := h.repository.SearchMedia(ctx, sql.NullString{String: search, Valid: search != ""})
+	if err != nil {
+		h.logger.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	library := make([]repository.MediaWithUsage, 0, len(items))
+	for _, item := range items {
+		usedBy, err := h.repository.GetPostsReferencingMedia(ctx, sql.NullString{String: item.Name, Valid: true})
+		if err != nil {
+			h.logger.Println(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		library = append(library, repository.MediaWithUsage{Media: item, UsedBy: usedBy})
+	}
+
+	user, _ := requestUser(r)
+
+	libraryPage := pages.MediaLibraryPage(h.blogName, h.pagetitle, library, search, user.CanManageContent())
+
+	page := pages.Root(h.blogName, libraryPage)
+	page.Render(ctx, w)
+}
+
+// Delete removes an uploaded file and its resized variants. Files still
+// referenced by posts are only deleted when the "force" parameter is set.
+func (h *MediaHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	user, _ := requestUser(r)
+	if !user.CanManageContent() {
+		http.Error(w, "Only editors and admins can delete media", http.StatusForbidden)
+		return
+	}
+
+	ctx := r.Context()
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid media id", http.StatusBadRequest)
+		return
+	}
+
+	item, err := h.repository.GetMediaByID(ctx, id)
+	if err != nil {
+		h.logger.Println(err)
+		http.Error(w, fmt.Sprintf("Media not found: %s", err.Error()), http.StatusNotFound)
+		return
+	}
+
+	usedBy, err := h.repository.GetPostsReferencingMedia(ctx, sql.NullString{String: item.Name, Valid: true})
+	if err != nil {
+		h.logger.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if len(usedBy) > 0 && r.URL.Query().Get("force") != "true" {
+		http.Error(w, fmt.Sprintf("media is still used by %d posts", len(usedBy)), http.StatusConflict)
+		return
+	}
+
+	if err := h.storage.Delete(ctx, item.Name); err != nil {
+		h.logger.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.variants.Purge(media.URL(item.Name)); err != nil {
+		h.logger.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.repository.DeleteMedia(ctx, item.ID); err != nil {
+		h.logger.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.audit.Record(r, user, AuditMediaDelete, "media:"+item.Name, mediaSummary{
+		OriginalName: item.OriginalName,
+		MimeType:     item.MimeType,
+		Size:         item.Size,
+		UsedBy:       len(usedBy),
+	}, nil)
+
+	w.Header().Set("HX-Location", "/admin/media")
+	w.WriteHeader(http.StatusOK)
+
+	w.Write([]byte("Media deleted"))
+}
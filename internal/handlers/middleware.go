@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/luizgustavojunqueira/Blogo/internal/auth"
+)
+
+// LoadUser adds the user signed in with the session cookie or the API token
+// of the request to its context, for the public pages that change for
+// writers. Anonymous requests are served as they are.
+func LoadUser(authenticator Auth, logger *log.Logger, next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, ok := authenticate(r, authenticator, logger); ok {
+			r = r.WithContext(auth.WithUser(r.Context(), user))
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireUser is LoadUser for the routes only writers may use. Anonymous
+// requests are redirected to the home page, or rejected with 401 when they
+// come with an API token, which lacks the scope their method needs.
+func RequireUser(authenticator Auth, logger *log.Logger, next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, ok := authenticate(r, authenticator, logger)
+		if !ok {
+			unauthorized(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(auth.WithUser(r.Context(), user)))
+	})
+}
+
+// RequireSession is RequireUser for the account and admin pages, which API
+// tokens cannot use, so a leaked token cannot take over the account.
+func RequireSession(authenticator Auth, logger *log.Logger, next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, ok := authenticate(r, authenticator, logger)
+		if !ok || user.ViaAPIToken() {
+			unauthorized(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(auth.WithUser(r.Context(), user)))
+	})
+}
+
+// authenticate returns the user signed in with the session cookie or the API
+// token of the request. The boolean is false when there is neither.
+func authenticate(r *http.Request, authenticator Auth, logger *log.Logger) (auth.User, bool) {
+	user, err := authenticator.UserFromRequest(r)
+	if err != nil {
+		if !errors.Is(err, auth.ErrSessionNotFound) {
+			logger.Println("Not authenticated:", err)
+		}
+		return auth.User{}, false
+	}
+
+	return user, true
+}
+
+func unauthorized(w http.ResponseWriter, r *http.Request) {
+	if _, ok := auth.BearerToken(r); ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+// requestUser returns the user the middleware added to the context of the
+// request. The boolean is false for anonymous requests.
+func requestUser(r *http.Request) (auth.User, bool) {
+	return auth.UserFromContext(r.Context())
+}
@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/luizgustavojunqueira/Blogo/internal/auth"
+)
+
+// requestAuthMock signs in "writer" with the cookie "session=writer" and
+// with the API token "token", which has the read scope.
+type requestAuthMock struct{}
+
+func (requestAuthMock) UserFromRequest(r *http.Request) (auth.User, error) {
+	if token, ok := auth.BearerToken(r); ok {
+		if token != "token" {
+			return auth.User{}, auth.ErrAPITokenNotFound
+		}
+		return auth.User{ID: 1, Username: "writer", Role: auth.RoleAuthor, Scopes: []string{auth.ScopeRead}}, nil
+	}
+
+	if cookie, err := r.Cookie("session"); err == nil && cookie.Value == "writer" {
+		return auth.User{ID: 1, Username: "writer", Role: auth.RoleAuthor}, nil
+	}
+	return auth.User{}, auth.ErrSessionNotFound
+}
+
+func TestMiddleware(t *testing.T) {
+	// The handler answers with the user the middleware added, or "anonymous"
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if user, ok := requestUser(r); ok {
+			io.WriteString(w, user.Username)
+			return
+		}
+		io.WriteString(w, "anonymous")
+	}
+
+	logger := log.New(io.Discard, "", 0)
+	middleware := map[string]http.Handler{
+		"LoadUser":       LoadUser(requestAuthMock{}, logger, handler),
+		"RequireUser":    RequireUser(requestAuthMock{}, logger, handler),
+		"RequireSession": RequireSession(requestAuthMock{}, logger, handler),
+	}
+
+	tests := []struct {
+		name       string
+		middleware string
+		cookie     string
+		token      string
+		wantCode   int
+		wantBody   string
+	}{
+		{name: "Anonymous public page", middleware: "LoadUser", wantCode: http.StatusOK, wantBody: "anonymous"},
+		{name: "Session on a public page", middleware: "LoadUser", cookie: "writer", wantCode: http.StatusOK, wantBody: "writer"},
+		{name: "Wrong token on a public page", middleware: "LoadUser", token: "wrong", wantCode: http.StatusOK, wantBody: "anonymous"},
+		{name: "Anonymous writer route", middleware: "RequireUser", wantCode: http.StatusFound},
+		{name: "Expired session on a writer route", middleware: "RequireUser", cookie: "expired", wantCode: http.StatusFound},
+		{name: "Wrong token on a writer route", middleware: "RequireUser", token: "wrong", wantCode: http.StatusUnauthorized},
+		{name: "Session on a writer route", middleware: "RequireUser", cookie: "writer", wantCode: http.StatusOK, wantBody: "writer"},
+		{name: "Token on a writer route", middleware: "RequireUser", token: "token", wantCode: http.StatusOK, wantBody: "writer"},
+		{name: "Anonymous account page", middleware: "RequireSession", wantCode: http.StatusFound},
+		{name: "Session on an account page", middleware: "RequireSession", cookie: "writer", wantCode: http.StatusOK, wantBody: "writer"},
+		{name: "Token on an account page", middleware: "RequireSession", token: "token", wantCode: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/account/password", nil)
+			if tt.cookie != "" {
+				r.AddCookie(&http.Cookie{Name: "session", Value: tt.cookie})
+			}
+			if tt.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+
+			w := httptest.NewRecorder()
+			middleware[tt.middleware].ServeHTTP(w, r)
+
+			if w.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantCode)
+			}
+
+			switch tt.wantCode {
+			case http.StatusOK:
+				if got := w.Body.String(); got != tt.wantBody {
+					t.Errorf("served %q, want %q", got, tt.wantBody)
+				}
+			case http.StatusFound:
+				if location := w.Header().Get("Location"); location != "/" {
+					t.Errorf("redirected to %q, want /", location)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/luizgustavojunqueira/Blogo/internal/auth"
+	"github.com/luizgustavojunqueira/Blogo/internal/repository"
+)
+
+type OIDCIdentityRepository interface {
+	GetOIDCIdentity(ctx context.Context, arg repository.GetOIDCIdentityParams) (int64, error)
+	CreateOIDCIdentity(ctx context.Context, arg repository.CreateOIDCIdentityParams) error
+}
+
+type oidcIdentityStore struct {
+	repo     OIDCIdentityRepository
+	location *time.Location
+}
+
+// NewOIDCIdentityStore returns an auth.OIDCIdentities that keeps the links
+// between provider subjects and users in the oidc_identities table.
+func NewOIDCIdentityStore(repo OIDCIdentityRepository, location *time.Location) auth.OIDCIdentities {
+	return &oidcIdentityStore{repo: repo, location: location}
+}
+
+func (s *oidcIdentityStore) GetOIDCIdentity(ctx context.Context, issuer, subject string) (int64, error) {
+	userID, err := s.repo.GetOIDCIdentity(ctx, repository.GetOIDCIdentityParams{Issuer: issuer, Subject: subject})
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, auth.ErrUserNotFound
+	}
+
+	return userID, err
+}
+
+func (s *oidcIdentityStore) LinkOIDCIdentity(ctx context.Context, userID int64, issuer, subject string, linkedAt time.Time) error {
+	return s.repo.CreateOIDCIdentity(ctx, repository.CreateOIDCIdentityParams{
+		Issuer:    issuer,
+		Subject:   subject,
+		UserID:    userID,
+		CreatedAt: linkedAt.In(s.location),
+	})
+}
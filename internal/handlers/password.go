@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/luizgustavojunqueira/Blogo/internal/auth"
+	"github.com/luizgustavojunqueira/Blogo/internal/templates/pages"
+)
+
+type PasswordHandler struct {
+	auth      *auth.Auth
+	users     UserRepository
+	audit     *Auditor
+	baseURL   string
+	logger    *log.Logger
+	blogName  string
+	pagetitle string
+}
+
+func NewPasswordHandler(auth *auth.Auth, users UserRepository, audit *Auditor, baseURL string, logger *log.Logger, blogName, pagetitle string) *PasswordHandler {
+	return &PasswordHandler{
+		auth:      auth,
+		users:     users,
+		audit:     audit,
+		baseURL:   strings.TrimSuffix(baseURL, "/"),
+		logger:    logger,
+		blogName:  blogName,
+		pagetitle: pagetitle,
+	}
+}
+
+// Settings shows the form to change the password of the signed in user.
+func (h *PasswordHandler) Settings(w http.ResponseWriter, r *http.Request) {
+	page := pages.Root(h.blogName, pages.PasswordPage(h.blogName, h.pagetitle))
+	page.Render(r.Context(), w)
+}
+
+// Change sets the password of the signed in user after checking the current
+// one, and signs them out of their other sessions.
+func (h *PasswordHandler) Change(w http.ResponseWriter, r *http.Request) {
+	user, _ := requestUser(r)
+
+	password, ok := newPassword(w, r, user.Username)
+	if !ok {
+		return
+	}
+
+	var sessionID string
+	if cookie, err := r.Cookie(h.auth.GetCookieName()); err == nil {
+		sessionID, _ = h.auth.ParseToken(cookie.Value)
+	}
+
+	err := h.auth.ChangePassword(r.Context(), user.ID, r.FormValue("current"), password, sessionID)
+	if errors.Is(err, auth.ErrInvalidCredentials) {
+		http.Error(w, "The current password is wrong", http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, auth.ErrPasswordUnchanged) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		h.logger.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.audit.Record(r, user, AuditUserPasswordUpdate, "user:"+user.Username, nil, nil)
+
+	w.Write([]byte("Password changed. Your other sessions were signed out."))
+}
+
+// newPassword returns the new password of the form, once it is valid for
+// username and confirmed. Otherwise it writes the response and returns false.
+func newPassword(w http.ResponseWriter, r *http.Request, username string) (string, bool) {
+	password := r.FormValue("password")
+
+	if password != r.FormValue("confirm") {
+		http.Error(w, "The passwords do not match", http.StatusBadRequest)
+		return "", false
+	}
+
+	if err := auth.ValidateUser(username, password); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return "", false
+	}
+
+	return password, true
+}
+
+// ResetLink creates a link that sets a new password for a user without the
+// current one, for admins to hand to users who forgot theirs.
+func (h *PasswordHandler) ResetLink(w http.ResponseWriter, r *http.Request) {
+	admin, _ := requestUser(r)
+	if !admin.CanManageUsers() {
+		http.Error(w, "Only admins can reset passwords", http.StatusForbidden)
+		return
+	}
+
+	ctx := r.Context()
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user id", http.StatusBadRequest)
+		return
+	}
+
+	target, err := h.users.GetUserByID(ctx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		h.logger.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	token := h.auth.PasswordResetToken(toAuthUser(target))
+	link := h.siteURL(r) + "/password/reset/" + token
+
+	h.audit.Record(r, admin, AuditUserResetLink, "user:"+target.Username, nil, nil)
+
+	pages.PasswordResetLink(target.Username, link, time.Now().Add(auth.PasswordResetValidity)).Render(ctx, w)
+}
+
+// siteURL returns the public URL of the blog, or else the one of the request.
+func (h *PasswordHandler) siteURL(r *http.Request) string {
+	if h.baseURL != "" {
+		return h.baseURL
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// Reset sets a new password with the token of a reset link, which then stops
+// working, and signs the user out everywhere.
+func (h *PasswordHandler) Reset(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	token := r.PathValue("token")
+
+	user, err := h.auth.UserFromPasswordReset(ctx, token)
+	if errors.Is(err, auth.ErrInvalidResetToken) {
+		http.Error(w, "This password reset link is invalid, expired or was already used", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		h.logger.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		page := pages.Root(h.blogName, pages.PasswordResetPage(h.blogName, h.pagetitle, user.Username, token))
+		page.Render(ctx, w)
+		return
+	}
+
+	password, ok := newPassword(w, r, user.Username)
+	if !ok {
+		return
+	}
+
+	if err := h.auth.SetPassword(ctx, user, password); err != nil {
+		h.logger.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.audit.Record(r, user, AuditUserPasswordReset, "user:"+user.Username, nil, nil)
+
+	w.Header().Set("HX-Location", "/login")
+}
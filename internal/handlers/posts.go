@@ -1,37 +1,38 @@
 package handlers
 
 import (
-	"bytes"
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
-	"math"
 	"net/http"
 	"strings"
 	"time"
 
+	"github.com/luizgustavojunqueira/Blogo/internal/auth"
+	"github.com/luizgustavojunqueira/Blogo/internal/markdown"
 	"github.com/luizgustavojunqueira/Blogo/internal/repository"
 	"github.com/luizgustavojunqueira/Blogo/internal/templates/components"
 	"github.com/luizgustavojunqueira/Blogo/internal/templates/pages"
 
 	"github.com/yuin/goldmark"
-	"github.com/yuin/goldmark/extension"
-	"github.com/yuin/goldmark/parser"
-	"github.com/yuin/goldmark/renderer/html"
-
-	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
-	highlighting "github.com/yuin/goldmark-highlighting/v2"
 )
 
 type PostHandler struct {
 	repository PostRepository
 	tagsRepo   TagRepository
+	linksRepo  LinkRepository
+	mediaRepo  MediaRepository
+	usersRepo  UserRepository
 	md         goldmark.Markdown
+	trustedMD  goldmark.Markdown // Renders raw HTML, for the posts of admins
+	readTime   markdown.ReadTimeEstimator
+	maxUpload  int64
 	location   *time.Location
+	audit      *Auditor
 	logger     *log.Logger
-	auth       Auth
 	blogName   string
 	pagetitle  string
 }
@@ -46,33 +47,26 @@ type PostRepository interface {
 }
 
 type Auth interface {
-	ValidateToken(token string) (bool, error)
-	GetCookieName() string
+	UserFromRequest(r *http.Request) (auth.User, error)
 }
 
-func NewPostHandler(repo PostRepository, tagsRepo TagRepository, location *time.Location, logger *log.Logger, auth Auth, blogName, pagetitle string) *PostHandler {
-	md := goldmark.New(goldmark.WithExtensions(extension.GFM, extension.Table, extension.Typographer, highlighting.NewHighlighting(
-		highlighting.WithStyle("dracula"),
-		highlighting.WithFormatOptions(
-			chromahtml.WithLineNumbers(true),
-		),
-	)),
-		goldmark.WithParserOptions(
-			parser.WithAutoHeadingID(),
-			parser.WithAttribute(),
-		),
-		goldmark.WithRendererOptions(
-			html.WithUnsafe(),
-			html.WithHardWraps(),
-		))
+func NewPostHandler(repo PostRepository, tagsRepo TagRepository, linksRepo LinkRepository, mediaRepo MediaRepository, usersRepo UserRepository, readTime markdown.ReadTimeEstimator, images markdown.ImageSource, maxUploadSize int64, location *time.Location, audit *Auditor, logger *log.Logger, blogName, pagetitle string) *PostHandler {
+	md := markdown.New(markdown.ResponsiveImages(images))
+	trustedMD := markdown.NewTrusted(markdown.ResponsiveImages(images))
 
 	return &PostHandler{
 		repository: repo,
 		tagsRepo:   tagsRepo,
+		linksRepo:  linksRepo,
+		mediaRepo:  mediaRepo,
+		usersRepo:  usersRepo,
 		md:         md,
+		trustedMD:  trustedMD,
+		readTime:   readTime,
+		maxUpload:  maxUploadSize,
 		logger:     logger,
 		location:   location,
-		auth:       auth,
+		audit:      audit,
 		blogName:   blogName,
 		pagetitle:  pagetitle,
 	}
@@ -81,7 +75,7 @@ func NewPostHandler(repo PostRepository, tagsRepo TagRepository, location *time.
 func (h *PostHandler) GetPosts(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
-	authenticated := h.isAuthenticated(r)
+	user, authenticated := requestUser(r)
 
 	tag := r.PathValue("tag")
 
@@ -94,6 +88,21 @@ func (h *PostHandler) GetPosts(w http.ResponseWriter, r *http.Request) {
 		tagName = sql.NullString{String: "", Valid: false}
 	}
 
+	// The tag pattern matches any path of one segment, so unknown tags are
+	// answered as unknown pages
+	if tagName.Valid {
+		_, err := h.tagsRepo.GetTagByName(ctx, tag)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			h.logger.Println(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
 	rows, err := h.repository.GetPostsByTag(ctx, tagName)
 	if err != nil {
 		h.logger.Println(err)
@@ -102,8 +111,11 @@ func (h *PostHandler) GetPosts(w http.ResponseWriter, r *http.Request) {
 	}
 
 	posts := generatePostsWithTags(rows)
+	for i := range posts {
+		posts[i].Editable = authenticated && user.CanEditPost(posts[i].AuthorID.Int64)
+	}
 
-	mainPage := pages.MainPage(h.blogName, h.pagetitle, posts, authenticated, tagName.String)
+	mainPage := pages.MainPage(h.blogName, h.pagetitle, posts, authenticated, user.CanManageContent(), user.CanManageUsers(), tagName.String)
 
 	root := pages.Root(h.blogName, mainPage)
 
@@ -111,12 +123,7 @@ func (h *PostHandler) GetPosts(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *PostHandler) CreatePost(w http.ResponseWriter, r *http.Request) {
-	authenticated := h.isAuthenticated(r)
-
-	if !authenticated {
-		http.Redirect(w, r, "/", http.StatusFound)
-		return
-	}
+	user, authenticated := requestUser(r)
 
 	ctx := r.Context()
 
@@ -132,6 +139,7 @@ func (h *PostHandler) CreatePost(w http.ResponseWriter, r *http.Request) {
 	slug := r.FormValue("slug")
 	description := r.FormValue("description")
 	tags := r.FormValue("tags")
+	cover := coverFromForm(r)
 
 	if err := validatePost(title, content, slug); err != nil {
 		h.logger.Println(err)
@@ -139,8 +147,14 @@ func (h *PostHandler) CreatePost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var parsedContent bytes.Buffer
-	if err := h.md.Convert([]byte(content), &parsedContent); err != nil {
+	if err := h.validateCover(ctx, cover); err != nil {
+		h.logger.Println(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	parsedContent, err := h.convertMarkdown(ctx, content, user.CanWriteRawHTML())
+	if err != nil {
 		h.logger.Println(err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -153,16 +167,19 @@ func (h *PostHandler) CreatePost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	words := len(strings.Fields(content))
-	readTime := int(math.Ceil(float64(words) / 200.0))
-
 	post := repository.CreatePostParams{
 		Title:         title,
 		Toc:           toc,
 		Content:       content,
-		ParsedContent: parsedContent.String(),
+		ParsedContent: parsedContent.html,
 		Description:   sql.NullString{String: description, Valid: true},
-		Readtime:      sql.NullInt64{Int64: int64(readTime), Valid: true},
+		Readtime:      sql.NullInt64{Int64: int64(parsedContent.readTime.Minutes), Valid: true},
+		Words:         sql.NullInt64{Int64: int64(parsedContent.readTime.Words), Valid: true},
+		Excerpt:       sql.NullString{String: parsedContent.excerpt, Valid: parsedContent.excerpt != ""},
+		CoverImage:    cover.image,
+		CoverAlt:      cover.alt,
+		CoverCaption:  cover.caption,
+		AuthorID:      sql.NullInt64{Int64: user.ID, Valid: true},
 		Slug:          slug,
 		CreatedAt:     sql.NullTime{Time: time.Now().In(h.location), Valid: true},
 		ModifiedAt:    sql.NullTime{Time: time.Now().In(h.location), Valid: true},
@@ -175,62 +192,25 @@ func (h *PostHandler) CreatePost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	createdTags := make([]repository.Tag, 0)
-
-	if tags != "" {
-		tagNames := strings.Split(tags, ",")
-
-		h.logger.Printf("Creating tags: %v\n", tagNames)
-
-		for _, tagName := range tagNames {
-			tagName = strings.TrimSpace(tagName)
-			if tagName == "" {
-				continue
-			}
-
-			h.logger.Printf("Creating tag: %s\n", tagName)
-
-			err := h.tagsRepo.CreateTagIfNotExists(ctx, repository.CreateTagIfNotExistsParams{
-				Name:       tagName,
-				CreatedAt:  sql.NullTime{Time: time.Now().In(h.location), Valid: true},
-				ModifiedAt: sql.NullTime{Time: time.Now().In(h.location), Valid: true},
-			})
-			if err != nil {
-				h.logger.Println(err)
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-
-			tag, err := h.tagsRepo.GetTagByName(ctx, tagName)
-			if err != nil {
-				h.logger.Println(err)
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-
-			h.logger.Printf("Adding tag %s to post %s\n", tagName, createdPost.Slug)
-
-			err = h.tagsRepo.AddTagToPost(ctx, repository.AddTagToPostParams{
-				PostID: createdPost.ID,
-				TagID:  tag.ID,
-			})
-			if err != nil {
-				h.logger.Println(err)
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
+	if err := h.savePostLinks(ctx, createdPost.ID, parsedContent); err != nil {
+		h.logger.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
-			createdTag := repository.Tag{
-				ID:         tag.ID,
-				Name:       tag.Name,
-				CreatedAt:  tag.CreatedAt,
-				ModifiedAt: tag.ModifiedAt,
-			}
+	if err := h.updateLinkingPosts(ctx, createdPost.Slug, createdPost.Slug); err != nil {
+		h.logger.Println("Error updating linking posts:", err)
+	}
 
-			createdTags = append(createdTags, createdTag)
-		}
+	createdTags, err := h.savePostTags(r, user, createdPost.ID, tags)
+	if err != nil {
+		h.logger.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
+	h.audit.Record(r, user, AuditPostCreate, "post:"+createdPost.Slug, nil, newPostSummary(createdPost, createdTags))
+
 	w.Header().Set("HX-Location", "/")
 	w.WriteHeader(http.StatusOK)
 
@@ -242,7 +222,15 @@ func (h *PostHandler) CreatePost(w http.ResponseWriter, r *http.Request) {
 		ModifiedAt:    createdPost.ModifiedAt,
 		ParsedContent: createdPost.ParsedContent,
 		Description:   createdPost.Description,
+		Excerpt:       createdPost.Excerpt,
+		CoverImage:    createdPost.CoverImage,
+		CoverAlt:      createdPost.CoverAlt,
+		CoverCaption:  createdPost.CoverCaption,
+		AuthorID:      createdPost.AuthorID,
+		AuthorName:    sql.NullString{String: user.Username, Valid: true},
+		Editable:      true,
 		Readtime:      createdPost.Readtime,
+		Words:         createdPost.Words,
 		Content:       createdPost.Content,
 		Toc:           createdPost.Toc,
 		Tags:          createdTags,
@@ -257,18 +245,18 @@ func (h *PostHandler) Editor(w http.ResponseWriter, r *http.Request) {
 
 	slug := r.PathValue("slug")
 
-	authenticated := h.isAuthenticated(r)
+	user, authenticated := requestUser(r)
 
-	if !authenticated {
-		http.Redirect(w, r, "/", http.StatusFound)
+	library, err := h.mediaRepo.SearchMedia(ctx, sql.NullString{})
+	if err != nil {
+		h.logger.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	if slug != "" {
-		post, err := h.repository.GetPostBySlug(ctx, slug)
-		if err != nil {
-			h.logger.Println(err)
-			http.Error(w, fmt.Sprintf("Post not found: %s", err.Error()), http.StatusNotFound)
+		post, ok := h.editablePost(w, r, user, slug)
+		if !ok {
 			return
 		}
 
@@ -287,6 +275,10 @@ func (h *PostHandler) Editor(w http.ResponseWriter, r *http.Request) {
 			ModifiedAt:    post.ModifiedAt,
 			ParsedContent: post.ParsedContent,
 			Description:   post.Description,
+			Excerpt:       post.Excerpt,
+			CoverImage:    post.CoverImage,
+			CoverAlt:      post.CoverAlt,
+			CoverCaption:  post.CoverCaption,
 			Content:       post.Content,
 			Toc:           post.Toc,
 			Tags:          tags,
@@ -302,29 +294,24 @@ func (h *PostHandler) Editor(w http.ResponseWriter, r *http.Request) {
 
 		h.logger.Printf("Tags: %s\n", tagsJsonString)
 
-		editorPage := pages.EditorPage(h.blogName, h.pagetitle, postWithTags, true, authenticated, tagsJsonString)
+		editorPage := pages.EditorPage(h.blogName, h.pagetitle, postWithTags, true, authenticated, tagsJsonString, h.maxUpload, library)
 
 		page := pages.Root(h.blogName, editorPage)
 		page.Render(ctx, w)
 		return
 	}
 
-	editorPage := pages.EditorPage(h.blogName, h.pagetitle, repository.PostWithTags{}, false, authenticated, "")
+	editorPage := pages.EditorPage(h.blogName, h.pagetitle, repository.PostWithTags{}, false, authenticated, "", h.maxUpload, library)
 
 	page := pages.Root(h.blogName, editorPage)
 	page.Render(ctx, w)
 }
 
 func (h *PostHandler) ParseMarkdown(w http.ResponseWriter, r *http.Request) {
-	authenticated := h.isAuthenticated(r)
-
-	if !authenticated {
-		http.Redirect(w, r, "/", http.StatusFound)
-		return
-	}
-
 	ctx := r.Context()
 
+	user, _ := requestUser(r)
+
 	err := r.ParseForm()
 	if err != nil {
 		h.logger.Println(err)
@@ -336,9 +323,29 @@ func (h *PostHandler) ParseMarkdown(w http.ResponseWriter, r *http.Request) {
 	content := r.FormValue("content")
 	slug := r.FormValue("slug")
 	tags := r.FormValue("tags")
+	cover := coverFromForm(r)
+
+	// The preview of a saved post shows what saving renders, which depends on
+	// its author and not on who edits it
+	rawHTML := user.CanWriteRawHTML()
+	if editing := r.FormValue("editing"); editing != "" {
+		post, err := h.repository.GetPostBySlug(ctx, editing)
+		if err != nil {
+			h.logger.Println(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		rawHTML, err = h.rawHTMLAllowed(ctx, post.AuthorID)
+		if err != nil {
+			h.logger.Println(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
 
-	var buf bytes.Buffer
-	if err := h.md.Convert([]byte(content), &buf); err != nil {
+	rendered, err := h.convertMarkdown(ctx, content, rawHTML)
+	if err != nil {
 		h.logger.Println(err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -369,21 +376,28 @@ func (h *PostHandler) ParseMarkdown(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	words := len(strings.Fields(content))
-	readTime := int(math.Ceil(float64(words) / 200.0))
-
 	post := repository.PostWithTags{
 		Title:         title,
 		Content:       content,
-		ParsedContent: buf.String(),
-		Readtime:      sql.NullInt64{Int64: int64(readTime), Valid: true},
+		ParsedContent: rendered.html,
+		Readtime:      sql.NullInt64{Int64: int64(rendered.readTime.Minutes), Valid: true},
+		Words:         sql.NullInt64{Int64: int64(rendered.readTime.Words), Valid: true},
+		Excerpt:       sql.NullString{String: rendered.excerpt, Valid: rendered.excerpt != ""},
+		CoverImage:    cover.image,
+		CoverAlt:      cover.alt,
+		CoverCaption:  cover.caption,
 		Toc:           toc,
 		Slug:          slug,
 		Tags:          postTags,
 	}
 
-	markdown := components.Markdown(post)
-	markdown.Render(ctx, w)
+	unresolved := markdown.UnresolvedLinks(rendered.links)
+	if len(unresolved) > 0 {
+		components.LinkWarnings(unresolved).Render(ctx, w)
+	}
+
+	preview := components.Markdown(post)
+	preview.Render(ctx, w)
 }
 
 func (h *PostHandler) ViewPost(w http.ResponseWriter, r *http.Request) {
@@ -392,12 +406,17 @@ func (h *PostHandler) ViewPost(w http.ResponseWriter, r *http.Request) {
 	slug := r.PathValue("slug")
 
 	post, err := h.repository.GetPostBySlug(ctx, slug)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.NotFound(w, r)
+		return
+	}
 	if err != nil {
 		h.logger.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	authenticated := h.isAuthenticated(r)
+	user, authenticated := requestUser(r)
 
 	postTags, err := h.tagsRepo.GetTagsByPost(ctx, post.Slug)
 	if err != nil {
@@ -414,37 +433,75 @@ func (h *PostHandler) ViewPost(w http.ResponseWriter, r *http.Request) {
 		ModifiedAt:    post.ModifiedAt,
 		ParsedContent: post.ParsedContent,
 		Description:   post.Description,
+		Excerpt:       post.Excerpt,
+		CoverImage:    post.CoverImage,
+		CoverAlt:      post.CoverAlt,
+		CoverCaption:  post.CoverCaption,
 		Content:       post.Content,
 		Readtime:      post.Readtime,
+		Words:         post.Words,
 		Toc:           post.Toc,
+		AuthorID:      post.AuthorID,
+		Editable:      authenticated && user.CanEditPost(post.AuthorID.Int64),
 		Tags:          postTags,
 	}
 
-	postPage := pages.PostPage(h.blogName, h.pagetitle, postWithTags, authenticated)
+	if post.AuthorID.Valid {
+		author, err := h.usersRepo.GetUserByID(ctx, post.AuthorID.Int64)
+		if err != nil {
+			h.logger.Println(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		postWithTags.AuthorName = sql.NullString{String: author.Username, Valid: true}
+	}
+
+	backlinks, err := h.linksRepo.GetBacklinks(ctx, sql.NullInt64{Int64: post.ID, Valid: true})
+	if err != nil {
+		h.logger.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	postPage := pages.PostPage(h.blogName, h.pagetitle, postWithTags, backlinks, authenticated)
 
 	page := pages.Root(h.blogName, postPage)
 	page.Render(ctx, w)
 }
 
 func (h *PostHandler) DeletePost(w http.ResponseWriter, r *http.Request) {
-	authenticated := h.isAuthenticated(r)
-
-	if !authenticated {
-		http.Redirect(w, r, "/", http.StatusFound)
-		return
-	}
+	user, _ := requestUser(r)
 
 	ctx := r.Context()
 
 	slug := r.PathValue("slug")
 
-	err := h.repository.DeletePostBySlug(ctx, slug)
+	post, ok := h.editablePost(w, r, user, slug)
+	if !ok {
+		return
+	}
+
+	tags, err := h.tagsRepo.GetTagsByPost(ctx, slug)
 	if err != nil {
 		h.logger.Println(err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	err = h.repository.DeletePostBySlug(ctx, slug)
+	if err != nil {
+		h.logger.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.audit.Record(r, user, AuditPostDelete, "post:"+slug, newPostSummary(post, tags), nil)
+
+	if err := h.updateLinkingPosts(ctx, slug, slug); err != nil {
+		h.logger.Println("Error updating linking posts:", err)
+	}
+
 	w.Header().Set("HX-Location", "/")
 	w.WriteHeader(http.StatusOK)
 
@@ -452,22 +509,30 @@ func (h *PostHandler) DeletePost(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *PostHandler) EditPost(w http.ResponseWriter, r *http.Request) {
-	authenticated := h.isAuthenticated(r)
-
-	if !authenticated {
-		http.Redirect(w, r, "/", http.StatusFound)
-		return
-	}
+	user, _ := requestUser(r)
 
 	ctx := r.Context()
 
 	slug := r.PathValue("slug")
 
+	oldPost, ok := h.editablePost(w, r, user, slug)
+	if !ok {
+		return
+	}
+
+	oldTags, err := h.tagsRepo.GetTagsByPost(ctx, slug)
+	if err != nil {
+		h.logger.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	newTitle := r.FormValue("title")
 	newSlug := r.FormValue("slug")
 	newContent := r.FormValue("content")
 	newDescription := r.FormValue("description")
 	newTags := r.FormValue("tags")
+	newCover := coverFromForm(r)
 
 	if err := validatePost(newTitle, newContent, newSlug); err != nil {
 		h.logger.Println(err)
@@ -475,22 +540,32 @@ func (h *PostHandler) EditPost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var parsedContent bytes.Buffer
-	if err := h.md.Convert([]byte(newContent), &parsedContent); err != nil {
+	if err := h.validateCover(ctx, newCover); err != nil {
+		h.logger.Println(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rawHTML, err := h.rawHTMLAllowed(ctx, oldPost.AuthorID)
+	if err != nil {
 		h.logger.Println(err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	toc, err := getPostToc(h.md, []byte(newContent))
+	parsedContent, err := h.convertMarkdown(ctx, newContent, rawHTML)
 	if err != nil {
 		h.logger.Println(err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	words := len(strings.Fields(newContent))
-	readTime := int(math.Ceil(float64(words) / 200.0))
+	toc, err := getPostToc(h.md, []byte(newContent))
+	if err != nil {
+		h.logger.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
 	post := repository.UpdatePostBySlugParams{
 		Title:         newTitle,
@@ -499,8 +574,13 @@ func (h *PostHandler) EditPost(w http.ResponseWriter, r *http.Request) {
 		Content:       newContent,
 		NewSlug:       newSlug,
 		Description:   sql.NullString{String: newDescription, Valid: true},
-		Readtime:      sql.NullInt64{Int64: int64(readTime), Valid: true},
-		ParsedContent: parsedContent.String(),
+		Readtime:      sql.NullInt64{Int64: int64(parsedContent.readTime.Minutes), Valid: true},
+		Words:         sql.NullInt64{Int64: int64(parsedContent.readTime.Words), Valid: true},
+		Excerpt:       sql.NullString{String: parsedContent.excerpt, Valid: parsedContent.excerpt != ""},
+		CoverImage:    newCover.image,
+		CoverAlt:      newCover.alt,
+		CoverCaption:  newCover.caption,
+		ParsedContent: parsedContent.html,
 		ModifiedAt:    sql.NullTime{Time: time.Now().In(h.location), Valid: true},
 	}
 
@@ -511,6 +591,24 @@ func (h *PostHandler) EditPost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := h.savePostLinks(ctx, updatedPost.ID, parsedContent); err != nil {
+		h.logger.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.updateLinkingPosts(ctx, slug, updatedPost.Slug); err != nil {
+		h.logger.Println("Error updating linking posts:", err)
+	}
+
+	// Like for a new post, the posts that already linked to the new slug
+	// showed the link as missing until now
+	if updatedPost.Slug != slug {
+		if err := h.updateLinkingPosts(ctx, updatedPost.Slug, updatedPost.Slug); err != nil {
+			h.logger.Println("Error updating linking posts:", err)
+		}
+	}
+
 	err = h.tagsRepo.ClearPostTagsBySlug(ctx, slug)
 	if err != nil {
 		h.logger.Println(err)
@@ -518,37 +616,101 @@ func (h *PostHandler) EditPost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if newTags != "" {
-		tagNames := strings.Split(newTags, ",")
-		for _, tagName := range tagNames {
-			tagName = strings.TrimSpace(tagName)
-			if tagName == "" {
-				continue
-			}
-			err := h.tagsRepo.CreateTagIfNotExists(ctx, repository.CreateTagIfNotExistsParams{
-				Name:       tagName,
-				CreatedAt:  sql.NullTime{Time: time.Now().In(h.location), Valid: true},
-				ModifiedAt: sql.NullTime{Time: time.Now().In(h.location), Valid: true},
-			})
-			if err != nil {
-				h.logger.Println(err)
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
+	savedTags, err := h.savePostTags(r, user, updatedPost.ID, newTags)
+	if err != nil {
+		h.logger.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
-			tag, err := h.tagsRepo.GetTagByName(ctx, tagName)
-			if err != nil {
-				h.logger.Println(err)
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
+	h.audit.Record(r, user, AuditPostUpdate, "post:"+updatedPost.Slug, newPostSummary(oldPost, oldTags), newPostSummary(updatedPost, savedTags))
+
+	w.Header().Set("HX-Location", "/")
+}
+
+// rawHTMLAllowed reports whether the raw HTML of a post written by the user
+// with ID authorID is rendered, which only depends on the author's role.
+func (h *PostHandler) rawHTMLAllowed(ctx context.Context, authorID sql.NullInt64) (bool, error) {
+	if !authorID.Valid {
+		return false, nil
+	}
+
+	author, err := h.usersRepo.GetUserByID(ctx, authorID.Int64)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return auth.User{Role: author.Role}.CanWriteRawHTML(), nil
+}
+
+// savePostTags adds the tags of the comma separated list to the post with ID
+// postID, creating the tags that do not exist yet, and returns them.
+func (h *PostHandler) savePostTags(r *http.Request, user auth.User, postID int64, tags string) ([]repository.Tag, error) {
+	ctx := r.Context()
+
+	savedTags := make([]repository.Tag, 0)
+
+	for _, tagName := range strings.Split(tags, ",") {
+		tagName = strings.TrimSpace(tagName)
+		if tagName == "" {
+			continue
+		}
 
-			err = h.tagsRepo.AddTagToPost(ctx, repository.AddTagToPostParams{
-				PostID: updatedPost.ID,
-				TagID:  tag.ID,
-			})
+		_, err := h.tagsRepo.GetTagByName(ctx, tagName)
+		isNew := errors.Is(err, sql.ErrNoRows)
+		if err != nil && !isNew {
+			return nil, err
+		}
+
+		err = h.tagsRepo.CreateTagIfNotExists(ctx, repository.CreateTagIfNotExistsParams{
+			Name:       tagName,
+			CreatedAt:  sql.NullTime{Time: time.Now().In(h.location), Valid: true},
+			ModifiedAt: sql.NullTime{Time: time.Now().In(h.location), Valid: true},
+		})
+		if err != nil {
+			return nil, err
 		}
+
+		tag, err := h.tagsRepo.GetTagByName(ctx, tagName)
+		if err != nil {
+			return nil, err
+		}
+
+		if isNew {
+			h.audit.Record(r, user, AuditTagCreate, "tag:"+tag.Name, nil, map[string]string{"name": tag.Name})
+		}
+
+		err = h.tagsRepo.AddTagToPost(ctx, repository.AddTagToPostParams{
+			PostID: postID,
+			TagID:  tag.ID,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		savedTags = append(savedTags, tag)
 	}
 
-	w.Header().Set("HX-Location", "/")
+	return savedTags, nil
+}
+
+// editablePost returns the post with the given slug when user may edit it.
+// Otherwise it writes the error response and returns false.
+func (h *PostHandler) editablePost(w http.ResponseWriter, r *http.Request, user auth.User, slug string) (repository.Post, bool) {
+	post, err := h.repository.GetPostBySlug(r.Context(), slug)
+	if err != nil {
+		h.logger.Println(err)
+		http.Error(w, fmt.Sprintf("Post not found: %s", err.Error()), http.StatusNotFound)
+		return repository.Post{}, false
+	}
+
+	if !user.CanEditPost(post.AuthorID.Int64) {
+		http.Error(w, "You are not allowed to change this post", http.StatusForbidden)
+		return repository.Post{}, false
+	}
+
+	return post, true
 }
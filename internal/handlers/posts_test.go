@@ -3,6 +3,7 @@ package handlers
 import (
 	"context"
 	"crypto/rand"
+	"database/sql"
 	"fmt"
 	"io"
 	"log"
@@ -13,7 +14,8 @@ import (
 	"testing"
 	"time"
 
-	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/luizgustavojunqueira/Blogo/internal/auth"
+	"github.com/luizgustavojunqueira/Blogo/internal/markdown"
 	"github.com/luizgustavojunqueira/Blogo/internal/repository"
 	"github.com/yuin/goldmark"
 	"github.com/yuin/goldmark/parser"
@@ -234,18 +236,26 @@ func (fa *authMock) GetCookieName() string {
 	return fa.cookieName
 }
 
-func (fa *authMock) ValidateToken(token string) (bool, error) {
+func (fa *authMock) UserFromRequest(r *http.Request) (auth.User, error) {
 	if fa.validToken {
-		return true, nil
+		return auth.User{ID: 1, Username: "admin", Role: auth.RoleAdmin}, nil
 	}
-	return false, fmt.Errorf("Invalid token")
+	return auth.User{}, fmt.Errorf("Invalid token")
 }
 
 type databaseMock struct {
 	posts []repository.Post
+	tags  []string
 }
 
+// queriesMock keeps the posts in memory. The repositories it embeds are nil,
+// for the methods the tests do not use.
 type queriesMock struct {
+	TagRepository
+	LinkRepository
+	MediaRepository
+	UserRepository
+
 	dbMock *databaseMock
 }
 
@@ -253,7 +263,26 @@ func (fq *queriesMock) GetPosts(ctx context.Context) ([]repository.Post, error)
 	return fq.dbMock.posts, nil
 }
 
-func (fq *queriesMock) UpdatePostBySlug(ctx context.Context, arg repository.UpdatePostBySlugParams) error {
+func (fq *queriesMock) GetPostsByTag(ctx context.Context, tagName sql.NullString) ([]repository.GetPostsByTagRow, error) {
+	rows := make([]repository.GetPostsByTagRow, 0, len(fq.dbMock.posts))
+	for _, post := range fq.dbMock.posts {
+		rows = append(rows, repository.GetPostsByTagRow{
+			ID:            post.ID,
+			Title:         post.Title,
+			Content:       post.Content,
+			Toc:           post.Toc,
+			ParsedContent: post.ParsedContent,
+			Slug:          post.Slug,
+			Description:   post.Description,
+			AuthorID:      post.AuthorID,
+			CreatedAt:     post.CreatedAt,
+			ModifiedAt:    post.ModifiedAt,
+		})
+	}
+	return rows, nil
+}
+
+func (fq *queriesMock) UpdatePostBySlug(ctx context.Context, arg repository.UpdatePostBySlugParams) (repository.Post, error) {
 	for i, post := range fq.dbMock.posts {
 		if post.Slug == arg.Slug {
 			fq.dbMock.posts[i].Title = arg.Title
@@ -261,10 +290,17 @@ func (fq *queriesMock) UpdatePostBySlug(ctx context.Context, arg repository.Upda
 			fq.dbMock.posts[i].ModifiedAt = arg.ModifiedAt
 			fq.dbMock.posts[i].ParsedContent = arg.ParsedContent
 			fq.dbMock.posts[i].Toc = arg.Toc
-			return nil
+			return fq.dbMock.posts[i], nil
 		}
 	}
-	return fmt.Errorf("Post not found")
+	return repository.Post{}, fmt.Errorf("Post not found")
+}
+
+func (fq *queriesMock) GetTagByName(ctx context.Context, name string) (repository.Tag, error) {
+	if !slices.Contains(fq.dbMock.tags, name) {
+		return repository.Tag{}, sql.ErrNoRows
+	}
+	return repository.Tag{Name: name}, nil
 }
 
 func (fq *queriesMock) GetPostBySlug(ctx context.Context, slug string) (repository.Post, error) {
@@ -308,11 +344,11 @@ func TestPostHandler_GetPosts(t *testing.T) {
 					Title:         "Post de Teste",
 					Content:       "Conteúdo do post de teste",
 					Slug:          "post-de-teste",
-					CreatedAt:     pgtype.Timestamp{Time: time.Now(), Valid: true},
-					ModifiedAt:    pgtype.Timestamp{Time: time.Now(), Valid: true},
+					CreatedAt:     sql.NullTime{Time: time.Now(), Valid: true},
+					ModifiedAt:    sql.NullTime{Time: time.Now(), Valid: true},
 					ParsedContent: "<p>Conteúdo do post de teste</p>",
 					Toc:           "<ul><li><a href=\"#post-de-teste\">Post de Teste</a></li></ul>",
-					Description:   pgtype.Text{String: "Descrição do post de teste", Valid: true},
+					Description:   sql.NullString{String: "Descrição do post de teste", Valid: true},
 				},
 			},
 		},
@@ -343,7 +379,6 @@ func TestPostHandler_GetPosts(t *testing.T) {
 			wantBodyContains: []string{
 				"Página de Teste",
 				"Post de Teste",
-				"Login",
 				"Descrição do post de teste",
 			},
 			dontWantBodyContains: []string{
@@ -388,14 +423,8 @@ func TestPostHandler_GetPosts(t *testing.T) {
 
 	for _, tt := range test {
 		t.Run(tt.name, func(t *testing.T) {
-			postHandler := NewPostHandler(
-				tt.args.fakeQueriesInstance,
-				tt.args.location,
-				tt.args.logger,
-				tt.args.fakeAuthInstance,
-				tt.args.pageTitle,
-				tt.args.title,
-			)
+			q := tt.args.fakeQueriesInstance
+			postHandler := NewPostHandler(q, q, q, q, q, markdown.ReadTimeEstimator{}, nil, 0, tt.args.location, nil, tt.args.logger, tt.args.pageTitle, tt.args.title)
 
 			req := httptest.NewRequest("GET", "/", nil)
 			req.AddCookie(&http.Cookie{
@@ -405,7 +434,7 @@ func TestPostHandler_GetPosts(t *testing.T) {
 
 			rr := httptest.NewRecorder()
 
-			postHandler.GetPosts(rr, req)
+			LoadUser(tt.args.fakeAuthInstance, tt.args.logger, postHandler.GetPosts).ServeHTTP(rr, req)
 
 			if rr.Code != tt.wantCode {
 				t.Errorf("Expected status %d, got %d", tt.wantCode, rr.Code)
@@ -581,3 +610,84 @@ func TestPostHandler_GetPosts(t *testing.T) {
 // 		t.Errorf("Response body does not contain expected toc. Got: %s", respBody)
 // 	}
 // }
+
+func TestPostHandler_GetPosts_Tag(t *testing.T) {
+	q := &queriesMock{dbMock: &databaseMock{tags: []string{"go"}}}
+	logger := log.New(io.Discard, "", 0)
+	postHandler := NewPostHandler(q, q, q, q, q, markdown.ReadTimeEstimator{}, nil, 0, time.UTC, nil, logger, "Blog", "Blog")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{tag}", postHandler.GetPosts)
+
+	tests := []struct {
+		path     string
+		wantCode int
+	}{
+		{path: "/go", wantCode: http.StatusOK},
+		{path: "/unknown", wantCode: http.StatusNotFound},
+		{path: "/logout", wantCode: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			if w.Code != tt.wantCode {
+				t.Errorf("GET %s status = %d, want %d", tt.path, w.Code, tt.wantCode)
+			}
+		})
+	}
+}
+
+// rawHTMLMock has an admin with ID 1 and an author with ID 2, and keeps the
+// HTML the posts are rendered to.
+type rawHTMLMock struct {
+	LinkRepository
+	UserRepository
+
+	rendered map[int64]string
+}
+
+func (m *rawHTMLMock) GetUserByID(ctx context.Context, id int64) (repository.User, error) {
+	switch id {
+	case 1:
+		return repository.User{ID: 1, Username: "admin", Role: auth.RoleAdmin}, nil
+	case 2:
+		return repository.User{ID: 2, Username: "author", Role: auth.RoleAuthor}, nil
+	}
+	return repository.User{}, sql.ErrNoRows
+}
+
+func (m *rawHTMLMock) UpdatePostContent(ctx context.Context, arg repository.UpdatePostContentParams) error {
+	m.rendered[arg.ID] = arg.ParsedContent
+	return nil
+}
+
+func (m *rawHTMLMock) ClearPostLinks(ctx context.Context, sourcePostID int64) error {
+	return nil
+}
+
+func TestPostHandler_RerenderPosts_RawHTML(t *testing.T) {
+	content := "Hello <kbd>Ctrl</kbd>"
+
+	q := &queriesMock{dbMock: &databaseMock{posts: []repository.Post{
+		{ID: 1, Slug: "admin-post", Content: content, AuthorID: sql.NullInt64{Int64: 1, Valid: true}},
+		{ID: 2, Slug: "author-post", Content: content, AuthorID: sql.NullInt64{Int64: 2, Valid: true}},
+		{ID: 3, Slug: "deleted-author-post", Content: content, AuthorID: sql.NullInt64{Int64: 3, Valid: true}},
+		{ID: 4, Slug: "no-author-post", Content: content},
+	}}}
+	m := &rawHTMLMock{rendered: make(map[int64]string)}
+
+	postHandler := NewPostHandler(q, q, m, q, m, markdown.ReadTimeEstimator{}, nil, 0, time.UTC, nil, log.New(io.Discard, "", 0), "Blog", "Blog")
+	if err := postHandler.RerenderPosts(context.Background()); err != nil {
+		t.Fatalf("RerenderPosts() error = %v", err)
+	}
+
+	for _, post := range q.dbMock.posts {
+		wantRawHTML := post.Slug == "admin-post"
+		if got := strings.Contains(m.rendered[post.ID], "<kbd>"); got != wantRawHTML {
+			t.Errorf("post %s rendered to %q, raw HTML = %v, want %v", post.Slug, m.rendered[post.ID], got, wantRawHTML)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/a-h/templ"
+	"github.com/luizgustavojunqueira/Blogo/internal/templates/pages"
+)
+
+// cspNonce returns the nonce of a Content-Security-Policy.
+func cspNonce(t *testing.T, policy string) string {
+	t.Helper()
+
+	match := regexp.MustCompile(`'nonce-([^']+)'`).FindStringSubmatch(policy)
+	if match == nil {
+		t.Fatalf("no nonce in the policy %q", policy)
+	}
+	return match[1]
+}
+
+func TestSecurityHeaders(t *testing.T) {
+	var contextNonce string
+	handler := SecurityHeaders(SecurityConfig{}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contextNonce = templ.GetNonce(r.Context())
+	}))
+
+	serve := func() http.Header {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		return w.Header()
+	}
+
+	header := serve()
+
+	wantHeaders := map[string]string{
+		"X-Content-Type-Options":    "nosniff",
+		"Referrer-Policy":           "strict-origin-when-cross-origin",
+		"Permissions-Policy":        DefaultPermissionsPolicy,
+		"Strict-Transport-Security": "",
+	}
+	for name, want := range wantHeaders {
+		if got := header.Get(name); got != want {
+			t.Errorf("header %s = %q, want %q", name, got, want)
+		}
+	}
+
+	policy := header.Get("Content-Security-Policy")
+	if header.Get("Content-Security-Policy-Report-Only") != "" {
+		t.Errorf("sent the report only policy without CSPReportOnly")
+	}
+	for _, unsafe := range []string{"'unsafe-eval'", "'unsafe-inline'", nonceTemplate} {
+		if strings.Contains(policy, unsafe) {
+			t.Errorf("policy %q contains %s", policy, unsafe)
+		}
+	}
+
+	nonce := cspNonce(t, policy)
+	if contextNonce != nonce {
+		t.Errorf("nonce in the context = %q, want %q of the policy", contextNonce, nonce)
+	}
+
+	if next := cspNonce(t, serve().Get("Content-Security-Policy")); next == nonce {
+		t.Errorf("two responses have the same nonce %q", nonce)
+	}
+}
+
+func TestSecurityHeaders_Config(t *testing.T) {
+	handler := SecurityHeaders(SecurityConfig{
+		ContentSecurityPolicy: "script-src 'nonce-{nonce}'",
+		CSPReportOnly:         true,
+		ReferrerPolicy:        "no-referrer",
+		HSTSMaxAge:            365 * 24 * time.Hour,
+		HSTSIncludeSubdomains: true,
+	}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if policy := w.Header().Get("Content-Security-Policy"); policy != "" {
+		t.Errorf("enforced the policy %q in report only mode", policy)
+	}
+
+	policy := w.Header().Get("Content-Security-Policy-Report-Only")
+	if want := "script-src 'nonce-" + cspNonce(t, policy) + "'"; policy != want {
+		t.Errorf("report only policy = %q, want %q", policy, want)
+	}
+
+	if got := w.Header().Get("Referrer-Policy"); got != "no-referrer" {
+		t.Errorf("Referrer-Policy = %q, want no-referrer", got)
+	}
+
+	if got, want := w.Header().Get("Strict-Transport-Security"), "max-age=31536000; includeSubDomains"; got != want {
+		t.Errorf("Strict-Transport-Security = %q, want %q", got, want)
+	}
+}
+
+func TestSecurityHeaders_RenderedNonce(t *testing.T) {
+	handler := SecurityHeaders(SecurityConfig{}, templ.Handler(pages.Root("Blog", templ.Raw("<p>Hello</p>"))))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	nonce := cspNonce(t, w.Header().Get("Content-Security-Policy"))
+
+	scripts := regexp.MustCompile(`<script[^>]*>`).FindAllString(w.Body.String(), -1)
+	if len(scripts) == 0 {
+		t.Fatalf("no scripts in %s", w.Body.String())
+	}
+
+	for _, script := range scripts {
+		if !strings.Contains(script, `nonce="`+nonce+`"`) {
+			t.Errorf("%s does not have the nonce %q of the response", script, nonce)
+		}
+		src := regexp.MustCompile(`src="/static/(js/[^"]+)"`).FindStringSubmatch(script)
+		if src == nil {
+			t.Errorf("%s is not one of the scripts of the blog", script)
+			continue
+		}
+		if _, err := os.Stat(filepath.Join("..", "static", src[1])); err != nil {
+			t.Errorf("%s is not in internal/static: %v", script, err)
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/luizgustavojunqueira/Blogo/internal/auth"
+	"github.com/luizgustavojunqueira/Blogo/internal/repository"
+	"github.com/luizgustavojunqueira/Blogo/internal/templates/pages"
+)
+
+type SessionRepository interface {
+	CreateSession(ctx context.Context, arg repository.CreateSessionParams) error
+	GetSession(ctx context.Context, id string) (repository.Session, error)
+	TouchSession(ctx context.Context, arg repository.TouchSessionParams) error
+	RenewSession(ctx context.Context, arg repository.RenewSessionParams) error
+	GetActiveSessions(ctx context.Context, now time.Time) ([]repository.GetActiveSessionsRow, error)
+	DeleteSession(ctx context.Context, id string) error
+	DeleteUserSessions(ctx context.Context, userID int64) error
+	DeleteUserSessionsExcept(ctx context.Context, arg repository.DeleteUserSessionsExceptParams) error
+	DeleteExpiredSessions(ctx context.Context, now time.Time) error
+}
+
+type sessionStore struct {
+	repo     SessionRepository
+	location *time.Location
+}
+
+// NewSessionStore returns an auth.Sessions that keeps the sessions in the
+// sessions table.
+func NewSessionStore(repo SessionRepository, location *time.Location) auth.Sessions {
+	return &sessionStore{repo: repo, location: location}
+}
+
+func (s *sessionStore) CreateSession(ctx context.Context, session auth.Session) error {
+	return s.repo.CreateSession(ctx, repository.CreateSessionParams{
+		ID:         session.ID,
+		UserID:     session.UserID,
+		CreatedAt:  session.CreatedAt.In(s.location),
+		LastSeenAt: session.LastSeenAt.In(s.location),
+		ExpiresAt:  session.ExpiresAt.In(s.location),
+		Ip:         session.IP,
+		UserAgent:  session.UserAgent,
+		Remember:   session.Remember,
+	})
+}
+
+func (s *sessionStore) GetSession(ctx context.Context, id string) (auth.Session, error) {
+	session, err := s.repo.GetSession(ctx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return auth.Session{}, auth.ErrSessionNotFound
+	}
+	if err != nil {
+		return auth.Session{}, err
+	}
+
+	return auth.Session{
+		ID:         session.ID,
+		UserID:     session.UserID,
+		CreatedAt:  session.CreatedAt,
+		LastSeenAt: session.LastSeenAt,
+		ExpiresAt:  session.ExpiresAt,
+		IP:         session.Ip,
+		UserAgent:  session.UserAgent,
+		Remember:   session.Remember,
+	}, nil
+}
+
+func (s *sessionStore) TouchSession(ctx context.Context, id string, lastSeenAt time.Time) error {
+	return s.repo.TouchSession(ctx, repository.TouchSessionParams{
+		LastSeenAt: lastSeenAt.In(s.location),
+		ID:         id,
+	})
+}
+
+func (s *sessionStore) RenewSession(ctx context.Context, id string, expiresAt time.Time) error {
+	return s.repo.RenewSession(ctx, repository.RenewSessionParams{
+		ExpiresAt: expiresAt.In(s.location),
+		ID:        id,
+	})
+}
+
+func (s *sessionStore) ListSessions(ctx context.Context, now time.Time) ([]auth.Session, error) {
+	rows, err := s.repo.GetActiveSessions(ctx, now.In(s.location))
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]auth.Session, 0, len(rows))
+	for _, row := range rows {
+		sessions = append(sessions, auth.Session{
+			ID:         row.ID,
+			UserID:     row.UserID,
+			Username:   row.Username,
+			CreatedAt:  row.CreatedAt,
+			LastSeenAt: row.LastSeenAt,
+			ExpiresAt:  row.ExpiresAt,
+			IP:         row.Ip,
+			UserAgent:  row.UserAgent,
+			Remember:   row.Remember,
+		})
+	}
+
+	return sessions, nil
+}
+
+func (s *sessionStore) DeleteSession(ctx context.Context, id string) error {
+	return s.repo.DeleteSession(ctx, id)
+}
+
+func (s *sessionStore) DeleteUserSessions(ctx context.Context, userID int64) error {
+	return s.repo.DeleteUserSessions(ctx, userID)
+}
+
+func (s *sessionStore) DeleteUserSessionsExcept(ctx context.Context, userID int64, keepID string) error {
+	return s.repo.DeleteUserSessionsExcept(ctx, repository.DeleteUserSessionsExceptParams{UserID: userID, ID: keepID})
+}
+
+func (s *sessionStore) DeleteExpiredSessions(ctx context.Context, now time.Time) error {
+	return s.repo.DeleteExpiredSessions(ctx, now.In(s.location))
+}
+
+type SessionRenewer interface {
+	GetCookieName() string
+	RenewSession(ctx context.Context, token string) (time.Time, bool, error)
+	SessionCookie(token string, expiry time.Time) *http.Cookie
+}
+
+// RenewSessions extends the session of requests past half its validity and
+// reissues the session cookie with the new expiry, so writers are not signed
+// out in the middle of a post.
+func RenewSessions(authenticator SessionRenewer, logger *log.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cookie, err := r.Cookie(authenticator.GetCookieName()); err == nil && cookie.Value != "" {
+			expiry, renewed, err := authenticator.RenewSession(r.Context(), cookie.Value)
+			if err != nil && !errors.Is(err, auth.ErrSessionNotFound) {
+				logger.Println(err)
+			}
+			if renewed {
+				http.SetCookie(w, authenticator.SessionCookie(cookie.Value, expiry))
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+type SessionsHandler struct {
+	auth      *auth.Auth
+	users     UserRepository
+	audit     *Auditor
+	logger    *log.Logger
+	blogName  string
+	pagetitle string
+}
+
+func NewSessionsHandler(auth *auth.Auth, users UserRepository, audit *Auditor, logger *log.Logger, blogName, pagetitle string) *SessionsHandler {
+	return &SessionsHandler{
+		auth:      auth,
+		users:     users,
+		audit:     audit,
+		logger:    logger,
+		blogName:  blogName,
+		pagetitle: pagetitle,
+	}
+}
+
+// admin returns the signed in user when they may manage sessions. Otherwise
+// it writes the response and returns false.
+func (h *SessionsHandler) admin(w http.ResponseWriter, r *http.Request) (auth.User, bool) {
+	user, _ := requestUser(r)
+	if !user.CanManageUsers() {
+		http.Error(w, "Only admins can manage sessions", http.StatusForbidden)
+		return auth.User{}, false
+	}
+
+	return user, true
+}
+
+// List shows the active sessions of every user, marking the one of the request.
+func (h *SessionsHandler) List(w http.ResponseWriter, r *http.Request) {
+	if _, ok := h.admin(w, r); !ok {
+		return
+	}
+
+	ctx := r.Context()
+
+	sessions, err := h.auth.ActiveSessions(ctx)
+	if err != nil {
+		h.logger.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var currentSessionID string
+	if cookie, err := r.Cookie(h.auth.GetCookieName()); err == nil {
+		currentSessionID, _ = h.auth.ParseToken(cookie.Value)
+	}
+
+	sessionsPage := pages.SessionsPage(h.blogName, h.pagetitle, sessions, currentSessionID)
+
+	page := pages.Root(h.blogName, sessionsPage)
+	page.Render(ctx, w)
+}
+
+// Revoke ends a single session, signing that browser out.
+func (h *SessionsHandler) Revoke(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.admin(w, r)
+	if !ok {
+		return
+	}
+
+	id := r.PathValue("id")
+
+	if err := h.auth.RevokeSession(r.Context(), id); err != nil {
+		h.logger.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.logger.Printf("%s revoked a session\n", user.Username)
+	h.audit.Record(r, user, AuditSessionRevoke, "session:"+id, nil, nil)
+
+	w.Header().Set("HX-Location", "/admin/sessions")
+}
+
+// RevokeUser ends every session of a user, signing them out everywhere.
+func (h *SessionsHandler) RevokeUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.admin(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user id", http.StatusBadRequest)
+		return
+	}
+
+	target, err := h.users.GetUserByID(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		h.logger.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.auth.RevokeUserSessions(r.Context(), id); err != nil {
+		h.logger.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.logger.Printf("%s signed out every session of the user %d\n", user.Username, id)
+	h.audit.Record(r, user, AuditSessionRevokeUser, "user:"+target.Username, nil, nil)
+
+	w.Header().Set("HX-Location", "/admin/sessions")
+}
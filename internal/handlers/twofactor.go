@@ -0,0 +1,202 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/luizgustavojunqueira/Blogo/internal/auth"
+	"github.com/luizgustavojunqueira/Blogo/internal/qr"
+	"github.com/luizgustavojunqueira/Blogo/internal/repository"
+	"github.com/luizgustavojunqueira/Blogo/internal/templates/pages"
+)
+
+type TwoFactorRepository interface {
+	GetUserTOTP(ctx context.Context, id int64) (repository.GetUserTOTPRow, error)
+	UpdateUserTOTP(ctx context.Context, arg repository.UpdateUserTOTPParams) error
+	CreateRecoveryCode(ctx context.Context, arg repository.CreateRecoveryCodeParams) error
+	DeleteRecoveryCodes(ctx context.Context, userID int64) error
+	UseRecoveryCode(ctx context.Context, arg repository.UseRecoveryCodeParams) (int64, error)
+}
+
+type twoFactorStore struct {
+	repo     TwoFactorRepository
+	location *time.Location
+}
+
+// NewTwoFactorStore returns an auth.TwoFactorStore that keeps the TOTP state
+// in the users table and the recovery codes in the recovery_codes table.
+func NewTwoFactorStore(repo TwoFactorRepository, location *time.Location) auth.TwoFactorStore {
+	return &twoFactorStore{repo: repo, location: location}
+}
+
+func (s *twoFactorStore) GetTOTP(ctx context.Context, userID int64) (auth.TOTP, error) {
+	row, err := s.repo.GetUserTOTP(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return auth.TOTP{}, auth.ErrUserNotFound
+	}
+	if err != nil {
+		return auth.TOTP{}, err
+	}
+
+	return auth.TOTP{
+		Secret:      row.TotpSecret.String,
+		Enabled:     row.TotpEnabled,
+		LastCounter: row.TotpLastCounter,
+	}, nil
+}
+
+func (s *twoFactorStore) SaveTOTP(ctx context.Context, userID int64, totp auth.TOTP) error {
+	return s.repo.UpdateUserTOTP(ctx, repository.UpdateUserTOTPParams{
+		TotpSecret:      sql.NullString{String: totp.Secret, Valid: totp.Secret != ""},
+		TotpEnabled:     totp.Enabled,
+		TotpLastCounter: totp.LastCounter,
+		ModifiedAt:      sql.NullTime{Time: time.Now().In(s.location), Valid: true},
+		ID:              userID,
+	})
+}
+
+func (s *twoFactorStore) ReplaceRecoveryCodes(ctx context.Context, userID int64, hashes []string) error {
+	if err := s.repo.DeleteRecoveryCodes(ctx, userID); err != nil {
+		return err
+	}
+
+	for _, hash := range hashes {
+		err := s.repo.CreateRecoveryCode(ctx, repository.CreateRecoveryCodeParams{
+			UserID:    userID,
+			CodeHash:  hash,
+			CreatedAt: sql.NullTime{Time: time.Now().In(s.location), Valid: true},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *twoFactorStore) UseRecoveryCode(ctx context.Context, userID int64, hash string) (bool, error) {
+	rows, err := s.repo.UseRecoveryCode(ctx, repository.UseRecoveryCodeParams{
+		UsedAt:   sql.NullTime{Time: time.Now().In(s.location), Valid: true},
+		UserID:   userID,
+		CodeHash: hash,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return rows > 0, nil
+}
+
+type TwoFactorHandler struct {
+	twoFactor *auth.TwoFactor
+	audit     *Auditor
+	logger    *log.Logger
+	blogName  string
+	pagetitle string
+}
+
+func NewTwoFactorHandler(twoFactor *auth.TwoFactor, audit *Auditor, logger *log.Logger, blogName, pagetitle string) *TwoFactorHandler {
+	return &TwoFactorHandler{
+		twoFactor: twoFactor,
+		audit:     audit,
+		logger:    logger,
+		blogName:  blogName,
+		pagetitle: pagetitle,
+	}
+}
+
+// Settings shows whether two-factor authentication is enabled. When it is
+// not, it shows the secret of the enrollment and its QR code, starting the
+// enrollment on the first visit.
+func (h *TwoFactorHandler) Settings(w http.ResponseWriter, r *http.Request) {
+	user, _ := requestUser(r)
+
+	ctx := r.Context()
+
+	enabled, err := h.twoFactor.Enabled(ctx, user.ID)
+	if err != nil {
+		h.logger.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if enabled {
+		page := pages.Root(h.blogName, pages.TwoFactorPage(h.blogName, h.pagetitle, true, "", ""))
+		page.Render(ctx, w)
+		return
+	}
+
+	secret, uri, err := h.twoFactor.Begin(ctx, user)
+	if err != nil {
+		h.logger.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	code, err := qr.Encode([]byte(uri))
+	if err != nil {
+		h.logger.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	page := pages.Root(h.blogName, pages.TwoFactorPage(h.blogName, h.pagetitle, false, secret, code.SVG()))
+	page.Render(ctx, w)
+}
+
+// Confirm enables two-factor authentication with the first code of the
+// authenticator app and answers with the recovery codes.
+func (h *TwoFactorHandler) Confirm(w http.ResponseWriter, r *http.Request) {
+	user, _ := requestUser(r)
+
+	ctx := r.Context()
+
+	codes, err := h.twoFactor.Confirm(ctx, user.ID, r.FormValue("code"))
+	if errors.Is(err, auth.ErrInvalidCode) {
+		http.Error(w, "Invalid code, check the time of your device and try again", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		h.logger.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.logger.Printf("Enabled two-factor authentication for %s\n", user.Username)
+	h.audit.Record(r, user, AuditTwoFactorEnable, "user:"+user.Username, nil, nil)
+
+	pages.RecoveryCodes(codes).Render(ctx, w)
+}
+
+// Disable turns two-factor authentication off, after checking a current code.
+func (h *TwoFactorHandler) Disable(w http.ResponseWriter, r *http.Request) {
+	user, _ := requestUser(r)
+
+	ctx := r.Context()
+
+	err := h.twoFactor.Verify(ctx, user.ID, r.FormValue("code"))
+	if errors.Is(err, auth.ErrInvalidCode) {
+		http.Error(w, "Invalid code", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		h.logger.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.twoFactor.Disable(ctx, user.ID); err != nil {
+		h.logger.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.logger.Printf("Disabled two-factor authentication for %s\n", user.Username)
+	h.audit.Record(r, user, AuditTwoFactorDisable, "user:"+user.Username, nil, nil)
+
+	w.Header().Set("HX-Location", "/account/2fa")
+}
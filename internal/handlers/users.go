@@ -0,0 +1,367 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/luizgustavojunqueira/Blogo/internal/auth"
+	"github.com/luizgustavojunqueira/Blogo/internal/repository"
+	"github.com/luizgustavojunqueira/Blogo/internal/templates/pages"
+)
+
+type UserRepository interface {
+	CreateUser(ctx context.Context, arg repository.CreateUserParams) (repository.User, error)
+	GetUserByUsername(ctx context.Context, username string) (repository.User, error)
+	GetUserByID(ctx context.Context, id int64) (repository.User, error)
+	GetUserByEmail(ctx context.Context, email sql.NullString) (repository.User, error)
+	GetUsers(ctx context.Context) ([]repository.User, error)
+	UpdateUserRole(ctx context.Context, arg repository.UpdateUserRoleParams) error
+	UpdateUserEmail(ctx context.Context, arg repository.UpdateUserEmailParams) error
+	UpdateUserPassword(ctx context.Context, arg repository.UpdateUserPasswordParams) error
+	CountUsers(ctx context.Context) (int64, error)
+}
+
+type authUsers struct {
+	repo     UserRepository
+	location *time.Location
+}
+
+// NewAuthUsers returns an auth.Users that stores the accounts in the users table.
+func NewAuthUsers(repo UserRepository, location *time.Location) auth.Users {
+	return &authUsers{repo: repo, location: location}
+}
+
+func toAuthUser(user repository.User) auth.User {
+	return auth.User{ID: user.ID, Username: user.Username, PasswordHash: user.PasswordHash, Role: user.Role, Email: user.Email.String}
+}
+
+func (u *authUsers) GetUserByUsername(ctx context.Context, username string) (auth.User, error) {
+	user, err := u.repo.GetUserByUsername(ctx, username)
+	if errors.Is(err, sql.ErrNoRows) {
+		return auth.User{}, auth.ErrUserNotFound
+	}
+	if err != nil {
+		return auth.User{}, err
+	}
+
+	return toAuthUser(user), nil
+}
+
+func (u *authUsers) GetUserByID(ctx context.Context, id int64) (auth.User, error) {
+	user, err := u.repo.GetUserByID(ctx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return auth.User{}, auth.ErrUserNotFound
+	}
+	if err != nil {
+		return auth.User{}, err
+	}
+
+	return toAuthUser(user), nil
+}
+
+func (u *authUsers) GetUserByEmail(ctx context.Context, email string) (auth.User, error) {
+	user, err := u.repo.GetUserByEmail(ctx, sql.NullString{String: email, Valid: true})
+	if errors.Is(err, sql.ErrNoRows) {
+		return auth.User{}, auth.ErrUserNotFound
+	}
+	if err != nil {
+		return auth.User{}, err
+	}
+
+	return toAuthUser(user), nil
+}
+
+func (u *authUsers) CountUsers(ctx context.Context) (int64, error) {
+	return u.repo.CountUsers(ctx)
+}
+
+func (u *authUsers) CreateUser(ctx context.Context, username, passwordHash, role string) (auth.User, error) {
+	user, err := u.repo.CreateUser(ctx, repository.CreateUserParams{
+		Username:     username,
+		PasswordHash: passwordHash,
+		Role:         role,
+		CreatedAt:    sql.NullTime{Time: time.Now().In(u.location), Valid: true},
+		ModifiedAt:   sql.NullTime{Time: time.Now().In(u.location), Valid: true},
+	})
+	if err != nil {
+		return auth.User{}, err
+	}
+
+	return toAuthUser(user), nil
+}
+
+func (u *authUsers) UpdatePassword(ctx context.Context, userID int64, passwordHash string) error {
+	return u.repo.UpdateUserPassword(ctx, repository.UpdateUserPasswordParams{
+		PasswordHash: passwordHash,
+		ModifiedAt:   sql.NullTime{Time: time.Now().In(u.location), Valid: true},
+		ID:           userID,
+	})
+}
+
+type UsersHandler struct {
+	repository UserRepository
+	location   *time.Location
+	audit      *Auditor
+	logger     *log.Logger
+	blogName   string
+	pagetitle  string
+}
+
+func NewUsersHandler(repo UserRepository, location *time.Location, audit *Auditor, logger *log.Logger, blogName, pagetitle string) *UsersHandler {
+	return &UsersHandler{
+		repository: repo,
+		location:   location,
+		audit:      audit,
+		logger:     logger,
+		blogName:   blogName,
+		pagetitle:  pagetitle,
+	}
+}
+
+// admin returns the signed in user when they may manage users. Otherwise it
+// writes the response and returns false.
+func (h *UsersHandler) admin(w http.ResponseWriter, r *http.Request) (auth.User, bool) {
+	user, _ := requestUser(r)
+	if !user.CanManageUsers() {
+		http.Error(w, "Only admins can manage users", http.StatusForbidden)
+		return auth.User{}, false
+	}
+
+	return user, true
+}
+
+// List shows every user with their role.
+func (h *UsersHandler) List(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.admin(w, r)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+
+	users, err := h.repository.GetUsers(ctx)
+	if err != nil {
+		h.logger.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	usersPage := pages.UsersPage(h.blogName, h.pagetitle, users, auth.Roles, user.ID)
+
+	page := pages.Root(h.blogName, usersPage)
+	page.Render(ctx, w)
+}
+
+// Create adds a user with the username, password, role and optional email
+// address of the form.
+func (h *UsersHandler) Create(w http.ResponseWriter, r *http.Request) {
+	admin, ok := h.admin(w, r)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+
+	username := r.FormValue("username")
+	password := r.FormValue("password")
+	role := r.FormValue("role")
+
+	if err := auth.ValidateUser(username, password); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !auth.ValidRole(role) {
+		http.Error(w, "Invalid role", http.StatusBadRequest)
+		return
+	}
+
+	email, ok := h.email(w, r, 0)
+	if !ok {
+		return
+	}
+
+	if _, err := h.repository.GetUserByUsername(ctx, username); err == nil {
+		http.Error(w, "Username already taken", http.StatusConflict)
+		return
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		h.logger.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		h.logger.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	created, err := h.repository.CreateUser(ctx, repository.CreateUserParams{
+		Username:     username,
+		PasswordHash: hash,
+		Role:         role,
+		Email:        email,
+		CreatedAt:    sql.NullTime{Time: time.Now().In(h.location), Valid: true},
+		ModifiedAt:   sql.NullTime{Time: time.Now().In(h.location), Valid: true},
+	})
+	if err != nil {
+		h.logger.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.audit.Record(r, admin, AuditUserCreate, "user:"+created.Username, nil, newUserSummary(created))
+
+	w.Header().Set("HX-Location", "/admin/users")
+	w.WriteHeader(http.StatusCreated)
+}
+
+// userSummary is the state of an account recorded around its changes.
+type userSummary struct {
+	Username string `json:"username"`
+	Role     string `json:"role"`
+	Email    string `json:"email,omitempty"`
+}
+
+func newUserSummary(user repository.User) userSummary {
+	return userSummary{Username: user.Username, Role: user.Role, Email: user.Email.String}
+}
+
+// email returns the normalized email address of the form, or a null string
+// when it is empty. Addresses are unique, so one used by a user other than
+// userID is refused. Otherwise it writes the response and returns false.
+func (h *UsersHandler) email(w http.ResponseWriter, r *http.Request, userID int64) (sql.NullString, bool) {
+	if strings.TrimSpace(r.FormValue("email")) == "" {
+		return sql.NullString{}, true
+	}
+
+	email, err := auth.NormalizeEmail(r.FormValue("email"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return sql.NullString{}, false
+	}
+
+	address := sql.NullString{String: email, Valid: true}
+
+	if other, err := h.repository.GetUserByEmail(r.Context(), address); err == nil && other.ID != userID {
+		http.Error(w, "Email address already used", http.StatusConflict)
+		return sql.NullString{}, false
+	} else if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		h.logger.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return sql.NullString{}, false
+	}
+
+	return address, true
+}
+
+// UpdateEmail changes the email address a user gets login links at. An empty
+// address removes it.
+func (h *UsersHandler) UpdateEmail(w http.ResponseWriter, r *http.Request) {
+	admin, ok := h.admin(w, r)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user id", http.StatusBadRequest)
+		return
+	}
+
+	target, err := h.repository.GetUserByID(ctx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		h.logger.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	email, ok := h.email(w, r, id)
+	if !ok {
+		return
+	}
+
+	err = h.repository.UpdateUserEmail(ctx, repository.UpdateUserEmailParams{
+		Email:      email,
+		ModifiedAt: sql.NullTime{Time: time.Now().In(h.location), Valid: true},
+		ID:         id,
+	})
+	if err != nil {
+		h.logger.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	updated := target
+	updated.Email = email
+	h.audit.Record(r, admin, AuditUserEmailUpdate, "user:"+target.Username, newUserSummary(target), newUserSummary(updated))
+
+	w.Header().Set("HX-Location", "/admin/users")
+}
+
+// UpdateRole changes the role of a user. Admins cannot change their own role,
+// so there is always an admin left.
+func (h *UsersHandler) UpdateRole(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.admin(w, r)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user id", http.StatusBadRequest)
+		return
+	}
+
+	role := r.FormValue("role")
+	if !auth.ValidRole(role) {
+		http.Error(w, "Invalid role", http.StatusBadRequest)
+		return
+	}
+
+	if id == user.ID {
+		http.Error(w, "You cannot change your own role", http.StatusBadRequest)
+		return
+	}
+
+	target, err := h.repository.GetUserByID(ctx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		h.logger.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = h.repository.UpdateUserRole(ctx, repository.UpdateUserRoleParams{
+		Role:       role,
+		ModifiedAt: sql.NullTime{Time: time.Now().In(h.location), Valid: true},
+		ID:         id,
+	})
+	if err != nil {
+		h.logger.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	updated := target
+	updated.Role = role
+	h.audit.Record(r, user, AuditUserRoleUpdate, "user:"+target.Username, newUserSummary(target), newUserSummary(updated))
+
+	w.Header().Set("HX-Location", "/admin/users")
+}
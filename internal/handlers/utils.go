@@ -3,7 +3,6 @@ package handlers
 import (
 	"bytes"
 	"fmt"
-	"net/http"
 
 	"github.com/luizgustavojunqueira/Blogo/internal/repository"
 	"github.com/yuin/goldmark"
@@ -51,35 +50,6 @@ func getPostToc(md goldmark.Markdown, src []byte) (string, error) {
 	return tocBuf.String(), nil
 }
 
-func (h *PostHandler) isAuthenticated(r *http.Request) bool {
-	cookie, err := r.Cookie(h.auth.GetCookieName())
-
-	authenticated := false
-
-	if cookie == nil {
-		h.logger.Println("Cookie is nil")
-		return authenticated
-	}
-
-	if err != nil {
-		h.logger.Println("Error getting cookie:", err)
-		return authenticated
-	}
-
-	authenticated, err = h.auth.ValidateToken(cookie.Value)
-	if err != nil {
-		h.logger.Println(err)
-	}
-
-	if authenticated {
-		h.logger.Println("Authenticated")
-	} else {
-		h.logger.Println("Not authenticated")
-	}
-
-	return authenticated
-}
-
 func generatePostsWithTags(rows []repository.GetPostsByTagRow) []repository.PostWithTags {
 	result := make([]repository.PostWithTags, 0, len(rows))
 	var currentPost *repository.PostWithTags
@@ -96,7 +66,14 @@ func generatePostsWithTags(rows []repository.GetPostsByTagRow) []repository.Post
 				ParsedContent: row.ParsedContent,
 				Slug:          row.Slug,
 				Description:   row.Description,
+				Excerpt:       row.Excerpt,
+				CoverImage:    row.CoverImage,
+				CoverAlt:      row.CoverAlt,
+				CoverCaption:  row.CoverCaption,
+				AuthorID:      row.AuthorID,
+				AuthorName:    row.AuthorName,
 				Readtime:      row.Readtime,
+				Words:         row.Words,
 				CreatedAt:     row.CreatedAt,
 				ModifiedAt:    row.ModifiedAt,
 				Tags:          []repository.Tag{},
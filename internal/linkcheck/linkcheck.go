@@ -0,0 +1,287 @@
+package linkcheck
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Status of a checked link.
+const (
+	StatusOK      = "ok"
+	StatusBroken  = "broken"
+	StatusError   = "error"
+	StatusSkipped = "skipped"
+)
+
+// Result is the outcome of checking a single link.
+type Result struct {
+	URL        string
+	Status     string
+	StatusCode int    // HTTP status code, only set for external links
+	Message    string // Reason the link was considered broken or could not be checked
+}
+
+// Site answers whether the internal targets of a link exist.
+type Site interface {
+	PostExists(ctx context.Context, slug string) (bool, error)
+	TagExists(ctx context.Context, name string) (bool, error)
+	MediaExists(ctx context.Context, name string) (bool, error)
+}
+
+// HTTPChecker checks external links.
+type HTTPChecker interface {
+	Check(ctx context.Context, rawURL string) Result
+}
+
+type Config struct {
+	Site       Site        // Lookup for posts and tags, required
+	Static     fs.FS       // Files served under /static/, required
+	HTTP       HTTPChecker // Checker for external links, defaults to NewHTTPChecker(nil)
+	KnownPaths []string    // Internal paths that are always valid, like "/login"
+}
+
+// Checker checks the links and images in rendered posts.
+type Checker struct {
+	site       Site
+	static     fs.FS
+	http       HTTPChecker
+	knownPaths map[string]bool
+
+	mu       sync.Mutex
+	external map[string]Result
+}
+
+// New creates a new Checker from the provided configuration.
+// It returns an error if the configuration is invalid.
+func New(config Config) (*Checker, error) {
+	if config.Site == nil {
+		return nil, fmt.Errorf("a site lookup is required")
+	}
+
+	if config.Static == nil {
+		return nil, fmt.Errorf("a static file system is required")
+	}
+
+	if config.HTTP == nil {
+		config.HTTP = NewHTTPChecker(nil)
+	}
+
+	knownPaths := make(map[string]bool, len(config.KnownPaths)+1)
+	knownPaths["/"] = true
+	for _, p := range config.KnownPaths {
+		knownPaths[p] = true
+	}
+
+	return &Checker{
+		site:       config.Site,
+		static:     config.Static,
+		http:       config.HTTP,
+		knownPaths: knownPaths,
+		external:   make(map[string]Result),
+	}, nil
+}
+
+// Reset forgets the external links checked so far. External results are
+// cached so a link shared by many posts is requested only once per run.
+func (c *Checker) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.external = make(map[string]Result)
+}
+
+// CheckHTML checks every link and image found in the rendered HTML of a post.
+func (c *Checker) CheckHTML(ctx context.Context, html string) []Result {
+	links := ExtractLinks(html)
+	results := make([]Result, 0, len(links))
+
+	for _, link := range links {
+		results = append(results, c.Check(ctx, link))
+	}
+
+	return results
+}
+
+// Check checks a single link.
+func (c *Checker) Check(ctx context.Context, link string) Result {
+	u, err := url.Parse(link)
+	if err != nil {
+		return Result{URL: link, Status: StatusBroken, Message: "invalid URL"}
+	}
+
+	switch {
+	case u.Scheme == "http" || u.Scheme == "https":
+		return c.checkExternal(ctx, link)
+	case u.Scheme != "" || u.Host != "":
+		return Result{URL: link, Status: StatusSkipped, Message: "unsupported scheme"}
+	case u.Path == "":
+		// Anchors inside the same page
+		return Result{URL: link, Status: StatusSkipped}
+	default:
+		return c.checkInternal(ctx, link, u.Path)
+	}
+}
+
+func (c *Checker) checkExternal(ctx context.Context, link string) Result {
+	c.mu.Lock()
+	result, ok := c.external[link]
+	c.mu.Unlock()
+	if ok {
+		return result
+	}
+
+	result = c.http.Check(ctx, link)
+	result.URL = link
+
+	c.mu.Lock()
+	c.external[link] = result
+	c.mu.Unlock()
+
+	return result
+}
+
+func (c *Checker) checkInternal(ctx context.Context, link, p string) Result {
+	result := Result{URL: link, Status: StatusOK}
+
+	if !strings.HasPrefix(p, "/") {
+		result.Status = StatusSkipped
+		result.Message = "relative link"
+		return result
+	}
+
+	if c.knownPaths[p] {
+		return result
+	}
+
+	switch {
+	case strings.HasPrefix(p, "/static/"):
+		name := strings.TrimPrefix(path.Clean(p), "/static/")
+		if _, err := fs.Stat(c.static, name); err != nil {
+			result.Status = StatusBroken
+			result.Message = "file not found"
+		}
+		return result
+
+	case strings.HasPrefix(p, "/media/"):
+		exists, err := c.site.MediaExists(ctx, strings.TrimPrefix(p, "/media/"))
+		return siteResult(result, exists, err, "media not found")
+
+	case strings.HasPrefix(p, "/post/"):
+		slug := strings.TrimPrefix(p, "/post/")
+		exists, err := c.site.PostExists(ctx, slug)
+		return siteResult(result, exists, err, "post not found")
+
+	case strings.Count(p, "/") == 1:
+		exists, err := c.site.TagExists(ctx, strings.TrimPrefix(p, "/"))
+		return siteResult(result, exists, err, "tag not found")
+	}
+
+	result.Status = StatusSkipped
+	result.Message = "unknown internal path"
+	return result
+}
+
+func siteResult(result Result, exists bool, err error, notFound string) Result {
+	if err != nil {
+		result.Status = StatusError
+		result.Message = err.Error()
+	} else if !exists {
+		result.Status = StatusBroken
+		result.Message = notFound
+	}
+	return result
+}
+
+var linkAttrRegex = regexp.MustCompile(`(?i)\s(?:href|src)\s*=\s*(?:"([^"]*)"|'([^']*)')`)
+
+// ExtractLinks returns the targets of the href and src attributes in html,
+// without duplicates and in document order.
+func ExtractLinks(html string) []string {
+	seen := make(map[string]bool)
+	links := make([]string, 0)
+
+	for _, match := range linkAttrRegex.FindAllStringSubmatch(html, -1) {
+		link := match[1]
+		if link == "" {
+			link = match[2]
+		}
+
+		link = strings.TrimSpace(unescapeAttr(link))
+		if link == "" || seen[link] {
+			continue
+		}
+
+		seen[link] = true
+		links = append(links, link)
+	}
+
+	return links
+}
+
+var attrUnescaper = strings.NewReplacer("&amp;", "&", "&quot;", `"`, "&#39;", "'", "&lt;", "<", "&gt;", ">")
+
+func unescapeAttr(s string) string {
+	return attrUnescaper.Replace(s)
+}
+
+type httpChecker struct {
+	client *http.Client
+}
+
+// NewHTTPChecker returns an HTTPChecker that requests links with client,
+// trying HEAD first and falling back to GET for servers that reject it.
+// A nil client uses one with a 10 second timeout.
+func NewHTTPChecker(client *http.Client) HTTPChecker {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &httpChecker{client: client}
+}
+
+func (c *httpChecker) Check(ctx context.Context, rawURL string) Result {
+	result := Result{URL: rawURL}
+
+	code, err := c.do(ctx, http.MethodHead, rawURL)
+	if err == nil && (code == http.StatusMethodNotAllowed || code == http.StatusNotImplemented) {
+		code, err = c.do(ctx, http.MethodGet, rawURL)
+	}
+
+	if err != nil {
+		result.Status = StatusError
+		result.Message = err.Error()
+		return result
+	}
+
+	result.StatusCode = code
+	if code >= 400 {
+		result.Status = StatusBroken
+		result.Message = http.StatusText(code)
+	} else {
+		result.Status = StatusOK
+	}
+
+	return result
+}
+
+func (c *httpChecker) do(ctx context.Context, method, rawURL string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("User-Agent", "Blogo link checker")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+
+	return resp.StatusCode, nil
+}
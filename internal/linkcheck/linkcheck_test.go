@@ -0,0 +1,110 @@
+package linkcheck
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+type siteMock struct {
+	posts map[string]bool
+	tags  map[string]bool
+	media map[string]bool
+}
+
+func (s *siteMock) PostExists(ctx context.Context, slug string) (bool, error) {
+	return s.posts[slug], nil
+}
+
+func (s *siteMock) TagExists(ctx context.Context, name string) (bool, error) {
+	return s.tags[name], nil
+}
+
+func (s *siteMock) MediaExists(ctx context.Context, name string) (bool, error) {
+	return s.media[name], nil
+}
+
+func TestExtractLinks(t *testing.T) {
+	html := `<p><a href="/post/first">first</a> <img src='/static/a.png' alt="a"> ` +
+		`<a href="/post/first">again</a> <a href="https://example.com/?a=1&amp;b=2">ext</a> <a href="">empty</a></p>`
+
+	want := []string{"/post/first", "/static/a.png", "https://example.com/?a=1&b=2"}
+
+	if got := ExtractLinks(html); !reflect.DeepEqual(got, want) {
+		t.Errorf("ExtractLinks() = %v, want %v", got, want)
+	}
+}
+
+func TestChecker_Check(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch r.URL.Path {
+		case "/ok":
+			w.WriteHeader(http.StatusOK)
+		case "/head-not-allowed":
+			if r.Method == http.MethodHead {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	checker, err := New(Config{
+		Site: &siteMock{
+			posts: map[string]bool{"first-post": true},
+			tags:  map[string]bool{"go": true},
+			media: map[string]bool{"abc.png": true},
+		},
+		Static:     fstest.MapFS{"images/a.png": &fstest.MapFile{}},
+		HTTP:       NewHTTPChecker(server.Client()),
+		KnownPaths: []string{"/login"},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	tests := []struct {
+		link       string
+		wantStatus string
+		wantCode   int
+	}{
+		{link: "/post/first-post", wantStatus: StatusOK},
+		{link: "/post/missing-post", wantStatus: StatusBroken},
+		{link: "/go", wantStatus: StatusOK},
+		{link: "/rust", wantStatus: StatusBroken},
+		{link: "/login", wantStatus: StatusOK},
+		{link: "/", wantStatus: StatusOK},
+		{link: "/static/images/a.png", wantStatus: StatusOK},
+		{link: "/static/images/b.png", wantStatus: StatusBroken},
+		{link: "/media/abc.png", wantStatus: StatusOK},
+		{link: "/media/def.png", wantStatus: StatusBroken},
+		{link: "#section", wantStatus: StatusSkipped},
+		{link: "mailto:someone@example.com", wantStatus: StatusSkipped},
+		{link: server.URL + "/ok", wantStatus: StatusOK, wantCode: http.StatusOK},
+		{link: server.URL + "/head-not-allowed", wantStatus: StatusOK, wantCode: http.StatusOK},
+		{link: server.URL + "/missing", wantStatus: StatusBroken, wantCode: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.link, func(t *testing.T) {
+			got := checker.Check(context.Background(), tt.link)
+			if got.Status != tt.wantStatus || got.StatusCode != tt.wantCode {
+				t.Errorf("Check() = %+v, want status %v and code %v", got, tt.wantStatus, tt.wantCode)
+			}
+		})
+	}
+
+	before := requests
+	checker.Check(context.Background(), server.URL+"/ok")
+	if requests != before {
+		t.Errorf("Check() requested a cached external link again")
+	}
+}
@@ -0,0 +1,187 @@
+// Package mail sends email through an SMTP server.
+package mail
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+type SMTPConfig struct {
+	Host       string        // Host of the SMTP server, required
+	Port       string        // Defaults to 587. Port 465 uses TLS from the start instead of STARTTLS
+	Username   string        // Login of the server, no authentication when empty
+	Password   string        // Password of the server
+	From       string        // Sender address, like "Blog <blog@example.com>", required
+	RequireTLS bool          // Refuse to send when the server does not offer STARTTLS
+	Timeout    time.Duration // Time to deliver a message, defaults to 30 seconds
+}
+
+// SMTP sends plain text messages through an SMTP server. On port 465 the
+// connection uses TLS from the start, otherwise it is upgraded with STARTTLS
+// when the server offers it, or always with RequireTLS.
+type SMTP struct {
+	host        string
+	port        string
+	username    string
+	password    string
+	from        *mail.Address
+	implicitTLS bool
+	requireTLS  bool
+	timeout     time.Duration
+	now         func() time.Time
+}
+
+func NewSMTP(config SMTPConfig) (*SMTP, error) {
+	if config.Host == "" {
+		return nil, fmt.Errorf("an SMTP host is required")
+	}
+
+	from, err := mail.ParseAddress(config.From)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sender address %q: %w", config.From, err)
+	}
+
+	if config.Port == "" {
+		config.Port = "587"
+	}
+
+	if config.Timeout <= 0 {
+		config.Timeout = 30 * time.Second
+	}
+
+	return &SMTP{
+		host:        config.Host,
+		port:        config.Port,
+		username:    config.Username,
+		password:    config.Password,
+		from:        from,
+		implicitTLS: config.Port == "465",
+		requireTLS:  config.RequireTLS,
+		timeout:     config.Timeout,
+		now:         time.Now,
+	}, nil
+}
+
+// SendMail sends a plain text message to a single recipient.
+func (s *SMTP) SendMail(ctx context.Context, to, subject, body string) error {
+	recipient, err := mail.ParseAddress(to)
+	if err != nil {
+		return fmt.Errorf("invalid recipient address %q: %w", to, err)
+	}
+
+	message, err := s.message(recipient, subject, body)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	conn, err := s.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	client, err := smtp.NewClient(conn, s.host)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if !s.implicitTLS {
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			if err := client.StartTLS(&tls.Config{ServerName: s.host}); err != nil {
+				return err
+			}
+		} else if s.requireTLS {
+			return errors.New("the SMTP server does not offer STARTTLS")
+		}
+	}
+
+	if s.username != "" {
+		// PlainAuth refuses to send the password without TLS, except to localhost
+		if err := client.Auth(smtp.PlainAuth("", s.username, s.password, s.host)); err != nil {
+			return err
+		}
+	}
+
+	if err := client.Mail(s.from.Address); err != nil {
+		return err
+	}
+
+	if err := client.Rcpt(recipient.Address); err != nil {
+		return err
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.Write(message); err != nil {
+		return err
+	}
+
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
+
+// dial connects to the server, with TLS on port 465.
+func (s *SMTP) dial(ctx context.Context) (net.Conn, error) {
+	address := net.JoinHostPort(s.host, s.port)
+
+	if s.implicitTLS {
+		dialer := tls.Dialer{Config: &tls.Config{ServerName: s.host}}
+		return dialer.DialContext(ctx, "tcp", address)
+	}
+
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, "tcp", address)
+}
+
+// message returns the headers and body of a message, with CRLF line endings.
+func (s *SMTP) message(to *mail.Address, subject, body string) ([]byte, error) {
+	if strings.ContainsAny(subject, "\r\n") {
+		return nil, fmt.Errorf("the subject cannot contain line breaks")
+	}
+
+	id := make([]byte, 12)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+
+	_, domain, _ := strings.Cut(s.from.Address, "@")
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", s.from)
+	fmt.Fprintf(&b, "To: %s\r\n", to)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", s.now().Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "Message-ID: <%x@%s>\r\n", id, domain)
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	b.WriteString("\r\n")
+
+	body = strings.ReplaceAll(body, "\r\n", "\n")
+	b.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+
+	return []byte(b.String()), nil
+}
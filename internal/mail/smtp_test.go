@@ -0,0 +1,210 @@
+package mail
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeServer is a local SMTP stand-in that accepts every message, without
+// TLS or authentication, and records what it receives.
+type fakeServer struct {
+	listener net.Listener
+
+	mu       sync.Mutex
+	from     string
+	to       []string
+	messages []string
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	s := &fakeServer{listener: listener}
+	go s.serve()
+	return s
+}
+
+func (s *fakeServer) port() string {
+	_, port, _ := net.SplitHostPort(s.listener.Addr().String())
+	return port
+}
+
+func (s *fakeServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *fakeServer) handle(conn net.Conn) {
+	defer conn.Close()
+
+	text := textproto.NewConn(conn)
+	text.PrintfLine("220 localhost ESMTP")
+
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+
+		command, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(command) {
+		case "EHLO", "HELO":
+			text.PrintfLine("250 localhost")
+		case "MAIL":
+			s.mu.Lock()
+			s.from = arg
+			s.mu.Unlock()
+			text.PrintfLine("250 OK")
+		case "RCPT":
+			s.mu.Lock()
+			s.to = append(s.to, arg)
+			s.mu.Unlock()
+			text.PrintfLine("250 OK")
+		case "DATA":
+			text.PrintfLine("354 Go ahead")
+			data, err := text.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.messages = append(s.messages, string(data))
+			s.mu.Unlock()
+			text.PrintfLine("250 OK")
+		case "QUIT":
+			text.PrintfLine("221 Bye")
+			return
+		default:
+			text.PrintfLine("502 Not implemented")
+		}
+	}
+}
+
+func TestSMTP_SendMail(t *testing.T) {
+	server := newFakeServer(t)
+
+	s, err := NewSMTP(SMTPConfig{
+		Host: "127.0.0.1",
+		Port: server.port(),
+		From: "Blog <blog@example.com>",
+	})
+	if err != nil {
+		t.Fatalf("NewSMTP() error = %v", err)
+	}
+	s.now = func() time.Time { return time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC) }
+
+	body := "Open this link to log in:\nhttps://blog.example.com/login/email/abc\n.\nThanks"
+	if err := s.SendMail(context.Background(), "writer@example.com", "Log in to Blög", body); err != nil {
+		t.Fatalf("SendMail() error = %v", err)
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	if server.from != "FROM:<blog@example.com>" {
+		t.Errorf("MAIL %s, want FROM:<blog@example.com>", server.from)
+	}
+	if len(server.to) != 1 || server.to[0] != "TO:<writer@example.com>" {
+		t.Errorf("RCPT %v, want TO:<writer@example.com>", server.to)
+	}
+	if len(server.messages) != 1 {
+		t.Fatalf("received %d messages, want 1", len(server.messages))
+	}
+
+	message, err := textproto.NewReader(bufio.NewReader(strings.NewReader(server.messages[0]))).ReadMIMEHeader()
+	if err != nil {
+		t.Fatalf("reading the headers error = %v", err)
+	}
+
+	wantHeaders := map[string]string{
+		"From":         `"Blog" <blog@example.com>`,
+		"To":           "<writer@example.com>",
+		"Subject":      "=?utf-8?q?Log_in_to_Bl=C3=B6g?=",
+		"Date":         "Thu, 02 Jan 2025 03:04:05 +0000",
+		"Content-Type": "text/plain; charset=utf-8",
+	}
+	for name, want := range wantHeaders {
+		if got := message.Get(name); got != want {
+			t.Errorf("header %s = %q, want %q", name, got, want)
+		}
+	}
+
+	// ReadDotBytes turns CRLF into LF and undoes the dot-stuffing
+	if _, got, _ := strings.Cut(server.messages[0], "\n\n"); got != body+"\n" {
+		t.Errorf("body = %q, want %q", got, body+"\n")
+	}
+}
+
+func TestSMTP_SendMail_TLS(t *testing.T) {
+	server := newFakeServer(t)
+
+	s, err := NewSMTP(SMTPConfig{
+		Host:       "127.0.0.1",
+		Port:       server.port(),
+		From:       "blog@example.com",
+		RequireTLS: true,
+	})
+	if err != nil {
+		t.Fatalf("NewSMTP() error = %v", err)
+	}
+
+	if err := s.SendMail(context.Background(), "writer@example.com", "Subject", "Body"); err == nil {
+		t.Errorf("SendMail() sent without STARTTLS while TLS is required")
+	}
+
+	// Like on port 465, which talks TLS from the start
+	s.requireTLS = false
+	s.implicitTLS = true
+
+	if err := s.SendMail(context.Background(), "writer@example.com", "Subject", "Body"); err == nil {
+		t.Errorf("SendMail() sent to a server without TLS on the TLS port")
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	if len(server.messages) != 0 {
+		t.Errorf("received %d messages without TLS, want none", len(server.messages))
+	}
+}
+
+func TestSMTP_SendMail_Invalid(t *testing.T) {
+	s, err := NewSMTP(SMTPConfig{Host: "127.0.0.1", Port: "1", From: "blog@example.com"})
+	if err != nil {
+		t.Fatalf("NewSMTP() error = %v", err)
+	}
+
+	if err := s.SendMail(context.Background(), "not an address", "Subject", "Body"); err == nil {
+		t.Errorf("SendMail() accepted an invalid recipient")
+	}
+
+	if err := s.SendMail(context.Background(), "writer@example.com", "Subject\r\nBcc: victim@example.com", "Body"); err == nil {
+		t.Errorf("SendMail() accepted a subject with a line break")
+	}
+}
+
+func TestNewSMTP(t *testing.T) {
+	if _, err := NewSMTP(SMTPConfig{From: "blog@example.com"}); err == nil {
+		t.Errorf("NewSMTP() accepted a config without host")
+	}
+
+	if _, err := NewSMTP(SMTPConfig{Host: "localhost", From: "not an address"}); err == nil {
+		t.Errorf("NewSMTP() accepted an invalid sender")
+	}
+}
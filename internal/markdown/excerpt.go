@@ -0,0 +1,126 @@
+package markdown
+
+import (
+	"bytes"
+	"strings"
+	"unicode"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/text"
+)
+
+// MoreMarker ends the excerpt of a post when it is present.
+const MoreMarker = "<!--more-->"
+
+// DefaultExcerptLength is the maximum length, in characters, of an excerpt.
+const DefaultExcerptLength = 300
+
+// Excerpt returns a plain text excerpt of a parsed document: the text before
+// the MoreMarker, or the first paragraph when there is no marker. The result
+// is trimmed to a word boundary so it has at most maxLength characters.
+func Excerpt(doc ast.Node, source []byte, maxLength int) string {
+	paragraphs := make([]string, 0)
+	foundMore := false
+
+	var current bytes.Buffer
+	inParagraph := false
+
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		switch node := n.(type) {
+		case *ast.Paragraph:
+			if entering {
+				current.Reset()
+				inParagraph = true
+			} else {
+				inParagraph = false
+				paragraphs = append(paragraphs, current.String())
+			}
+			return ast.WalkContinue, nil
+		case *ast.HTMLBlock:
+			if entering && hasMoreMarker(node.Lines(), source) {
+				foundMore = true
+				return ast.WalkStop, nil
+			}
+			return ast.WalkSkipChildren, nil
+		case *ast.RawHTML:
+			if entering && hasMoreMarker(node.Segments, source) {
+				foundMore = true
+				if inParagraph {
+					paragraphs = append(paragraphs, current.String())
+				}
+				return ast.WalkStop, nil
+			}
+			return ast.WalkSkipChildren, nil
+		}
+
+		if !entering || !inParagraph {
+			return ast.WalkContinue, nil
+		}
+
+		switch node := n.(type) {
+		case *ast.Image:
+			return ast.WalkSkipChildren, nil
+		case *ast.AutoLink:
+			current.Write(node.Label(source))
+			return ast.WalkSkipChildren, nil
+		case *WikiLink:
+			current.WriteString(node.DisplayText())
+		case *ast.String:
+			current.Write(node.Value)
+		case *ast.Text:
+			current.Write(node.Value(source))
+			if node.SoftLineBreak() || node.HardLineBreak() {
+				current.WriteByte(' ')
+			}
+		}
+
+		return ast.WalkContinue, nil
+	})
+
+	var excerpt string
+	if foundMore {
+		excerpt = strings.Join(paragraphs, " ")
+	} else {
+		for _, paragraph := range paragraphs {
+			if strings.TrimSpace(paragraph) != "" {
+				excerpt = paragraph
+				break
+			}
+		}
+	}
+
+	return truncateWords(strings.Join(strings.Fields(excerpt), " "), maxLength)
+}
+
+func hasMoreMarker(segments *text.Segments, source []byte) bool {
+	for i := 0; i < segments.Len(); i++ {
+		segment := segments.At(i)
+		if bytes.Contains(segment.Value(source), []byte(MoreMarker)) {
+			return true
+		}
+	}
+	return false
+}
+
+// truncateWords cuts s at the last word boundary that keeps it, with the
+// trailing ellipsis, within maxLength characters.
+func truncateWords(s string, maxLength int) string {
+	runes := []rune(s)
+	if maxLength <= 0 || len(runes) <= maxLength {
+		return s
+	}
+
+	cut := maxLength - 1
+	for cut > 0 && !unicode.IsSpace(runes[cut]) {
+		cut--
+	}
+	if cut == 0 {
+		cut = maxLength - 1
+	}
+
+	truncated := strings.TrimRightFunc(string(runes[:cut]), func(r rune) bool {
+		return unicode.IsSpace(r) || unicode.IsPunct(r)
+	})
+
+	return truncated + "…"
+}
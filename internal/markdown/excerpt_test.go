@@ -0,0 +1,60 @@
+package markdown
+
+import (
+	"testing"
+
+	"github.com/yuin/goldmark/text"
+)
+
+func TestExcerpt(t *testing.T) {
+	md := New()
+
+	tests := []struct {
+		name      string
+		src       string
+		maxLength int
+		want      string
+	}{
+		{
+			name:      "First paragraph",
+			src:       "# Title\n\nThe **first** paragraph\nwith a [link](https://example.com).\n\nThe second paragraph.",
+			maxLength: DefaultExcerptLength,
+			want:      "The first paragraph with a link.",
+		},
+		{
+			name:      "Text before the more marker",
+			src:       "First paragraph.\n\n```go\ncode()\n```\n\nSecond paragraph.\n\n<!--more-->\n\nThird paragraph.",
+			maxLength: DefaultExcerptLength,
+			want:      "First paragraph. Second paragraph.",
+		},
+		{
+			name:      "Inline more marker",
+			src:       "Before the marker <!--more--> after the marker.",
+			maxLength: DefaultExcerptLength,
+			want:      "Before the marker",
+		},
+		{
+			name:      "Trimmed to a word boundary",
+			src:       "One two three, four five six.",
+			maxLength: 16,
+			want:      "One two three…",
+		},
+		{
+			name:      "No paragraphs",
+			src:       "# Only a title",
+			maxLength: DefaultExcerptLength,
+			want:      "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := []byte(tt.src)
+			doc := md.Parser().Parse(text.NewReader(src))
+
+			if got := Excerpt(doc, src, tt.maxLength); got != tt.want {
+				t.Errorf("Excerpt() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
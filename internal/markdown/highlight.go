@@ -0,0 +1,66 @@
+package markdown
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strings"
+
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/styles"
+)
+
+// Default highlighting themes, any chroma style name can be used instead.
+const (
+	DefaultLightTheme = "github"
+	DefaultDarkTheme  = "dracula"
+)
+
+// HighlightCSS returns the stylesheet for highlighted code blocks. The light
+// theme applies by default and the dark theme when the page has the dark class
+// toggled by pages.Root.
+func HighlightCSS(lightTheme, darkTheme string) ([]byte, error) {
+	var css bytes.Buffer
+
+	if err := writeThemeCSS(&css, lightTheme, ":root:not(.dark)"); err != nil {
+		return nil, err
+	}
+
+	if err := writeThemeCSS(&css, darkTheme, ".dark"); err != nil {
+		return nil, err
+	}
+
+	return css.Bytes(), nil
+}
+
+// writeThemeCSS writes the rules of a chroma style with every selector scoped
+// under scope.
+func writeThemeCSS(css *bytes.Buffer, theme, scope string) error {
+	style, ok := styles.Registry[theme]
+	if !ok {
+		return fmt.Errorf("unknown highlighting theme %q", theme)
+	}
+
+	var rules bytes.Buffer
+	if err := chromahtml.New(formatOptions...).WriteCSS(&rules, style); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(css, "/* %s */\n", theme)
+
+	scanner := bufio.NewScanner(&rules)
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		// Rules are written as "/* Token */ selector { declarations }"
+		start := strings.Index(line, "*/ ")
+		end := strings.Index(line, " {")
+		if start < 0 || end < start {
+			continue
+		}
+
+		css.WriteString(scope + " " + line[start+3:end] + line[end:] + "\n")
+	}
+
+	return scanner.Err()
+}
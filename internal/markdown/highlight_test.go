@@ -0,0 +1,41 @@
+package markdown
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestHighlightCSS(t *testing.T) {
+	css, err := HighlightCSS(DefaultLightTheme, DefaultDarkTheme)
+	if err != nil {
+		t.Fatalf("HighlightCSS() error = %v", err)
+	}
+
+	for _, want := range []string{":root:not(.dark) .chroma .k {", ".dark .chroma .k {", ".dark .chroma {"} {
+		if !strings.Contains(string(css), want) {
+			t.Errorf("HighlightCSS() does not contain %q", want)
+		}
+	}
+
+	if _, err := HighlightCSS("not-a-theme", DefaultDarkTheme); err == nil {
+		t.Errorf("HighlightCSS() with an unknown theme did not return an error")
+	}
+}
+
+func TestNew_HighlightsWithClasses(t *testing.T) {
+	var buf bytes.Buffer
+	if err := New().Convert([]byte("```go\nfunc main() {}\n```"), &buf); err != nil {
+		t.Fatalf("Convert() error = %v", err)
+	}
+
+	html := buf.String()
+
+	if !strings.Contains(html, `class="chroma"`) {
+		t.Errorf("Convert() = %v, want class based highlighting", html)
+	}
+
+	if strings.Contains(html, "style=") {
+		t.Errorf("Convert() = %v, want no inline styles", html)
+	}
+}
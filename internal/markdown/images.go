@@ -0,0 +1,140 @@
+package markdown
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/util"
+)
+
+// DefaultImageSizes is the sizes attribute of responsive images. Posts are
+// at most 768px wide and take the full width of smaller screens.
+const DefaultImageSizes = "(max-width: 768px) 100vw, 768px"
+
+// ImageSource provides the dimensions and resized variants of local images.
+type ImageSource interface {
+	// Size returns the dimensions of the image at src, or false if src is not
+	// a local image that can be resized.
+	Size(src string) (width, height int, ok bool)
+	// Widths returns the widths variants are generated at.
+	Widths() []int
+	// URL returns the URL of the variant of src with the given width.
+	URL(src string, width int) string
+}
+
+type imageRenderer struct {
+	html.Config
+	source ImageSource
+}
+
+func (r *imageRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(ast.KindImage, r.render)
+}
+
+func (r *imageRenderer) render(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+
+	n := node.(*ast.Image)
+
+	_, _ = w.WriteString(`<img src="`)
+	if r.Unsafe || !html.IsDangerousURL(n.Destination) {
+		_, _ = w.Write(util.EscapeHTML(util.URLEscape(n.Destination, true)))
+	}
+	_, _ = w.WriteString(`" alt="`)
+	_, _ = w.Write(util.EscapeHTML(altText(n, source)))
+	_ = w.WriteByte('"')
+
+	if n.Title != nil {
+		_, _ = w.WriteString(` title="`)
+		r.Writer.Write(w, n.Title)
+		_ = w.WriteByte('"')
+	}
+
+	if width, height, ok := r.source.Size(string(n.Destination)); ok {
+		if srcset := r.srcset(string(n.Destination), width); srcset != "" {
+			fmt.Fprintf(w, ` srcset="%s" sizes="%s"`, util.EscapeHTML([]byte(srcset)), DefaultImageSizes)
+		}
+		fmt.Fprintf(w, ` width="%d" height="%d" loading="lazy" decoding="async"`, width, height)
+	}
+
+	if n.Attributes() != nil {
+		html.RenderAttributes(w, n, html.ImageAttributeFilter)
+	}
+
+	if r.XHTML {
+		_, _ = w.WriteString(" />")
+	} else {
+		_, _ = w.WriteString(">")
+	}
+
+	return ast.WalkSkipChildren, nil
+}
+
+// srcset lists the variants narrower than the original, followed by the
+// original itself. It is empty when there are no smaller variants.
+func (r *imageRenderer) srcset(src string, width int) string {
+	candidates := make([]string, 0)
+
+	for _, w := range r.source.Widths() {
+		if w < width {
+			candidates = append(candidates, fmt.Sprintf("%s %dw", r.source.URL(src, w), w))
+		}
+	}
+
+	if len(candidates) == 0 {
+		return ""
+	}
+
+	candidates = append(candidates, fmt.Sprintf("%s %dw", util.URLEscape([]byte(src), true), width))
+
+	return strings.Join(candidates, ", ")
+}
+
+// altText returns the plain text of the image description.
+func altText(n ast.Node, source []byte) []byte {
+	var buf bytes.Buffer
+
+	_ = ast.Walk(n, func(node ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+
+		switch node := node.(type) {
+		case *ast.Text:
+			buf.Write(node.Segment.Value(source))
+			if node.SoftLineBreak() {
+				buf.WriteByte(' ')
+			}
+		case *ast.String:
+			buf.Write(node.Value)
+		}
+
+		return ast.WalkContinue, nil
+	})
+
+	return buf.Bytes()
+}
+
+type responsiveImages struct {
+	source ImageSource
+}
+
+// ResponsiveImages is a goldmark extension that renders local images with
+// the resized variants of source in srcset, their dimensions and lazy loading.
+// Other images render as usual.
+func ResponsiveImages(source ImageSource) goldmark.Extender {
+	return &responsiveImages{source: source}
+}
+
+func (e *responsiveImages) Extend(m goldmark.Markdown) {
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(
+		util.Prioritized(&imageRenderer{Config: html.NewConfig(), source: e.source}, 199),
+	))
+}
@@ -0,0 +1,67 @@
+package markdown
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+type imageSourceMock map[string][2]int
+
+func (m imageSourceMock) Size(src string) (int, int, bool) {
+	size, ok := m[src]
+	return size[0], size[1], ok
+}
+
+func (m imageSourceMock) Widths() []int {
+	return []int{480, 960}
+}
+
+func (m imageSourceMock) URL(src string, width int) string {
+	return fmt.Sprintf("/images/%d%s", width, src)
+}
+
+func TestResponsiveImages(t *testing.T) {
+	md := New(ResponsiveImages(imageSourceMock{
+		"/media/large.png": {1200, 800},
+		"/media/small.png": {300, 200},
+	}))
+
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{
+			name: "Local image with variants",
+			src:  `![A *large* image](/media/large.png "Title")`,
+			want: `<img src="/media/large.png" alt="A large image" title="Title" ` +
+				`srcset="/images/480/media/large.png 480w, /images/960/media/large.png 960w, /media/large.png 1200w" ` +
+				`sizes="` + DefaultImageSizes + `" width="1200" height="800" loading="lazy" decoding="async">`,
+		},
+		{
+			name: "Local image smaller than every variant",
+			src:  `![Small](/media/small.png)`,
+			want: `<img src="/media/small.png" alt="Small" width="300" height="200" loading="lazy" decoding="async">`,
+		},
+		{
+			name: "External image",
+			src:  `![External](https://example.com/a.png)`,
+			want: `<img src="https://example.com/a.png" alt="External">`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := md.Convert([]byte(tt.src), &buf); err != nil {
+				t.Fatalf("Convert() error = %v", err)
+			}
+
+			if got := buf.String(); !strings.Contains(got, tt.want) {
+				t.Errorf("Convert() = %v, want it to contain %v", got, tt.want)
+			}
+		})
+	}
+}
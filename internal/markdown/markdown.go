@@ -0,0 +1,53 @@
+package markdown
+
+import (
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/renderer/html"
+
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	highlighting "github.com/yuin/goldmark-highlighting/v2"
+)
+
+// Version identifies the HTML that New and NewTrusted render. Bump it whenever
+// a change to the pipeline renders stored posts differently, so they are
+// rendered again on the next start.
+const Version = 2
+
+// Code blocks are highlighted with CSS classes instead of inline colors, so
+// the theme follows the page; see HighlightCSS for the stylesheet.
+var formatOptions = []chromahtml.Option{
+	chromahtml.WithClasses(true),
+	chromahtml.WithLineNumbers(true),
+}
+
+// New returns the goldmark pipeline used to render posts, with any extra
+// extensions like ResponsiveImages. Raw HTML in the Markdown is left out and
+// dangerous link URLs are dropped, since any author's post is shown to admins
+// and scripts in it would run with their session.
+func New(extensions ...goldmark.Extender) goldmark.Markdown {
+	return newPipeline(extensions)
+}
+
+// NewTrusted is like New but renders the raw HTML in the Markdown, for the
+// posts of admins, who can already do anything on the blog.
+func NewTrusted(extensions ...goldmark.Extender) goldmark.Markdown {
+	return newPipeline(extensions, html.WithUnsafe())
+}
+
+func newPipeline(extensions []goldmark.Extender, options ...renderer.Option) goldmark.Markdown {
+	extensions = append([]goldmark.Extender{extension.GFM, extension.Table, extension.Typographer, WikiLinks, highlighting.NewHighlighting(
+		highlighting.WithFormatOptions(formatOptions...),
+	)}, extensions...)
+
+	return goldmark.New(goldmark.WithExtensions(extensions...),
+		goldmark.WithParserOptions(
+			parser.WithAutoHeadingID(),
+			parser.WithAttribute(),
+		),
+		goldmark.WithRendererOptions(
+			append([]renderer.Option{html.WithHardWraps()}, options...)...,
+		))
+}
@@ -0,0 +1,51 @@
+package markdown
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestNew_NoRawHTML(t *testing.T) {
+	md := New()
+
+	tests := []struct {
+		name string
+		src  string
+	}{
+		{name: "Script block", src: "<script>alert(1)</script>"},
+		{name: "Inline script", src: "Hello <script>alert(1)</script> world"},
+		{name: "Alpine attribute", src: `<div x-init="alert(1)">Hello</div>`},
+		{name: "Event handler", src: `Hello <img src="x" onerror="alert(1)">`},
+		{name: "Heading attribute", src: `# Hello {x-init="alert(1)" onclick="alert(1)"}`},
+		{name: "JavaScript link", src: "[Hello](javascript:alert(1))"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := md.Convert([]byte(tt.src), &buf); err != nil {
+				t.Fatalf("Convert() error = %v", err)
+			}
+
+			html := buf.String()
+			for _, unsafe := range []string{"<script", "x-init", "onerror", "onclick", "javascript:"} {
+				if strings.Contains(html, unsafe) {
+					t.Errorf("Convert() = %q, rendered %q", html, unsafe)
+				}
+			}
+		})
+	}
+}
+
+func TestNewTrusted_RawHTML(t *testing.T) {
+	var buf bytes.Buffer
+	if err := NewTrusted().Convert([]byte("<details><summary>More</summary>Hello</details>"), &buf); err != nil {
+		t.Fatalf("Convert() error = %v", err)
+	}
+
+	html := buf.String()
+	if !strings.Contains(html, "<details><summary>More</summary>") {
+		t.Errorf("Convert() = %q, want the raw HTML", html)
+	}
+}
@@ -0,0 +1,128 @@
+package markdown
+
+import (
+	"bytes"
+	"math"
+	"unicode"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/text"
+)
+
+// ReadTimeEstimator estimates how long a post takes to read. Prose, code and
+// images are weighted separately; zero values use the defaults below.
+type ReadTimeEstimator struct {
+	WordsPerMinute     int // Reading speed for prose, defaults to 200
+	CodeWordsPerMinute int // Reading speed for code blocks, defaults to half the prose speed
+	SecondsPerImage    int // Time spent looking at each image, defaults to 12
+}
+
+// ReadTime is the result of an estimate.
+type ReadTime struct {
+	Words   int // Words of prose and code, not counting Markdown syntax or URLs
+	Minutes int
+}
+
+func (e ReadTimeEstimator) wordsPerMinute() float64 {
+	if e.WordsPerMinute <= 0 {
+		return 200
+	}
+	return float64(e.WordsPerMinute)
+}
+
+func (e ReadTimeEstimator) codeWordsPerMinute() float64 {
+	if e.CodeWordsPerMinute <= 0 {
+		return e.wordsPerMinute() / 2
+	}
+	return float64(e.CodeWordsPerMinute)
+}
+
+func (e ReadTimeEstimator) secondsPerImage() float64 {
+	if e.SecondsPerImage <= 0 {
+		return 12
+	}
+	return float64(e.SecondsPerImage)
+}
+
+// Estimate walks a parsed document and estimates its reading time.
+func (e ReadTimeEstimator) Estimate(doc ast.Node, source []byte) ReadTime {
+	codeWords, images := 0, 0
+
+	// Inline text is split into many nodes (typographer quotes, emphasis), so
+	// prose is gathered first and counted once.
+	var prose bytes.Buffer
+
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+
+		if n.Type() == ast.TypeBlock {
+			prose.WriteByte(' ')
+		}
+
+		switch node := n.(type) {
+		case *ast.FencedCodeBlock, *ast.CodeBlock:
+			codeWords += countWords(node.Lines(), source)
+			return ast.WalkSkipChildren, nil
+		case *ast.HTMLBlock, *ast.RawHTML:
+			return ast.WalkSkipChildren, nil
+		case *ast.Image:
+			// Alt text is not read, the image is looked at
+			images++
+			return ast.WalkSkipChildren, nil
+		case *ast.AutoLink:
+			prose.WriteString(" link ")
+			return ast.WalkSkipChildren, nil
+		case *WikiLink:
+			// The label is a node of its own, so it must not run into the
+			// text around it
+			prose.WriteByte(' ')
+			prose.WriteString(node.DisplayText())
+			prose.WriteByte(' ')
+		case *ast.String:
+			prose.Write(node.Value)
+		case *ast.Text:
+			prose.Write(node.Value(source))
+			if node.SoftLineBreak() || node.HardLineBreak() {
+				prose.WriteByte(' ')
+			}
+		}
+
+		return ast.WalkContinue, nil
+	})
+
+	proseWords := 0
+	for _, field := range bytes.Fields(prose.Bytes()) {
+		if bytes.IndexFunc(field, isWordRune) >= 0 {
+			proseWords++
+		}
+	}
+
+	seconds := float64(proseWords)/e.wordsPerMinute()*60 +
+		float64(codeWords)/e.codeWordsPerMinute()*60 +
+		float64(images)*e.secondsPerImage()
+
+	minutes := int(math.Ceil(seconds / 60))
+	if minutes == 0 && (proseWords > 0 || codeWords > 0 || images > 0) {
+		minutes = 1
+	}
+
+	return ReadTime{
+		Words:   proseWords + codeWords,
+		Minutes: minutes,
+	}
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func countWords(lines *text.Segments, source []byte) int {
+	words := 0
+	for i := 0; i < lines.Len(); i++ {
+		segment := lines.At(i)
+		words += len(bytes.Fields(segment.Value(source)))
+	}
+	return words
+}
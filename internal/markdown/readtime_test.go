@@ -0,0 +1,75 @@
+package markdown
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/yuin/goldmark/text"
+)
+
+func TestReadTimeEstimator_Estimate(t *testing.T) {
+	md := New()
+
+	prose := strings.Repeat("word ", 400)
+	code := "```go\n" + strings.Repeat("fmt.Println(x)\n", 100) + "```\n"
+
+	tests := []struct {
+		name      string
+		estimator ReadTimeEstimator
+		src       string
+		want      ReadTime
+	}{
+		{
+			name: "Empty post",
+			src:  "",
+			want: ReadTime{Words: 0, Minutes: 0},
+		},
+		{
+			name: "Short post takes at least a minute",
+			src:  "# Title\n\nA few words.",
+			want: ReadTime{Words: 4, Minutes: 1},
+		},
+		{
+			name: "Prose at the default speed",
+			src:  prose,
+			want: ReadTime{Words: 400, Minutes: 2},
+		},
+		{
+			name:      "Prose at a configured speed",
+			estimator: ReadTimeEstimator{WordsPerMinute: 100},
+			src:       prose,
+			want:      ReadTime{Words: 400, Minutes: 4},
+		},
+		{
+			name: "Code is read slower than prose",
+			src:  code,
+			want: ReadTime{Words: 100, Minutes: 1},
+		},
+		{
+			name: "Markdown syntax and URLs are not words",
+			src:  "**bold** [a link](https://example.com/a/very/long/path) `code`",
+			want: ReadTime{Words: 4, Minutes: 1},
+		},
+		{
+			name: "Wiki link labels are words of their own",
+			src:  "See [[first-post|the first]][[second-post|the second]]",
+			want: ReadTime{Words: 5, Minutes: 1},
+		},
+		{
+			name: "Images add time but no words",
+			src:  prose + "\n\n" + strings.Repeat("![alt text](/static/a.png)\n\n", 10),
+			want: ReadTime{Words: 400, Minutes: 4},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := []byte(tt.src)
+			doc := md.Parser().Parse(text.NewReader(src))
+
+			if got := tt.estimator.Estimate(doc, src); got != tt.want {
+				t.Errorf("Estimate() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,211 @@
+package markdown
+
+import (
+	"bytes"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// KindWikiLink is the ast.NodeKind of WikiLink nodes.
+var KindWikiLink = ast.NewNodeKind("WikiLink")
+
+// WikiLink is an inline node for the [[slug]] and [[slug|label]] syntax.
+type WikiLink struct {
+	ast.BaseInline
+
+	Slug     string
+	Label    string
+	Title    string // Title of the target post, set when the link is resolved
+	Resolved bool
+}
+
+// Kind implements ast.Node.
+func (n *WikiLink) Kind() ast.NodeKind {
+	return KindWikiLink
+}
+
+// Dump implements ast.Node.
+func (n *WikiLink) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{
+		"Slug":  n.Slug,
+		"Label": n.Label,
+	}, nil)
+}
+
+// DisplayText returns the text shown for the link: the label when one was given,
+// then the title of the target post, then the slug itself.
+func (n *WikiLink) DisplayText() string {
+	if n.Label != "" {
+		return n.Label
+	}
+	if n.Title != "" {
+		return n.Title
+	}
+	return n.Slug
+}
+
+// Resolver looks up the post a wiki link points to.
+type Resolver interface {
+	ResolveSlug(slug string) (title string, ok bool)
+}
+
+// ResolverFunc adapts a function to the Resolver interface.
+type ResolverFunc func(slug string) (string, bool)
+
+// ResolveSlug implements Resolver.
+func (f ResolverFunc) ResolveSlug(slug string) (string, bool) {
+	return f(slug)
+}
+
+// LinkRef is a wiki link found while parsing a document.
+type LinkRef struct {
+	Slug     string
+	Resolved bool
+}
+
+var (
+	resolverKey = parser.NewContextKey()
+	linksKey    = parser.NewContextKey()
+)
+
+// NewContext returns a parser context that resolves wiki links with the given
+// resolver. Pass it to Convert with parser.WithContext.
+func NewContext(resolver Resolver) parser.Context {
+	pc := parser.NewContext()
+	pc.Set(resolverKey, resolver)
+	return pc
+}
+
+// LinksFromContext returns the wiki links found while parsing with pc, in
+// document order and without duplicates.
+func LinksFromContext(pc parser.Context) []LinkRef {
+	links, _ := pc.Get(linksKey).([]LinkRef)
+	return links
+}
+
+// UnresolvedLinks returns the slugs of the links that did not match a post.
+func UnresolvedLinks(links []LinkRef) []string {
+	unresolved := make([]string, 0)
+	for _, link := range links {
+		if !link.Resolved {
+			unresolved = append(unresolved, link.Slug)
+		}
+	}
+	return unresolved
+}
+
+// RenameWikiLinks rewrites the wiki links to oldSlug in src so they point to
+// newSlug, keeping any labels.
+func RenameWikiLinks(src, oldSlug, newSlug string) string {
+	re := regexp.MustCompile(`\[\[\s*` + regexp.QuoteMeta(oldSlug) + `\s*(\||\]\])`)
+	return re.ReplaceAllString(src, "[["+strings.ReplaceAll(newSlug, "$", "$$")+"$1")
+}
+
+func addLink(pc parser.Context, ref LinkRef) {
+	links, _ := pc.Get(linksKey).([]LinkRef)
+	for _, link := range links {
+		if link.Slug == ref.Slug {
+			return
+		}
+	}
+	pc.Set(linksKey, append(links, ref))
+}
+
+type wikiLinkParser struct{}
+
+func (p *wikiLinkParser) Trigger() []byte {
+	return []byte{'['}
+}
+
+func (p *wikiLinkParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	line, _ := block.PeekLine()
+	if !bytes.HasPrefix(line, []byte("[[")) {
+		return nil
+	}
+
+	end := bytes.Index(line, []byte("]]"))
+	if end < 0 {
+		return nil
+	}
+
+	inner := line[2:end]
+	if bytes.ContainsAny(inner, "[]\n") {
+		return nil
+	}
+
+	slug, label, _ := bytes.Cut(inner, []byte("|"))
+	slug = bytes.TrimSpace(slug)
+	label = bytes.TrimSpace(label)
+	if len(slug) == 0 {
+		return nil
+	}
+
+	node := &WikiLink{
+		Slug:  string(slug),
+		Label: string(label),
+	}
+
+	if resolver, ok := pc.Get(resolverKey).(Resolver); ok && resolver != nil {
+		node.Title, node.Resolved = resolver.ResolveSlug(node.Slug)
+	}
+
+	addLink(pc, LinkRef{Slug: node.Slug, Resolved: node.Resolved})
+
+	block.Advance(end + 2)
+	return node
+}
+
+type wikiLinkRenderer struct{}
+
+func (r *wikiLinkRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(KindWikiLink, r.render)
+}
+
+func (r *wikiLinkRenderer) render(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+
+	n := node.(*WikiLink)
+	label := util.EscapeHTML([]byte(n.DisplayText()))
+
+	if !n.Resolved {
+		_, _ = w.WriteString(`<span class="wikilink wikilink-missing" title="Post not found: `)
+		_, _ = w.Write(util.EscapeHTML([]byte(n.Slug)))
+		_, _ = w.WriteString(`">`)
+		_, _ = w.Write(label)
+		_, _ = w.WriteString("</span>")
+		return ast.WalkSkipChildren, nil
+	}
+
+	_, _ = w.WriteString(`<a class="wikilink" href="/post/`)
+	_, _ = w.Write(util.EscapeHTML([]byte(url.PathEscape(n.Slug))))
+	_, _ = w.WriteString(`">`)
+	_, _ = w.Write(label)
+	_, _ = w.WriteString("</a>")
+	return ast.WalkSkipChildren, nil
+}
+
+type wikiLinks struct{}
+
+// WikiLinks is a goldmark extension for [[slug]] and [[slug|label]] links
+// between posts. Links are resolved with the Resolver set by NewContext;
+// without one every link renders as missing.
+var WikiLinks goldmark.Extender = &wikiLinks{}
+
+func (e *wikiLinks) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(parser.WithInlineParsers(
+		util.Prioritized(&wikiLinkParser{}, 199),
+	))
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(
+		util.Prioritized(&wikiLinkRenderer{}, 199),
+	))
+}
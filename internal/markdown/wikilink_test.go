@@ -0,0 +1,96 @@
+package markdown
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/parser"
+)
+
+func TestWikiLinks(t *testing.T) {
+	md := goldmark.New(goldmark.WithExtensions(WikiLinks))
+
+	posts := map[string]string{
+		"first-post": "First Post",
+	}
+
+	resolver := ResolverFunc(func(slug string) (string, bool) {
+		title, ok := posts[slug]
+		return title, ok
+	})
+
+	tests := []struct {
+		name      string
+		src       string
+		want      string
+		wantLinks []LinkRef
+	}{
+		{
+			name:      "Resolved link uses the post title",
+			src:       "See [[first-post]].",
+			want:      `<p>See <a class="wikilink" href="/post/first-post">First Post</a>.</p>`,
+			wantLinks: []LinkRef{{Slug: "first-post", Resolved: true}},
+		},
+		{
+			name:      "Resolved link with label",
+			src:       "See [[ first-post | the first one ]].",
+			want:      `<p>See <a class="wikilink" href="/post/first-post">the first one</a>.</p>`,
+			wantLinks: []LinkRef{{Slug: "first-post", Resolved: true}},
+		},
+		{
+			name:      "Unresolved link",
+			src:       "See [[missing-post]].",
+			want:      `<p>See <span class="wikilink wikilink-missing" title="Post not found: missing-post">missing-post</span>.</p>`,
+			wantLinks: []LinkRef{{Slug: "missing-post", Resolved: false}},
+		},
+		{
+			name:      "Duplicated links are collected once",
+			src:       "[[first-post]] and [[first-post|again]]",
+			want:      `<p><a class="wikilink" href="/post/first-post">First Post</a> and <a class="wikilink" href="/post/first-post">again</a></p>`,
+			wantLinks: []LinkRef{{Slug: "first-post", Resolved: true}},
+		},
+		{
+			name:      "Regular links are untouched",
+			src:       "[first](/post/first-post)",
+			want:      `<p><a href="/post/first-post">first</a></p>`,
+			wantLinks: nil,
+		},
+		{
+			name:      "Code spans are untouched",
+			src:       "`[[first-post]]`",
+			want:      `<p><code>[[first-post]]</code></p>`,
+			wantLinks: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pc := NewContext(resolver)
+
+			var buf bytes.Buffer
+			if err := md.Convert([]byte(tt.src), &buf, parser.WithContext(pc)); err != nil {
+				t.Fatalf("Convert() error = %v", err)
+			}
+
+			if got := strings.TrimSpace(buf.String()); got != tt.want {
+				t.Errorf("Convert() =\n%v, want\n%v", got, tt.want)
+			}
+
+			if got := LinksFromContext(pc); !reflect.DeepEqual(got, tt.wantLinks) {
+				t.Errorf("LinksFromContext() = %v, want %v", got, tt.wantLinks)
+			}
+		})
+	}
+}
+
+func TestRenameWikiLinks(t *testing.T) {
+	src := "[[old-slug]], [[ old-slug | label ]], [[old-slug-2]] and [old](/post/old-slug)"
+	want := "[[new-slug]], [[new-slug| label ]], [[old-slug-2]] and [old](/post/old-slug)"
+
+	if got := RenameWikiLinks(src, "old-slug", "new-slug"); got != want {
+		t.Errorf("RenameWikiLinks() = %v, want %v", got, want)
+	}
+}
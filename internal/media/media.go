@@ -0,0 +1,92 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"net/http"
+
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+)
+
+// ErrNotFound is returned by a Storage when the requested item does not exist.
+var ErrNotFound = errors.New("media not found")
+
+// Storage stores uploaded files by name.
+type Storage interface {
+	Put(ctx context.Context, name string, r io.Reader, size int64, contentType string) error
+	Open(ctx context.Context, name string) (io.ReadCloser, error)
+	Delete(ctx context.Context, name string) error
+}
+
+// Linker is implemented by storages that can send clients straight to a
+// file instead of having the blog serve it.
+type Linker interface {
+	// Link returns the URL of a stored file, or an empty string if the file
+	// must be served by the blog.
+	Link(ctx context.Context, name string) (string, error)
+}
+
+// AllowedTypes maps the accepted MIME types to the extension of stored files.
+var AllowedTypes = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/gif":  ".gif",
+}
+
+// MaxPixels is the size of the largest image accepted and resized, 50
+// megapixels. Decoding needs memory for every pixel, whatever the file size.
+const MaxPixels = 50_000_000
+
+// Info describes an uploaded file.
+type Info struct {
+	Name     string // Content hash followed by the extension of the MIME type
+	MimeType string
+	Size     int64
+	Width    int
+	Height   int
+}
+
+// Inspect detects the type and dimensions of an uploaded file and names it
+// after the hash of its content, so the same file always gets the same name.
+// It returns an error if the type is not one of AllowedTypes or the image has
+// more than MaxPixels.
+func Inspect(data []byte) (Info, error) {
+	mimeType := http.DetectContentType(data)
+
+	ext, ok := AllowedTypes[mimeType]
+	if !ok {
+		return Info{}, fmt.Errorf("file type %s is not allowed", mimeType)
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return Info{}, fmt.Errorf("invalid image: %w", err)
+	}
+
+	if config.Width*config.Height > MaxPixels {
+		return Info{}, fmt.Errorf("image of %dx%d pixels is larger than %d megapixels", config.Width, config.Height, MaxPixels/1_000_000)
+	}
+
+	hash := sha256.Sum256(data)
+
+	return Info{
+		Name:     hex.EncodeToString(hash[:]) + ext,
+		MimeType: mimeType,
+		Size:     int64(len(data)),
+		Width:    config.Width,
+		Height:   config.Height,
+	}, nil
+}
+
+// URL returns the stable URL a stored file is served under.
+func URL(name string) string {
+	return "/media/" + name
+}
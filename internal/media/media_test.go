@@ -0,0 +1,206 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func testPNG(t *testing.T, width, height int) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatalf("png.Encode() error = %v", err)
+	}
+	return buf.Bytes()
+}
+
+// hugePNG returns the start of a PNG that declares the given dimensions,
+// enough for DecodeConfig but not for Decode.
+func hugePNG(width, height uint32) []byte {
+	header := make([]byte, 13)
+	binary.BigEndian.PutUint32(header[0:], width)
+	binary.BigEndian.PutUint32(header[4:], height)
+	header[8], header[9] = 8, 6 // 8 bit RGBA
+
+	chunk := append([]byte("IHDR"), header...)
+
+	var buf bytes.Buffer
+	buf.WriteString("\x89PNG\r\n\x1a\n")
+	binary.Write(&buf, binary.BigEndian, uint32(len(header)))
+	buf.Write(chunk)
+	binary.Write(&buf, binary.BigEndian, crc32.ChecksumIEEE(chunk))
+	return buf.Bytes()
+}
+
+func TestInspect(t *testing.T) {
+	data := testPNG(t, 30, 20)
+
+	info, err := Inspect(data)
+	if err != nil {
+		t.Fatalf("Inspect() error = %v", err)
+	}
+
+	if info.MimeType != "image/png" || info.Width != 30 || info.Height != 20 || info.Size != int64(len(data)) {
+		t.Errorf("Inspect() = %+v", info)
+	}
+
+	if !strings.HasSuffix(info.Name, ".png") || len(info.Name) != 64+len(".png") {
+		t.Errorf("Inspect() name = %v, want a content hash with the .png extension", info.Name)
+	}
+
+	again, _ := Inspect(data)
+	if again.Name != info.Name {
+		t.Errorf("Inspect() named the same content %v and %v", info.Name, again.Name)
+	}
+
+	if _, err := Inspect([]byte("<html><body>not an image</body></html>")); err == nil {
+		t.Errorf("Inspect() accepted a file that is not an image")
+	}
+
+	if _, err := Inspect(hugePNG(30000, 30000)); err == nil {
+		t.Errorf("Inspect() accepted an image of 900 megapixels")
+	}
+}
+
+func TestLocal(t *testing.T) {
+	ctx := context.Background()
+
+	storage, err := NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocal() error = %v", err)
+	}
+
+	if err := storage.Put(ctx, "file.png", strings.NewReader("content"), 7, "image/png"); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+
+	file, err := storage.Open(ctx, "file.png")
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+
+	content, _ := io.ReadAll(file)
+	file.Close()
+
+	if string(content) != "content" {
+		t.Errorf("Open() content = %v, want %v", string(content), "content")
+	}
+
+	if err := storage.Delete(ctx, "file.png"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+
+	if _, err := storage.Open(ctx, "file.png"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Open() after Delete() error = %v, want %v", err, ErrNotFound)
+	}
+
+	if err := storage.Put(ctx, "../escape.png", strings.NewReader(""), 0, "image/png"); err == nil {
+		t.Errorf("Put() accepted a name outside the media directory")
+	}
+}
+
+func TestResize(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 100, 50))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+
+	resized := Resize(img, 40)
+
+	if got := resized.Bounds().Size(); got != image.Pt(40, 20) {
+		t.Fatalf("Resize() size = %v, want %v", got, image.Pt(40, 20))
+	}
+
+	if got := resized.RGBAAt(10, 10); got.R != 0xff || got.A != 0xff {
+		t.Errorf("Resize() pixel = %v, want white", got)
+	}
+}
+
+func TestVariants(t *testing.T) {
+	ctx := context.Background()
+
+	storage, err := NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocal() error = %v", err)
+	}
+
+	data := testPNG(t, 1000, 500)
+	if err := storage.Put(ctx, "photo.png", bytes.NewReader(data), int64(len(data)), "image/png"); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+
+	variants, err := NewVariants(VariantsConfig{
+		Static: fstest.MapFS{
+			"images/icon.png": &fstest.MapFile{Data: testPNG(t, 64, 64)},
+			"images/huge.png": &fstest.MapFile{Data: hugePNG(30000, 30000)},
+		},
+		Storage:  storage,
+		CacheDir: t.TempDir(),
+		Widths:   []int{960, 480},
+	})
+	if err != nil {
+		t.Fatalf("NewVariants() error = %v", err)
+	}
+
+	if width, height, ok := variants.Size("/media/photo.png"); !ok || width != 1000 || height != 500 {
+		t.Errorf("Size() = %v, %v, %v, want 1000, 500, true", width, height, ok)
+	}
+
+	if _, _, ok := variants.Size("/media/missing.png"); ok {
+		t.Errorf("Size() found a missing image")
+	}
+
+	path, err := variants.Path(ctx, "/media/photo.png", 480)
+	if err != nil {
+		t.Fatalf("Path() error = %v", err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer file.Close()
+
+	config, format, err := image.DecodeConfig(file)
+	if err != nil || format != "jpeg" || config.Width != 480 || config.Height != 240 {
+		t.Errorf("Path() variant = %+v %v %v, want a 480x240 jpeg", config, format, err)
+	}
+
+	for _, tt := range []struct {
+		src   string
+		width int
+	}{
+		{src: "/media/photo.png", width: 300},
+		{src: "/static/images/icon.png", width: 480},
+		{src: "/static/images/huge.png", width: 480},
+		{src: "/media/../secret.png", width: 480},
+		{src: "/post/photo.png", width: 480},
+	} {
+		if _, err := variants.Path(ctx, tt.src, tt.width); err == nil {
+			t.Errorf("Path(%v, %v) generated a variant", tt.src, tt.width)
+		}
+	}
+
+	if _, _, ok := variants.Size("/static/images/huge.png"); ok {
+		t.Errorf("Size() accepted an image of 900 megapixels")
+	}
+
+	if err := variants.Purge("/media/photo.png"); err != nil {
+		t.Fatalf("Purge() error = %v", err)
+	}
+
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Purge() kept the variant, Stat() error = %v", err)
+	}
+}
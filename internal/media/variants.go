@@ -0,0 +1,328 @@
+package media
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultWidths are the widths of the resized variants of local images.
+var DefaultWidths = []int{480, 960, 1440}
+
+// ErrNoVariant is returned by Variants.Path for images that are not resized
+// or widths that are not generated.
+var ErrNoVariant = errors.New("no variant for this image")
+
+type VariantsConfig struct {
+	Static   fs.FS   // Files served under /static/, required
+	Storage  Storage // Uploads served under /media/, required
+	CacheDir string  // Directory the variants are written to, required
+	Widths   []int   // Widths of the variants, defaults to DefaultWidths
+	Quality  int     // JPEG quality of the variants, defaults to 80
+}
+
+// Variants generates resized copies of local images so small screens do not
+// download full-size files. Variants are JPEG files generated on the first
+// request and cached on disk. Only PNG and JPEG images are resized; GIFs
+// would lose their animation.
+type Variants struct {
+	static   fs.FS
+	storage  Storage
+	cacheDir string
+	widths   []int
+	quality  int
+
+	mu    sync.Mutex // Guards sizes
+	sizes map[string]image.Point
+
+	// Generating a variant is expensive, so only one is generated at a time
+	generating sync.Mutex
+}
+
+// NewVariants creates a new Variants from the provided configuration.
+func NewVariants(config VariantsConfig) (*Variants, error) {
+	if config.Static == nil {
+		return nil, fmt.Errorf("a static file system is required")
+	}
+
+	if config.Storage == nil {
+		return nil, fmt.Errorf("a media storage is required")
+	}
+
+	if config.CacheDir == "" {
+		return nil, fmt.Errorf("a cache directory is required")
+	}
+
+	if len(config.Widths) == 0 {
+		config.Widths = DefaultWidths
+	}
+
+	widths := slices.Clone(config.Widths)
+	slices.Sort(widths)
+	widths = slices.Compact(widths)
+
+	if widths[0] <= 0 {
+		return nil, fmt.Errorf("invalid variant width %d", widths[0])
+	}
+
+	if config.Quality <= 0 {
+		config.Quality = 80
+	}
+
+	if err := os.MkdirAll(config.CacheDir, 0o755); err != nil {
+		return nil, err
+	}
+
+	return &Variants{
+		static:   config.Static,
+		storage:  config.Storage,
+		cacheDir: config.CacheDir,
+		widths:   widths,
+		quality:  config.Quality,
+		sizes:    make(map[string]image.Point),
+	}, nil
+}
+
+// Widths returns the widths variants are generated at, in increasing order.
+func (v *Variants) Widths() []int {
+	return v.widths
+}
+
+// URL returns the URL of the variant of src with the given width.
+func (v *Variants) URL(src string, width int) string {
+	return "/images/" + strconv.Itoa(width) + src
+}
+
+// Size returns the dimensions of the local image at src, or false if src is
+// not an image that can be resized.
+func (v *Variants) Size(src string) (width, height int, ok bool) {
+	if !resizable(src) {
+		return 0, 0, false
+	}
+
+	v.mu.Lock()
+	size, ok := v.sizes[src]
+	v.mu.Unlock()
+	if ok {
+		return size.X, size.Y, true
+	}
+
+	file, err := v.open(context.Background(), src)
+	if err != nil {
+		return 0, 0, false
+	}
+	defer file.Close()
+
+	config, _, err := image.DecodeConfig(file)
+	if err != nil || config.Width*config.Height > MaxPixels {
+		return 0, 0, false
+	}
+
+	v.mu.Lock()
+	v.sizes[src] = image.Pt(config.Width, config.Height)
+	v.mu.Unlock()
+
+	return config.Width, config.Height, true
+}
+
+// Path returns the path of the cached variant of src with the given width,
+// generating it first if needed. It returns ErrNoVariant if width is not one
+// of the configured widths, is not smaller than the image, or the image has
+// more than MaxPixels.
+func (v *Variants) Path(ctx context.Context, src string, width int) (string, error) {
+	if !resizable(src) || !slices.Contains(v.widths, width) {
+		return "", ErrNoVariant
+	}
+
+	cached := v.cachePath(src, width)
+
+	if _, err := os.Stat(cached); err == nil {
+		return cached, nil
+	}
+
+	// Concurrent requests for the same variant wait for the first
+	v.generating.Lock()
+	defer v.generating.Unlock()
+
+	if _, err := os.Stat(cached); err == nil {
+		return cached, nil
+	}
+
+	// The dimensions are checked before decoding, since a small file can
+	// declare enough pixels to exhaust the memory
+	config, err := v.decodeConfig(ctx, src)
+	if err != nil {
+		return "", err
+	}
+
+	if config.Width <= width || config.Width*config.Height > MaxPixels {
+		return "", ErrNoVariant
+	}
+
+	file, err := v.open(ctx, src)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return "", fmt.Errorf("invalid image %s: %w", src, err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(cached), 0o755); err != nil {
+		return "", err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(cached), ".variant-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := jpeg.Encode(tmp, Resize(img, width), &jpeg.Options{Quality: v.quality}); err != nil {
+		tmp.Close()
+		return "", err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+
+	if err := os.Rename(tmp.Name(), cached); err != nil {
+		return "", err
+	}
+
+	return cached, nil
+}
+
+// Purge removes the cached variants of src, so a deleted image stops being
+// served.
+func (v *Variants) Purge(src string) error {
+	v.mu.Lock()
+	delete(v.sizes, src)
+	v.mu.Unlock()
+
+	v.generating.Lock()
+	defer v.generating.Unlock()
+
+	for _, width := range v.widths {
+		if err := os.Remove(v.cachePath(src, width)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// cachePath returns the path of the variant of src with the given width.
+func (v *Variants) cachePath(src string, width int) string {
+	return filepath.Join(v.cacheDir, strconv.Itoa(width), filepath.FromSlash(strings.TrimPrefix(src, "/"))+".jpg")
+}
+
+// decodeConfig reads the dimensions of the original of src.
+func (v *Variants) decodeConfig(ctx context.Context, src string) (image.Config, error) {
+	file, err := v.open(ctx, src)
+	if err != nil {
+		return image.Config{}, err
+	}
+	defer file.Close()
+
+	config, _, err := image.DecodeConfig(file)
+	if err != nil {
+		return image.Config{}, fmt.Errorf("invalid image %s: %w", src, err)
+	}
+
+	return config, nil
+}
+
+// open opens the original of a local image URL.
+func (v *Variants) open(ctx context.Context, src string) (io.ReadCloser, error) {
+	switch {
+	case strings.HasPrefix(src, "/static/"):
+		file, err := v.static.Open(strings.TrimPrefix(src, "/static/"))
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, ErrNotFound
+		}
+		return file, err
+	case strings.HasPrefix(src, "/media/"):
+		return v.storage.Open(ctx, strings.TrimPrefix(src, "/media/"))
+	default:
+		return nil, ErrNotFound
+	}
+}
+
+// resizable reports whether src is a clean local URL of a PNG or JPEG image.
+func resizable(src string) bool {
+	if path.Clean(src) != src || strings.Contains(src, "..") {
+		return false
+	}
+
+	if !strings.HasPrefix(src, "/static/") && !strings.HasPrefix(src, "/media/") {
+		return false
+	}
+
+	switch strings.ToLower(path.Ext(src)) {
+	case ".png", ".jpg", ".jpeg":
+		return true
+	default:
+		return false
+	}
+}
+
+// Resize scales img to the given width, keeping its aspect ratio. Each pixel
+// of the result is the average of the pixels it covers, which gives good
+// results when shrinking. Transparent areas are filled with white, since the
+// variants are JPEG files.
+func Resize(img image.Image, width int) *image.RGBA {
+	bounds := img.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+
+	height := max(1, srcH*width/srcW)
+
+	src := image.NewRGBA(image.Rect(0, 0, srcW, srcH))
+	draw.Draw(src, src.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(src, src.Bounds(), img, bounds.Min, draw.Over)
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for y := range height {
+		y0, y1 := y*srcH/height, max((y+1)*srcH/height, y*srcH/height+1)
+
+		for x := range width {
+			x0, x1 := x*srcW/width, max((x+1)*srcW/width, x*srcW/width+1)
+
+			var r, g, b, n int
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					r += int(row[sx*4])
+					g += int(row[sx*4+1])
+					b += int(row[sx*4+2])
+					n++
+				}
+			}
+
+			i := y*dst.Stride + x*4
+			dst.Pix[i] = uint8(r / n)
+			dst.Pix[i+1] = uint8(g / n)
+			dst.Pix[i+2] = uint8(b / n)
+			dst.Pix[i+3] = 0xff
+		}
+	}
+
+	return dst
+}
@@ -0,0 +1,193 @@
+package qr
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// decode reads the data back from a code, checking the format information
+// and the error correction of every block on the way.
+func decode(t *testing.T, c *Code) []byte {
+	t.Helper()
+
+	version := (c.Size - 17) / 4
+
+	// Format information, first copy, from the most significant bit
+	var format int
+	positions := [][2]int{{0, 8}, {1, 8}, {2, 8}, {3, 8}, {4, 8}, {5, 8}, {7, 8}, {8, 8}, {8, 7}, {8, 5}, {8, 4}, {8, 3}, {8, 2}, {8, 1}, {8, 0}}
+	for _, p := range positions {
+		format <<= 1
+		if c.Dark(p[0], p[1]) {
+			format |= 1
+		}
+	}
+	format ^= 0x5412
+
+	rem := format
+	for i := 14; i >= 10; i-- {
+		if rem&(1<<i) != 0 {
+			rem ^= 0x537 << (i - 10)
+		}
+	}
+	if rem != 0 {
+		t.Fatalf("format information %015b fails its BCH check", format)
+	}
+	if level := format >> 13; level != formatLevelM {
+		t.Fatalf("error correction level = %d, want M", level)
+	}
+	mask := format >> 10 & 7
+
+	// Read the codewords in zigzag order, unmasking them
+	var bits []bool
+	for right := c.Size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < c.Size; vert++ {
+			for j := 0; j < 2; j++ {
+				x, y := right-j, vert
+				if (right+1)&2 == 0 {
+					y = c.Size - 1 - vert
+				}
+				if !c.function[y*c.Size+x] {
+					bits = append(bits, c.Dark(x, y) != maskBit(mask, x, y))
+				}
+			}
+		}
+	}
+
+	codewords := make([]byte, len(bits)/8)
+	for i := range codewords {
+		for j := 0; j < 8; j++ {
+			if bits[i*8+j] {
+				codewords[i] |= 1 << (7 - j)
+			}
+		}
+	}
+
+	// De-interleave the blocks and check that every syndrome is zero
+	blocks, eccLen := numBlocks[version], eccPerBlock[version]
+	numShort := blocks - len(codewords)%blocks
+	shortData := len(codewords)/blocks - eccLen
+
+	split := make([][]byte, blocks)
+	k := 0
+	for i := 0; i < shortData+1; i++ {
+		for j := range split {
+			if i < shortData || j >= numShort {
+				split[j] = append(split[j], codewords[k])
+				k++
+			}
+		}
+	}
+	for i := 0; i < eccLen; i++ {
+		for j := range split {
+			split[j] = append(split[j], codewords[k])
+			k++
+		}
+	}
+
+	var data []byte
+	for j, block := range split {
+		root := byte(1)
+		for i := 0; i < eccLen; i++ {
+			var syndrome byte
+			for _, b := range block {
+				syndrome = gfMultiply(syndrome, root) ^ b
+			}
+			if syndrome != 0 {
+				t.Fatalf("block %d has a non-zero syndrome", j)
+			}
+			root = gfMultiply(root, 0x02)
+		}
+		data = append(data, block[:len(block)-eccLen]...)
+	}
+
+	// Parse the byte mode segment
+	var reader bitBuffer
+	for _, b := range data {
+		reader.append(int(b), 8)
+	}
+	read := func(n int) int {
+		value := 0
+		for _, bit := range reader[:n] {
+			value <<= 1
+			if bit {
+				value |= 1
+			}
+		}
+		reader = reader[n:]
+		return value
+	}
+
+	if mode := read(4); mode != 0b0100 {
+		t.Fatalf("mode = %04b, want byte mode", mode)
+	}
+	result := make([]byte, read(countBits(version)))
+	for i := range result {
+		result[i] = byte(read(8))
+	}
+	return result
+}
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		data    string
+		version int
+	}{
+		{data: "hello", version: 1},
+		{data: strings.Repeat("a", 14), version: 1},
+		{data: strings.Repeat("a", 15), version: 2},
+		{data: "otpauth://totp/Blog:admin?secret=JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP&issuer=Blog&algorithm=SHA1&digits=6&period=30", version: 7},
+		{data: strings.Repeat("x", 122), version: 7},
+		{data: strings.Repeat("y", 213), version: 10},
+	}
+
+	for _, tt := range tests {
+		c, err := Encode([]byte(tt.data))
+		if err != nil {
+			t.Fatalf("Encode(%d bytes) error = %v", len(tt.data), err)
+		}
+
+		if want := 4*tt.version + 17; c.Size != want {
+			t.Errorf("Encode(%d bytes) size = %d, want %d (version %d)", len(tt.data), c.Size, want, tt.version)
+		}
+
+		if got := decode(t, c); !bytes.Equal(got, []byte(tt.data)) {
+			t.Errorf("decode(Encode(%q)) = %q", tt.data, got)
+		}
+
+		// Finder pattern in the top left corner
+		for i := 0; i < 7; i++ {
+			if !c.Dark(i, 0) || !c.Dark(0, i) || c.Dark(7, i) || c.Dark(i, 7) {
+				t.Errorf("Encode(%d bytes) has no finder pattern in the top left corner", len(tt.data))
+				break
+			}
+		}
+	}
+}
+
+func TestEncode_TooLong(t *testing.T) {
+	if _, err := Encode(bytes.Repeat([]byte("z"), 214)); !errors.Is(err, ErrTooLong) {
+		t.Errorf("Encode(214 bytes) error = %v, want %v", err, ErrTooLong)
+	}
+}
+
+func TestCode_SVG(t *testing.T) {
+	c, err := Encode([]byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	svg := c.SVG()
+	if !strings.HasPrefix(svg, "<svg") || !strings.Contains(svg, `viewBox="0 0 29 29"`) {
+		t.Errorf("SVG() = %q, want a 29x29 svg", svg)
+	}
+
+	// The top left module of the finder, after the quiet zone
+	if !strings.Contains(svg, "M4,4h1v1h-1z") {
+		t.Errorf("SVG() does not draw the top left module")
+	}
+}
@@ -2,6 +2,7 @@ package repository
 
 import (
 	"database/sql"
+	"strings"
 )
 
 type PostWithTags struct {
@@ -12,8 +13,30 @@ type PostWithTags struct {
 	ParsedContent string
 	Slug          string
 	Readtime      sql.NullInt64
+	Words         sql.NullInt64
 	CreatedAt     sql.NullTime
 	ModifiedAt    sql.NullTime
 	Description   sql.NullString
+	Excerpt       sql.NullString
+	CoverImage    sql.NullString
+	CoverAlt      sql.NullString
+	CoverCaption  sql.NullString
+	AuthorID      sql.NullInt64
+	AuthorName    sql.NullString
+	Editable      bool // Whether the current user may edit and delete the post
 	Tags          []Tag
 }
+
+// Summary returns the description of the post, or its generated excerpt when
+// no description was written.
+func (p PostWithTags) Summary() string {
+	if p.Description.Valid && strings.TrimSpace(p.Description.String) != "" {
+		return p.Description.String
+	}
+	return p.Excerpt.String
+}
+
+type MediaWithUsage struct {
+	Media  Media
+	UsedBy []GetPostsReferencingMediaRow
+}
@@ -0,0 +1,11 @@
+create table post_links (
+    source_post_id bigint not null,
+    target_slug text not null,
+    target_post_id bigint,
+    created_at DATETIME,
+    primary key (source_post_id, target_slug),
+    foreign key (source_post_id) references posts(id) on delete cascade,
+    foreign key (target_post_id) references posts(id) on delete set null
+);
+
+CREATE INDEX post_links_target_slug_idx ON post_links (target_slug);
@@ -0,0 +1,11 @@
+create table link_checks (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    post_id bigint not null,
+    url text not null,
+    status text not null,
+    status_code INTEGER,
+    message text,
+    checked_at DATETIME,
+    unique (post_id, url),
+    foreign key (post_id) references posts(id) on delete cascade
+);
@@ -0,0 +1,2 @@
+ALTER TABLE posts
+ADD COLUMN words INTEGER DEFAULT 0;
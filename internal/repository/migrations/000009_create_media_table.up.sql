@@ -0,0 +1,10 @@
+create table media (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name text not null unique,
+    original_name text not null,
+    mime_type text not null,
+    size INTEGER not null,
+    width INTEGER not null default 0,
+    height INTEGER not null default 0,
+    created_at DATETIME
+);
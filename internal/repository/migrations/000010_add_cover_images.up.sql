@@ -0,0 +1,8 @@
+ALTER TABLE posts
+ADD COLUMN cover_image TEXT REFERENCES media(name) ON DELETE SET NULL;
+
+ALTER TABLE posts
+ADD COLUMN cover_alt TEXT;
+
+ALTER TABLE posts
+ADD COLUMN cover_caption TEXT;
@@ -0,0 +1,7 @@
+create table users (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    username text not null unique,
+    password_hash text not null,
+    created_at DATETIME,
+    modified_at DATETIME
+);
@@ -0,0 +1,49 @@
+-- SQLite cannot drop a column with a foreign key, so posts is rebuilt without
+-- author_id. Dropping posts deletes the rows that reference it, and the
+-- migration runs in a transaction where foreign keys cannot be turned off, so
+-- those rows are kept aside and put back.
+CREATE TEMP TABLE saved_tags_posts AS SELECT * FROM tags_posts;
+CREATE TEMP TABLE saved_post_links AS SELECT * FROM post_links;
+CREATE TEMP TABLE saved_link_checks AS SELECT * FROM link_checks;
+
+CREATE TABLE posts_new (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    title text not null,
+    content TEXT not null,
+    toc TEXT not null,
+    parsed_content TEXT not null,
+    slug text not null,
+    created_at DATETIME,
+    modified_at DATETIME,
+    description TEXT,
+    readtime INTEGER DEFAULT 0,
+    words INTEGER DEFAULT 0,
+    excerpt TEXT,
+    cover_image TEXT REFERENCES media(name) ON DELETE SET NULL,
+    cover_alt TEXT,
+    cover_caption TEXT
+);
+
+INSERT INTO posts_new (id, title, content, toc, parsed_content, slug, created_at, modified_at, description, readtime, words, excerpt, cover_image, cover_alt, cover_caption)
+SELECT id, title, content, toc, parsed_content, slug, created_at, modified_at, description, readtime, words, excerpt, cover_image, cover_alt, cover_caption
+FROM posts;
+
+-- Keep the ids of deleted posts from being used again
+UPDATE sqlite_sequence
+SET seq = (SELECT seq FROM sqlite_sequence WHERE name = 'posts')
+WHERE name = 'posts_new';
+
+DROP TABLE posts;
+
+ALTER TABLE posts_new RENAME TO posts;
+
+INSERT INTO tags_posts SELECT * FROM saved_tags_posts;
+INSERT INTO post_links SELECT * FROM saved_post_links;
+INSERT INTO link_checks SELECT * FROM saved_link_checks;
+
+DROP TABLE saved_tags_posts;
+DROP TABLE saved_post_links;
+DROP TABLE saved_link_checks;
+
+ALTER TABLE users
+DROP COLUMN role;
@@ -0,0 +1,13 @@
+ALTER TABLE users
+ADD COLUMN role TEXT NOT NULL DEFAULT 'author';
+
+-- The first user was the only administrator before roles existed
+UPDATE users
+SET role = 'admin'
+WHERE id = (SELECT min(id) FROM users);
+
+ALTER TABLE posts
+ADD COLUMN author_id INTEGER REFERENCES users(id) ON DELETE SET NULL;
+
+UPDATE posts
+SET author_id = (SELECT min(id) FROM users);
@@ -0,0 +1,6 @@
+create table login_attempts (
+    key text PRIMARY KEY,
+    failures INTEGER not null default 0,
+    last_failure_at DATETIME not null,
+    locked_until DATETIME
+);
@@ -0,0 +1,10 @@
+drop table recovery_codes;
+
+ALTER TABLE users
+DROP COLUMN totp_last_counter;
+
+ALTER TABLE users
+DROP COLUMN totp_enabled;
+
+ALTER TABLE users
+DROP COLUMN totp_secret;
@@ -0,0 +1,16 @@
+ALTER TABLE users
+ADD COLUMN totp_secret TEXT;
+
+ALTER TABLE users
+ADD COLUMN totp_enabled BOOLEAN NOT NULL DEFAULT 0;
+
+ALTER TABLE users
+ADD COLUMN totp_last_counter INTEGER NOT NULL DEFAULT 0;
+
+create table recovery_codes (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER not null REFERENCES users(id) ON DELETE CASCADE,
+    code_hash text not null,
+    created_at DATETIME,
+    used_at DATETIME
+);
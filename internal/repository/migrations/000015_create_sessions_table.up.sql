@@ -0,0 +1,11 @@
+create table sessions (
+    id text PRIMARY KEY,
+    user_id INTEGER not null REFERENCES users(id) ON DELETE CASCADE,
+    created_at DATETIME not null,
+    last_seen_at DATETIME not null,
+    expires_at DATETIME not null,
+    ip text not null,
+    user_agent text not null
+);
+
+create index sessions_user_id_idx on sessions (user_id);
@@ -0,0 +1,2 @@
+ALTER TABLE sessions
+ADD COLUMN remember BOOLEAN NOT NULL DEFAULT 0;
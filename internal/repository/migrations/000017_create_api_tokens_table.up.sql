@@ -0,0 +1,9 @@
+create table api_tokens (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER not null REFERENCES users(id) ON DELETE CASCADE,
+    name text not null,
+    token_hash text not null UNIQUE,
+    scopes text not null,
+    created_at DATETIME not null,
+    last_used_at DATETIME
+);
@@ -0,0 +1,6 @@
+drop table magic_links;
+
+drop index users_email_idx;
+
+ALTER TABLE users
+DROP COLUMN email;
@@ -0,0 +1,12 @@
+ALTER TABLE users
+ADD COLUMN email text;
+
+create unique index users_email_idx on users (email);
+
+create table magic_links (
+    token_hash text PRIMARY KEY,
+    user_id INTEGER not null REFERENCES users(id) ON DELETE CASCADE,
+    created_at DATETIME not null,
+    expires_at DATETIME not null,
+    used_at DATETIME
+);
@@ -0,0 +1 @@
+drop table oidc_identities;
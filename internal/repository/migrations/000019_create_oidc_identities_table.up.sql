@@ -0,0 +1,9 @@
+create table oidc_identities (
+    issuer text not null,
+    subject text not null,
+    user_id INTEGER not null REFERENCES users(id) ON DELETE CASCADE,
+    created_at DATETIME not null,
+    PRIMARY KEY (issuer, subject)
+);
+
+create index oidc_identities_user_id_idx on oidc_identities (user_id);
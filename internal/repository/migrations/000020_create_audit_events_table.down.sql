@@ -0,0 +1,5 @@
+drop trigger audit_events_no_delete;
+
+drop trigger audit_events_no_update;
+
+drop table audit_events;
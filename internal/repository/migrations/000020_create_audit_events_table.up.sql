@@ -0,0 +1,23 @@
+create table audit_events (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    created_at DATETIME not null,
+    actor_id INTEGER,
+    actor text not null,
+    action text not null,
+    target text not null,
+    ip text not null,
+    before text,
+    after text
+);
+
+create index audit_events_created_at_idx on audit_events (created_at);
+
+create trigger audit_events_no_update before update on audit_events
+begin
+    select raise(abort, 'audit events are append-only');
+end;
+
+create trigger audit_events_no_delete before delete on audit_events
+begin
+    select raise(abort, 'audit events are append-only');
+end;
@@ -0,0 +1,4 @@
+create table settings (
+    key text PRIMARY KEY,
+    value text not null
+);
@@ -0,0 +1,23 @@
+-- Foreign keys were not enforced before, so rows may still point to deleted
+-- ones. Clean them up as their ON DELETE actions would have.
+UPDATE posts
+SET cover_image = NULL
+WHERE cover_image NOT IN (SELECT name FROM media);
+
+UPDATE posts
+SET author_id = NULL
+WHERE author_id NOT IN (SELECT id FROM users);
+
+DELETE FROM tags_posts
+WHERE post_id NOT IN (SELECT id FROM posts)
+   OR tag_id NOT IN (SELECT id FROM tags);
+
+DELETE FROM post_links
+WHERE source_post_id NOT IN (SELECT id FROM posts);
+
+UPDATE post_links
+SET target_post_id = NULL
+WHERE target_post_id NOT IN (SELECT id FROM posts);
+
+DELETE FROM link_checks
+WHERE post_id NOT IN (SELECT id FROM posts);
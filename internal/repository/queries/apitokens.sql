@@ -0,0 +1,29 @@
+-- name: CreateAPIToken :one
+insert into api_tokens (user_id, name, token_hash, scopes, created_at)
+values (:user_id, :name, :token_hash, :scopes, :created_at)
+returning *
+;
+
+-- name: GetAPITokenByHash :one
+select *
+from api_tokens
+where token_hash = :token_hash
+;
+
+-- name: GetUserAPITokens :many
+select *
+from api_tokens
+where user_id = :user_id
+order by created_at desc
+;
+
+-- name: TouchAPIToken :exec
+update api_tokens
+set last_used_at = :last_used_at
+where id = :id
+;
+
+-- name: DeleteAPIToken :execrows
+delete from api_tokens
+where id = :id and user_id = :user_id
+;
@@ -0,0 +1,17 @@
+-- name: CreateAuditEvent :exec
+insert into audit_events (created_at, actor_id, actor, action, target, ip, before, after)
+values (:created_at, :actor_id, :actor, :action, :target, :ip, :before, :after)
+;
+
+-- name: GetAuditEvents :many
+select *
+from audit_events
+where
+    (cast(sqlc.narg('actor') as text) is null or actor = sqlc.narg('actor'))
+    and (cast(sqlc.narg('action') as text) is null or action like sqlc.narg('action') || '%')
+    and (cast(sqlc.narg('target') as text) is null or target like '%' || sqlc.narg('target') || '%' collate nocase)
+    and (cast(sqlc.narg('since') as DATETIME) is null or created_at >= sqlc.narg('since'))
+    and (cast(sqlc.narg('until') as DATETIME) is null or created_at < sqlc.narg('until'))
+order by created_at desc, id desc
+limit sqlc.arg('limit')
+;
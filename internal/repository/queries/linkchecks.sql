@@ -0,0 +1,26 @@
+-- name: AddLinkCheck :exec
+insert into link_checks (post_id, url, status, status_code, message, checked_at)
+values (:post_id, :url, :status, :status_code, :message, :checked_at)
+on conflict (post_id, url) do update
+set status = excluded.status, status_code = excluded.status_code, message = excluded.message, checked_at = excluded.checked_at
+;
+
+-- name: ClearLinkChecks :exec
+delete from link_checks
+where post_id =:post_id
+;
+
+-- name: GetLinkChecks :many
+select
+    lc.id,
+    lc.url,
+    lc.status,
+    lc.status_code,
+    lc.message,
+    lc.checked_at,
+    p.title as post_title,
+    p.slug as post_slug
+from link_checks lc
+join posts p on p.id = lc.post_id
+order by case lc.status when 'ok' then 2 when 'skipped' then 1 else 0 end, p.title, lc.url
+;
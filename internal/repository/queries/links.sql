@@ -0,0 +1,28 @@
+-- name: AddPostLink :exec
+insert into post_links (source_post_id, target_slug, target_post_id, created_at)
+values (:source_post_id, :target_slug, :target_post_id, :created_at)
+on conflict (source_post_id, target_slug) do nothing
+;
+
+-- name: ClearPostLinks :exec
+delete from post_links
+where source_post_id =:source_post_id
+;
+
+-- name: GetBacklinks :many
+select p.title, p.slug
+from post_links l
+join posts p on p.id = l.source_post_id
+where l.target_post_id =:target_post_id and l.source_post_id != l.target_post_id
+order by p.created_at desc
+;
+
+-- name: GetPostsLinkingTo :many
+select p.*
+from posts p
+where p.id in (
+    select l.source_post_id
+    from post_links l
+    where l.target_slug =:target_slug
+)
+;
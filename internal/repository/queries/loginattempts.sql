@@ -0,0 +1,33 @@
+-- name: GetLoginAttempt :one
+select *
+from login_attempts
+where key = :key
+;
+
+-- name: AddLoginAttempt :one
+insert into login_attempts (key, failures, last_failure_at)
+values (:key, 1, :now)
+on conflict (key) do update
+set failures = case when login_attempts.last_failure_at < :reset_before then 1 else login_attempts.failures + 1 end,
+    locked_until = case when login_attempts.last_failure_at < :reset_before then null else login_attempts.locked_until end,
+    last_failure_at = excluded.last_failure_at
+returning *
+;
+
+-- name: RemoveLoginAttempt :exec
+update login_attempts
+set failures = max(failures - 1, 0)
+where key = :key
+;
+
+-- name: LockLoginAttempt :execrows
+update login_attempts
+set locked_until = :locked_until
+where key = :key
+    and (locked_until is null or locked_until <= :now)
+;
+
+-- name: DeleteLoginAttempt :exec
+delete from login_attempts
+where key = :key
+;
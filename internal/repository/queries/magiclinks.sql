@@ -0,0 +1,16 @@
+-- name: CreateMagicLink :exec
+insert into magic_links (token_hash, user_id, created_at, expires_at)
+values (:token_hash, :user_id, :created_at, :expires_at)
+;
+
+-- name: UseMagicLink :one
+update magic_links
+set used_at = :used_at
+where token_hash = :token_hash and used_at is null and expires_at > :now
+returning user_id
+;
+
+-- name: DeleteExpiredMagicLinks :exec
+delete from magic_links
+where expires_at <= :now
+;
@@ -0,0 +1,38 @@
+-- name: CreateMedia :one
+insert into media (name, original_name, mime_type, size, width, height, created_at)
+values (:name, :original_name, :mime_type, :size, :width, :height, :created_at)
+returning *
+;
+
+-- name: GetMediaByName :one
+select *
+from media
+where name =:name
+;
+
+-- name: GetMediaByID :one
+select *
+from media
+where id =:id
+;
+
+-- name: SearchMedia :many
+select *
+from media
+where
+    cast(sqlc.narg('search') as text) is null
+    or original_name like '%' || sqlc.narg('search') || '%' collate nocase
+order by created_at desc
+;
+
+-- name: DeleteMedia :exec
+delete from media
+where id =:id
+;
+
+-- name: GetPostsReferencingMedia :many
+select title, slug
+from posts
+where content like '%' || sqlc.arg('name') || '%' or cover_image = sqlc.arg('name')
+order by title
+;
@@ -0,0 +1,10 @@
+-- name: GetOIDCIdentity :one
+select user_id
+from oidc_identities
+where issuer = :issuer and subject = :subject
+;
+
+-- name: CreateOIDCIdentity :exec
+insert into oidc_identities (issuer, subject, user_id, created_at)
+values (:issuer, :subject, :user_id, :created_at)
+;
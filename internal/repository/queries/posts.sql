@@ -5,8 +5,8 @@ order by created_at desc
 ;
 
 -- name: CreatePost :one
-insert into posts (title, toc, content, parsed_content, description, slug, created_at, modified_at, readtime)
-values (:title, :toc, :content, :parsed_content, :description, :slug, :created_at, :modified_at, :readtime)
+insert into posts (title, toc, content, parsed_content, description, slug, created_at, modified_at, readtime, words, excerpt, cover_image, cover_alt, cover_caption, author_id)
+values (:title, :toc, :content, :parsed_content, :description, :slug, :created_at, :modified_at, :readtime, :words, :excerpt, :cover_image, :cover_alt, :cover_caption, :author_id)
 returning *
 ;
 
@@ -23,7 +23,7 @@ where slug =:slug
 
 -- name: UpdatePostBySlug :one
 update posts
-set title = :title, toc = :toc, slug = :new_slug, content = :content, parsed_content = :parsed_content, modified_at = :modified_at, description = :description, readtime = :readtime
+set title = :title, toc = :toc, slug = :new_slug, content = :content, parsed_content = :parsed_content, modified_at = :modified_at, description = :description, readtime = :readtime, words = :words, excerpt = :excerpt, cover_image = :cover_image, cover_alt = :cover_alt, cover_caption = :cover_caption
 where slug = :slug
 returning *
 ;
@@ -37,7 +37,14 @@ select
     p.parsed_content,
     p.slug,
     p.description,
+    p.excerpt,
     p.readtime,
+    p.words,
+    p.cover_image,
+    p.cover_alt,
+    p.cover_caption,
+    p.author_id,
+    u.username as author_name,
     p.created_at,
     p.modified_at,
     t.id as tag_id,
@@ -45,6 +52,7 @@ select
     t.created_at as tag_created_at,
     t.modified_at as tag_modified_at
 from posts p
+left join users u on p.author_id = u.id
 left join tags_posts tp on p.id = tp.post_id
 left join tags t on tp.tag_id = t.id
 where
@@ -58,3 +66,26 @@ where
 order by p.created_at desc, p.id, t.id
 ;
 
+-- name: UpdatePostContent :exec
+update posts
+set content = :content, toc = :toc, parsed_content = :parsed_content, excerpt = :excerpt
+where id = :id
+;
+
+-- name: UpdatePostReadtime :exec
+update posts
+set readtime = :readtime, words = :words
+where id = :id
+;
+
+-- name: UpdatePostExcerpt :exec
+update posts
+set excerpt = :excerpt
+where id = :id
+;
+
+-- name: AssignPostsWithoutAuthor :exec
+update posts
+set author_id = :author_id
+where author_id is null
+;
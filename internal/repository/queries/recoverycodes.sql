@@ -0,0 +1,15 @@
+-- name: CreateRecoveryCode :exec
+insert into recovery_codes (user_id, code_hash, created_at)
+values (:user_id, :code_hash, :created_at)
+;
+
+-- name: DeleteRecoveryCodes :exec
+delete from recovery_codes
+where user_id = :user_id
+;
+
+-- name: UseRecoveryCode :execrows
+update recovery_codes
+set used_at = :used_at
+where user_id = :user_id and code_hash = :code_hash and used_at is null
+;
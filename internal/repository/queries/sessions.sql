@@ -0,0 +1,51 @@
+-- name: CreateSession :exec
+insert into sessions (id, user_id, created_at, last_seen_at, expires_at, ip, user_agent, remember)
+values (:id, :user_id, :created_at, :last_seen_at, :expires_at, :ip, :user_agent, :remember)
+;
+
+-- name: GetSession :one
+select *
+from sessions
+where id = :id
+;
+
+-- name: TouchSession :exec
+update sessions
+set last_seen_at = :last_seen_at
+where id = :id
+;
+
+-- name: RenewSession :exec
+update sessions
+set expires_at = :expires_at
+where id = :id
+;
+
+-- name: GetActiveSessions :many
+select s.*, u.username
+from sessions s
+join users u on u.id = s.user_id
+where s.expires_at > :now
+order by s.last_seen_at desc
+;
+
+-- name: DeleteSession :exec
+delete from sessions
+where id = :id
+;
+
+-- name: DeleteUserSessions :exec
+delete from sessions
+where user_id = :user_id
+;
+
+-- name: DeleteUserSessionsExcept :exec
+delete from sessions
+where user_id = :user_id
+  and id != :id
+;
+
+-- name: DeleteExpiredSessions :exec
+delete from sessions
+where expires_at <= :now
+;
@@ -0,0 +1,12 @@
+-- name: GetSetting :one
+select value
+from settings
+where key = :key
+;
+
+-- name: SetSetting :exec
+insert into settings (key, value)
+values (:key, :value)
+on conflict (key) do update
+set value = excluded.value
+;
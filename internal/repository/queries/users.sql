@@ -0,0 +1,67 @@
+-- name: CreateUser :one
+insert into users (username, password_hash, role, email, created_at, modified_at)
+values (:username, :password_hash, :role, :email, :created_at, :modified_at)
+returning *
+;
+
+-- name: GetUserByUsername :one
+select *
+from users
+where username =:username
+;
+
+-- name: GetUserByID :one
+select *
+from users
+where id =:id
+;
+
+-- name: GetUserByEmail :one
+select *
+from users
+where email = :email
+;
+
+-- name: CountUsers :one
+select count(*)
+from users
+;
+
+-- name: GetUsers :many
+select *
+from users
+order by username
+;
+
+-- name: UpdateUserRole :exec
+update users
+set role = :role, modified_at = :modified_at
+where id = :id
+;
+
+-- name: GetUserTOTP :one
+select totp_secret, totp_enabled, totp_last_counter
+from users
+where id = :id
+;
+
+-- name: UpdateUserTOTP :exec
+update users
+set totp_secret = :totp_secret,
+    totp_enabled = :totp_enabled,
+    totp_last_counter = :totp_last_counter,
+    modified_at = :modified_at
+where id = :id
+;
+
+-- name: UpdateUserEmail :exec
+update users
+set email = :email, modified_at = :modified_at
+where id = :id
+;
+
+-- name: UpdateUserPassword :exec
+update users
+set password_hash = :password_hash, modified_at = :modified_at
+where id = :id
+;
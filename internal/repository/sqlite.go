@@ -0,0 +1,21 @@
+package repository
+
+import (
+	"database/sql"
+	"strings"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// OpenSQLite opens the SQLite database at path, which may carry driver
+// options like "blog.db?_busy_timeout=5000". Foreign keys are enforced on
+// every connection, since SQLite leaves them off and the ON DELETE actions of
+// the schema would never run.
+func OpenSQLite(path string) (*sql.DB, error) {
+	separator := "?"
+	if strings.Contains(path, "?") {
+		separator = "&"
+	}
+
+	return sql.Open("sqlite3", path+separator+"_foreign_keys=on")
+}
@@ -0,0 +1,227 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database/sqlite3"
+
+	_ "github.com/golang-migrate/migrate/v4/source/file"
+)
+
+// newTestDB opens a database in a temporary directory and returns it with its
+// migrations, which are not applied yet.
+func newTestDB(t *testing.T) (*sql.DB, *migrate.Migrate) {
+	t.Helper()
+
+	db, err := OpenSQLite(filepath.Join(t.TempDir(), "blog.db"))
+	if err != nil {
+		t.Fatalf("OpenSQLite() error = %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	driver, err := sqlite3.WithInstance(db, &sqlite3.Config{DatabaseName: "blog.db"})
+	if err != nil {
+		t.Fatalf("sqlite3.WithInstance() error = %v", err)
+	}
+
+	m, err := migrate.NewWithDatabaseInstance("file://migrations", "sqlite3", driver)
+	if err != nil {
+		t.Fatalf("migrate.NewWithDatabaseInstance() error = %v", err)
+	}
+
+	return db, m
+}
+
+// testExec runs a statement, failing the test on errors.
+func testExec(t *testing.T, db *sql.DB, query string, args ...any) {
+	t.Helper()
+
+	if _, err := db.Exec(query, args...); err != nil {
+		t.Fatalf("Exec(%q) error = %v", query, err)
+	}
+}
+
+func TestOpenSQLite_ForeignKeys(t *testing.T) {
+	db, m := newTestDB(t)
+
+	if err := m.Up(); err != nil {
+		t.Fatalf("Up() error = %v", err)
+	}
+
+	exec := func(query string, args ...any) {
+		t.Helper()
+		testExec(t, db, query, args...)
+	}
+
+	exec(`insert into media (name, original_name, mime_type, size) values ('cover.png', 'cover.png', 'image/png', 1)`)
+	exec(`insert into posts (title, content, toc, parsed_content, slug, cover_image) values ('Post', '', '', '', 'post', 'cover.png')`)
+	exec(`insert into link_checks (post_id, url, status, checked_at) values ((select id from posts), 'https://example.com', 'ok', current_timestamp)`)
+
+	exec(`delete from media where name = 'cover.png'`)
+
+	var cover sql.NullString
+	if err := db.QueryRow(`select cover_image from posts where slug = 'post'`).Scan(&cover); err != nil {
+		t.Fatalf("Scan() error = %v", err)
+	}
+	if cover.Valid {
+		t.Errorf("deleting the media kept the cover image %q", cover.String)
+	}
+
+	exec(`delete from posts where slug = 'post'`)
+
+	var checks int
+	if err := db.QueryRow(`select count(*) from link_checks`).Scan(&checks); err != nil {
+		t.Fatalf("Scan() error = %v", err)
+	}
+	if checks != 0 {
+		t.Errorf("deleting the post kept %d link checks", checks)
+	}
+
+	if _, err := db.Exec(`insert into sessions (id, user_id, created_at, expires_at, last_seen_at, ip, user_agent) values ('s', 42, current_timestamp, current_timestamp, current_timestamp, '', '')`); err == nil {
+		t.Errorf("inserted a session of a user that does not exist")
+	}
+}
+
+func TestAssignPostsWithoutAuthor_Upgrade(t *testing.T) {
+	ctx := context.Background()
+	db, m := newTestDB(t)
+
+	// A blog from before accounts existed, with its posts
+	if err := m.Migrate(10); err != nil {
+		t.Fatalf("Migrate() error = %v", err)
+	}
+	testExec(t, db, `insert into posts (title, content, toc, parsed_content, slug) values ('Old post', '', '', '', 'old-post')`)
+
+	if err := m.Up(); err != nil {
+		t.Fatalf("Up() error = %v", err)
+	}
+
+	var author sql.NullInt64
+	if err := db.QueryRow(`select author_id from posts where slug = 'old-post'`).Scan(&author); err != nil {
+		t.Fatalf("Scan() error = %v", err)
+	}
+	if author.Valid {
+		t.Fatalf("the migrations made user %d the author before any user existed", author.Int64)
+	}
+
+	// The initial admin is created after the migrations
+	testExec(t, db, `insert into users (username, password_hash, role, created_at) values ('admin', 'hash', 'admin', current_timestamp)`)
+
+	var adminID int64
+	if err := db.QueryRow(`select id from users where username = 'admin'`).Scan(&adminID); err != nil {
+		t.Fatalf("Scan() error = %v", err)
+	}
+
+	if err := New(db).AssignPostsWithoutAuthor(ctx, sql.NullInt64{Int64: adminID, Valid: true}); err != nil {
+		t.Fatalf("AssignPostsWithoutAuthor() error = %v", err)
+	}
+
+	if err := db.QueryRow(`select author_id from posts where slug = 'old-post'`).Scan(&author); err != nil {
+		t.Fatalf("Scan() error = %v", err)
+	}
+	if !author.Valid || author.Int64 != adminID {
+		t.Errorf("author of the old post = %v, want the admin %d", author, adminID)
+	}
+}
+
+func TestMigrateDown_KeepsPosts(t *testing.T) {
+	db, m := newTestDB(t)
+
+	if err := m.Up(); err != nil {
+		t.Fatalf("Up() error = %v", err)
+	}
+
+	exec := func(query string, args ...any) {
+		t.Helper()
+		testExec(t, db, query, args...)
+	}
+
+	exec(`insert into users (username, password_hash, role, created_at) values ('admin', 'hash', 'admin', current_timestamp)`)
+	exec(`insert into media (name, original_name, mime_type, size) values ('cover.png', 'cover.png', 'image/png', 1)`)
+	exec(`insert into posts (title, content, toc, parsed_content, slug) values ('Deleted', '', '', '', 'deleted')`)
+	exec(`delete from posts where slug = 'deleted'`)
+	exec(`insert into posts (title, content, toc, parsed_content, slug, cover_image, author_id) values ('First', '', '', '', 'first', 'cover.png', (select id from users))`)
+	exec(`insert into posts (title, content, toc, parsed_content, slug) values ('Second', '', '', '', 'second')`)
+	exec(`insert into tags (name) values ('go')`)
+	exec(`insert into tags_posts (tag_id, post_id) select tags.id, posts.id from tags, posts`)
+	exec(`insert into post_links (source_post_id, target_slug, target_post_id) values ((select id from posts where slug = 'first'), 'second', (select id from posts where slug = 'second'))`)
+	exec(`insert into link_checks (post_id, url, status, checked_at) values ((select id from posts where slug = 'first'), 'https://example.com', 'ok', current_timestamp)`)
+
+	// count returns the number of rows of a query
+	count := func(query string) int {
+		t.Helper()
+
+		var n int
+		if err := db.QueryRow(query).Scan(&n); err != nil {
+			t.Fatalf("QueryRow(%q) error = %v", query, err)
+		}
+		return n
+	}
+
+	// Id of the last post created, deleted ones included
+	lastID := 3
+
+	// checkPosts fails the test if the rollback lost posts or the rows that
+	// reference them.
+	checkPosts := func() {
+		t.Helper()
+
+		wantRows := map[string]int{
+			`select count(*) from posts`:                                       2,
+			`select count(*) from tags_posts`:                                  2,
+			`select count(*) from post_links where target_post_id is not null`: 1,
+			`select count(*) from link_checks`:                                 1,
+		}
+		for query, want := range wantRows {
+			if got := count(query); got != want {
+				t.Errorf("%s = %d, want %d", query, got, want)
+			}
+		}
+
+		// The ids of deleted posts are still not used again
+		lastID++
+		exec(`insert into posts (title, content, toc, parsed_content, slug) values ('New', '', '', '', 'new')`)
+		if got := count(`select id from posts where slug = 'new'`); got != lastID {
+			t.Errorf("id of a new post = %d, want %d", got, lastID)
+		}
+		exec(`delete from posts where slug = 'new'`)
+
+		if got := count(`select count(*) from pragma_foreign_key_check`); got != 0 {
+			t.Errorf("%d rows have a broken foreign key", got)
+		}
+	}
+
+	// hasColumn reports if a table still has a column
+	hasColumn := func(table, column string) bool {
+		t.Helper()
+		return count(`select count(*) from pragma_table_info('`+table+`') where name = '`+column+`'`) > 0
+	}
+
+	if err := m.Migrate(11); err != nil {
+		t.Fatalf("Migrate(11) error = %v", err)
+	}
+	checkPosts()
+	if hasColumn("posts", "author_id") || hasColumn("users", "role") {
+		t.Errorf("the rollback of the roles kept their columns")
+	}
+	if got := count(`select count(*) from posts where cover_image = 'cover.png'`); got != 1 {
+		t.Errorf("the rollback of the roles lost the cover image")
+	}
+
+	if err := m.Migrate(9); err != nil {
+		t.Fatalf("Migrate(9) error = %v", err)
+	}
+	checkPosts()
+	for _, column := range []string{"cover_image", "cover_alt", "cover_caption"} {
+		if hasColumn("posts", column) {
+			t.Errorf("the rollback of the cover images kept %s", column)
+		}
+	}
+	if got := count(`select count(*) from media`); got != 1 {
+		t.Errorf("the rollback of the cover images lost the media")
+	}
+}
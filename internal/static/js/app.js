@@ -0,0 +1,15 @@
+// Scripts of every page. The Content-Security-Policy only runs the script
+// files of the blog, so the pages have no inline handlers and mark the
+// elements these scripts act on with data attributes.
+
+// Dark mode of the <html> element, remembered in the local storage. The
+// script runs in the <head>, so the page is drawn in the right mode.
+document.documentElement.classList.toggle('dark', localStorage.getItem('dark') === 'true');
+
+// Listening on the document also covers the headers htmx swaps in
+document.addEventListener('click', (event) => {
+    if (!event.target.closest('[data-theme-toggle]')) return;
+
+    const dark = document.documentElement.classList.toggle('dark');
+    localStorage.setItem('dark', dark);
+});
@@ -0,0 +1,249 @@
+// Scripts of the editor. Like app.js, they find their elements by data
+// attributes, and the values from the server come in data attributes too. The
+// editor page loads this script after the form, so its elements exist.
+
+// Tags of the post, with suggestions of the existing ones. data-tags has the
+// tags as a JSON array, and the hidden tags input gets them comma separated.
+function tagSelector(root) {
+    const input = root.querySelector('[data-tag-input]');
+    const suggestionList = root.querySelector('[data-tag-suggestions]');
+    const tagList = root.querySelector('[data-tag-list]');
+    const value = root.querySelector('input[name="tags"]');
+
+    const selectedTags = JSON.parse(root.dataset.tags || '[]');
+    let search;
+
+    const render = () => {
+        tagList.replaceChildren(...selectedTags.map((tag, index) => {
+            const item = document.createElement('div');
+            item.className = 'flex items-center rounded bg-blue-500 px-2 py-1 text-white';
+
+            const name = document.createElement('span');
+            name.textContent = tag;
+
+            const remove = document.createElement('button');
+            remove.type = 'button';
+            remove.className = 'ml-1 font-bold hover:text-gray-300';
+            remove.setAttribute('aria-label', 'Remover tag');
+            remove.textContent = '×';
+            remove.addEventListener('click', () => {
+                selectedTags.splice(index, 1);
+                render();
+            });
+
+            item.append(name, remove);
+            return item;
+        }));
+
+        value.value = selectedTags.join(',');
+    };
+
+    const showSuggestions = (suggestions) => {
+        suggestionList.replaceChildren(...suggestions.map((tag) => {
+            const item = document.createElement('li');
+            item.className = 'cursor-pointer px-3 py-1 hover:bg-gray-200 dark:hover:bg-gray-700';
+            item.textContent = tag;
+            item.addEventListener('click', () => addTag(tag));
+            return item;
+        }));
+
+        suggestionList.hidden = suggestions.length === 0 || input.value.length === 0;
+    };
+
+    const addTag = (tag) => {
+        tag = tag.trim();
+        if (tag.length === 0) return;
+        if (!selectedTags.includes(tag)) {
+            selectedTags.push(tag);
+        }
+        input.value = '';
+        showSuggestions([]);
+        render();
+    };
+
+    const searchTags = () => {
+        const query = input.value;
+        if (query.length === 0) {
+            showSuggestions([]);
+            return;
+        }
+        fetch(`/tags/search/${encodeURIComponent(query)}`)
+            .then((res) => {
+                if (!res.ok) throw new Error('Network response was not ok');
+                return res.json();
+            })
+            .then((data) => {
+                // The input changed while searching
+                if (input.value !== query) return;
+                showSuggestions(data.filter((tag) => !selectedTags.includes(tag)));
+            })
+            .catch(() => showSuggestions([]));
+    };
+
+    input.addEventListener('input', () => {
+        clearTimeout(search);
+        search = setTimeout(searchTags, 300);
+    });
+
+    input.addEventListener('keydown', (event) => {
+        if (event.key !== 'Enter') return;
+        event.preventDefault();
+        addTag(input.value);
+    });
+
+    render();
+}
+
+// Cover image of the post, picked from the media library. The hidden
+// cover_image input has the name of the current one, and the buttons of the
+// library have theirs in data-name.
+function coverPicker(root) {
+    const value = root.querySelector('input[name="cover_image"]');
+    const preview = root.querySelector('[data-cover-preview]');
+    const library = root.querySelector('[data-cover-library]');
+    const shownWithCover = root.querySelectorAll('[data-cover-remove], [data-cover-fields]');
+
+    const render = () => {
+        const cover = value.value;
+        preview.hidden = cover === '';
+        if (cover !== '') {
+            preview.src = '/media/' + cover;
+        }
+        shownWithCover.forEach((element) => {
+            element.hidden = cover === '';
+        });
+    };
+
+    const choose = (name) => {
+        value.value = name;
+        library.hidden = true;
+        render();
+        // Update the preview like typing does
+        htmx.trigger(root.closest('form'), 'keyup');
+    };
+
+    root.querySelector('[data-cover-toggle]').addEventListener('click', () => {
+        library.hidden = !library.hidden;
+    });
+
+    root.querySelector('[data-cover-remove]').addEventListener('click', () => choose(''));
+
+    library.querySelectorAll('[data-name]').forEach((button) => {
+        button.addEventListener('click', () => choose(button.dataset.name));
+    });
+
+    render();
+}
+
+// Uploads images pasted or dropped into the content and inserts them as
+// Markdown at the cursor. data-max-size has the largest upload in bytes and
+// data-accepted-types the comma separated MIME types.
+function imageUploader(root) {
+    const content = root.querySelector('textarea');
+    const uploads = root.querySelector('[data-uploads]');
+    const error = root.querySelector('[data-upload-error]');
+
+    const maxSize = Number(root.dataset.maxSize);
+    const acceptedTypes = root.dataset.acceptedTypes.split(',');
+    const dragClasses = ['border-dashed', 'border-blue-500', 'dark:border-blue-500'];
+
+    const showError = (message) => {
+        error.textContent = message;
+        error.hidden = message === '';
+    };
+
+    // Updates the preview like typing does
+    const refresh = () => htmx.trigger(content.form, 'keyup');
+
+    const insert = (text) => {
+        content.setRangeText(text, content.selectionStart, content.selectionEnd, 'end');
+        refresh();
+    };
+
+    const replace = (placeholder, text) => {
+        const start = content.value.indexOf(placeholder);
+        if (start === -1) return;
+        content.setRangeText(text, start, start + placeholder.length, 'preserve');
+        refresh();
+    };
+
+    const upload = (file) => {
+        showError('');
+
+        if (!acceptedTypes.includes(file.type)) {
+            showError(`${file.name}: file type ${file.type || 'unknown'} is not allowed`);
+            return;
+        }
+        if (file.size > maxSize) {
+            showError(`${file.name}: file must be smaller than ${Math.floor(maxSize / 1048576)} MB`);
+            return;
+        }
+
+        const placeholder = `![Uploading ${file.name}…]()`;
+        insert(placeholder);
+
+        const row = document.createElement('div');
+        row.className = 'mt-1 flex w-full flex-row items-center gap-2 text-sm';
+        const name = document.createElement('span');
+        name.className = 'truncate';
+        name.textContent = file.name;
+        const progress = document.createElement('progress');
+        progress.className = 'w-full';
+        progress.max = 100;
+        progress.value = 0;
+        row.append(name, progress);
+        uploads.append(row);
+
+        const data = new FormData();
+        data.append('file', file);
+
+        const xhr = new XMLHttpRequest();
+        xhr.open('POST', '/media/upload');
+        const headers = JSON.parse(root.closest('[hx-headers]').getAttribute('hx-headers'));
+        Object.entries(headers).forEach(([header, value]) => xhr.setRequestHeader(header, value));
+        xhr.upload.onprogress = (event) => {
+            if (event.lengthComputable) {
+                progress.value = Math.round((event.loaded * 100) / event.total);
+            }
+        };
+        xhr.onload = () => {
+            row.remove();
+            if (xhr.status === 201) {
+                replace(placeholder, JSON.parse(xhr.responseText).markdown);
+            } else {
+                replace(placeholder, '');
+                showError(`${file.name}: ${xhr.responseText.trim() || xhr.statusText}`);
+            }
+        };
+        xhr.onerror = () => {
+            row.remove();
+            replace(placeholder, '');
+            showError(`${file.name}: upload failed`);
+        };
+        xhr.send(data);
+    };
+
+    content.addEventListener('paste', (event) => {
+        const files = [...event.clipboardData.files];
+        if (files.length === 0) return;
+        event.preventDefault();
+        files.forEach(upload);
+    });
+
+    content.addEventListener('dragover', (event) => {
+        event.preventDefault();
+        content.classList.add(...dragClasses);
+    });
+
+    content.addEventListener('dragleave', () => content.classList.remove(...dragClasses));
+
+    content.addEventListener('drop', (event) => {
+        event.preventDefault();
+        content.classList.remove(...dragClasses);
+        [...event.dataTransfer.files].forEach(upload);
+    });
+}
+
+document.querySelectorAll('[data-tag-selector]').forEach(tagSelector);
+document.querySelectorAll('[data-cover-picker]').forEach(coverPicker);
+document.querySelectorAll('[data-image-uploader]').forEach(imageUploader);
@@ -1,17 +1,27 @@
 package blogo
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"net/netip"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
 	"time"
 
 	"github.com/luizgustavojunqueira/Blogo/internal/auth"
 	"github.com/luizgustavojunqueira/Blogo/internal/handlers"
+	"github.com/luizgustavojunqueira/Blogo/internal/linkcheck"
+	"github.com/luizgustavojunqueira/Blogo/internal/markdown"
+	"github.com/luizgustavojunqueira/Blogo/internal/media"
 	"github.com/luizgustavojunqueira/Blogo/internal/repository"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/text"
 )
 
 type User struct {
@@ -28,17 +38,51 @@ type BlogoConfig struct {
 	Logger     *log.Logger
 	Location   *time.Location
 	Queries    *repository.Queries
+
+	WordsPerMinute int    // Reading speed used to estimate read times, defaults to 200
+	CodeLightTheme string // Chroma style for code blocks in light mode, defaults to "github"
+	CodeDarkTheme  string // Chroma style for code blocks in dark mode, defaults to "dracula"
+
+	MediaStorage  media.Storage // Where uploads are stored, defaults to a local directory
+	MediaDir      string        // Directory of the default local storage, defaults to "media"
+	MaxUploadSize int64         // Maximum size of an upload in bytes, defaults to 10 MB
+
+	ImageWidths   []int  // Widths of the resized image variants, defaults to 480, 960 and 1440
+	ImageCacheDir string // Directory the image variants are cached in, defaults to "cache/images"
+
+	TrustedProxies []string // IP addresses or CIDR ranges of the reverse proxies whose X-Forwarded-For header is trusted
+
+	BaseURL string      // Public URL of the blog, like "https://blog.example.com", required for login links
+	Mailer  auth.Mailer // Sends the login links, logging in by email is disabled without it
+
+	OIDC *auth.OIDCConfig // OpenID Connect provider to log in with, disabled when nil. Auth and Identities are filled in
+
+	Security handlers.SecurityConfig // Content-Security-Policy and other security headers, see handlers.SecurityHeaders
 }
 
 type Blogo struct {
-	blogName string
-	title    string
-	port     string
-	db       *sql.DB
-	auth     *auth.Auth
-	logger   *log.Logger
-	location *time.Location
-	queries  *repository.Queries
+	blogName   string
+	title      string
+	port       string
+	db         *sql.DB
+	auth       *auth.Auth
+	limiter    *auth.Limiter
+	twoFactor  *auth.TwoFactor
+	magicLinks *auth.MagicLinks
+	oidc       *auth.OIDC
+	logger     *log.Logger
+	location   *time.Location
+	queries    *repository.Queries
+	readTime   markdown.ReadTimeEstimator
+	codeCSS    []byte
+
+	mediaStorage  media.Storage
+	maxUploadSize int64
+	imageVariants *media.Variants
+
+	trustedProxies []netip.Prefix
+	security       handlers.SecurityConfig
+	baseURL        string
 }
 
 type PostHandler interface {
@@ -56,8 +100,66 @@ type TagHandler interface {
 	SearchTag(w http.ResponseWriter, r *http.Request)
 }
 
+type LinkCheckHandler interface {
+	Report(w http.ResponseWriter, r *http.Request)
+	Check(w http.ResponseWriter, r *http.Request)
+}
+
+type MediaHandler interface {
+	Upload(w http.ResponseWriter, r *http.Request)
+	Serve(w http.ResponseWriter, r *http.Request)
+	Library(w http.ResponseWriter, r *http.Request)
+	Delete(w http.ResponseWriter, r *http.Request)
+}
+
+type ImageHandler interface {
+	ServeVariant(w http.ResponseWriter, r *http.Request)
+}
+
+type UsersHandler interface {
+	List(w http.ResponseWriter, r *http.Request)
+	Create(w http.ResponseWriter, r *http.Request)
+	UpdateRole(w http.ResponseWriter, r *http.Request)
+	UpdateEmail(w http.ResponseWriter, r *http.Request)
+}
+
+type PasswordHandler interface {
+	Settings(w http.ResponseWriter, r *http.Request)
+	Change(w http.ResponseWriter, r *http.Request)
+	ResetLink(w http.ResponseWriter, r *http.Request)
+	Reset(w http.ResponseWriter, r *http.Request)
+}
+
+type SessionsHandler interface {
+	List(w http.ResponseWriter, r *http.Request)
+	Revoke(w http.ResponseWriter, r *http.Request)
+	RevokeUser(w http.ResponseWriter, r *http.Request)
+}
+
+type APITokensHandler interface {
+	List(w http.ResponseWriter, r *http.Request)
+	Create(w http.ResponseWriter, r *http.Request)
+	Delete(w http.ResponseWriter, r *http.Request)
+}
+
+type AuditHandler interface {
+	List(w http.ResponseWriter, r *http.Request)
+	Export(w http.ResponseWriter, r *http.Request)
+}
+
+type TwoFactorHandler interface {
+	Settings(w http.ResponseWriter, r *http.Request)
+	Confirm(w http.ResponseWriter, r *http.Request)
+	Disable(w http.ResponseWriter, r *http.Request)
+}
+
 type AuthHandler interface {
 	Login(w http.ResponseWriter, r *http.Request)
+	LoginCode(w http.ResponseWriter, r *http.Request)
+	LoginEmail(w http.ResponseWriter, r *http.Request)
+	LoginLink(w http.ResponseWriter, r *http.Request)
+	LoginOIDC(w http.ResponseWriter, r *http.Request)
+	OIDCCallback(w http.ResponseWriter, r *http.Request)
 	Logout(w http.ResponseWriter, r *http.Request)
 }
 
@@ -73,11 +175,6 @@ func NewBlogo(config *BlogoConfig) (*Blogo, error) {
 		return nil, errors.New("auth configuration is required")
 	}
 
-	auth, err := auth.NewAuth(*config.AuthConfig)
-	if err != nil {
-		return nil, err
-	}
-
 	if config.BlogName == "" {
 		return nil, errors.New("a Blog name is required")
 	}
@@ -105,54 +202,398 @@ func NewBlogo(config *BlogoConfig) (*Blogo, error) {
 		return nil, errors.New("queries not provided")
 	}
 
+	if config.AuthConfig.Users == nil {
+		config.AuthConfig.Users = handlers.NewAuthUsers(config.Queries, config.Location)
+	}
+
+	if config.AuthConfig.Sessions == nil {
+		config.AuthConfig.Sessions = handlers.NewSessionStore(config.Queries, config.Location)
+	}
+
+	if config.AuthConfig.APITokens == nil {
+		config.AuthConfig.APITokens = handlers.NewAPITokenStore(config.Queries, config.Location)
+	}
+
+	limiter, err := auth.NewLimiter(auth.LimiterConfig{
+		Store: handlers.NewAttemptStore(config.Queries),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	twoFactor, err := auth.NewTwoFactor(auth.TwoFactorConfig{
+		Store:  handlers.NewTwoFactorStore(config.Queries, config.Location),
+		Issuer: config.BlogName,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	auth, err := auth.NewAuth(*config.AuthConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	created, err := auth.CreateInitialAdmin(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	if created {
+		config.Logger.Printf("Created the admin user %s\n", config.AuthConfig.Username)
+
+		if err := assignPostsToAdmin(config); err != nil {
+			return nil, err
+		}
+	}
+
+	magicLinks, err := newMagicLinks(config, auth)
+	if err != nil {
+		return nil, err
+	}
+
+	oidc, err := newOIDC(config, auth)
+	if err != nil {
+		return nil, err
+	}
+
+	trustedProxies, err := handlers.ParseTrustedProxies(config.TrustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("invalid trusted proxy: %w", err)
+	}
+
+	if config.CodeLightTheme == "" {
+		config.CodeLightTheme = markdown.DefaultLightTheme
+	}
+
+	if config.CodeDarkTheme == "" {
+		config.CodeDarkTheme = markdown.DefaultDarkTheme
+	}
+
+	codeCSS, err := markdown.HighlightCSS(config.CodeLightTheme, config.CodeDarkTheme)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.MediaStorage == nil {
+		if config.MediaDir == "" {
+			config.MediaDir = "media"
+		}
+
+		config.MediaStorage, err = media.NewLocal(config.MediaDir)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if config.MaxUploadSize <= 0 {
+		config.MaxUploadSize = 10 << 20
+	}
+
+	if config.ImageCacheDir == "" {
+		config.ImageCacheDir = "cache/images"
+	}
+
+	imageVariants, err := media.NewVariants(media.VariantsConfig{
+		Static:   os.DirFS("internal/static"),
+		Storage:  config.MediaStorage,
+		CacheDir: config.ImageCacheDir,
+		Widths:   config.ImageWidths,
+	})
+	if err != nil {
+		return nil, err
+	}
+
 	blog := &Blogo{
-		blogName: config.BlogName,
-		title:    config.Title,
-		port:     config.Port,
-		db:       config.DB,
-		auth:     auth,
-		logger:   config.Logger,
-		location: config.Location,
-		queries:  config.Queries,
+		blogName:   config.BlogName,
+		title:      config.Title,
+		port:       config.Port,
+		db:         config.DB,
+		auth:       auth,
+		limiter:    limiter,
+		twoFactor:  twoFactor,
+		magicLinks: magicLinks,
+		oidc:       oidc,
+		logger:     config.Logger,
+		location:   config.Location,
+		queries:    config.Queries,
+		readTime:   markdown.ReadTimeEstimator{WordsPerMinute: config.WordsPerMinute},
+		codeCSS:    codeCSS,
+
+		mediaStorage:  config.MediaStorage,
+		maxUploadSize: config.MaxUploadSize,
+		imageVariants: imageVariants,
+
+		trustedProxies: trustedProxies,
+		security:       config.Security,
+		baseURL:        config.BaseURL,
 	}
 
 	return blog, nil
 }
 
-// Start starts the blog server and listens for incoming requests.
+// assignPostsToAdmin makes the initial admin the author of the posts without
+// one. On upgrades from before accounts existed, the migration adding authors
+// runs while there are no users yet, so it leaves every post without one.
+func assignPostsToAdmin(config *BlogoConfig) error {
+	ctx := context.Background()
+
+	admin, err := config.Queries.GetUserByUsername(ctx, config.AuthConfig.Username)
+	if err != nil {
+		return err
+	}
+
+	return config.Queries.AssignPostsWithoutAuthor(ctx, sql.NullInt64{Int64: admin.ID, Valid: true})
+}
+
+// newMagicLinks returns the login links sent by the mailer of config, or nil
+// when there is no mailer.
+func newMagicLinks(config *BlogoConfig, authenticator *auth.Auth) (*auth.MagicLinks, error) {
+	if config.Mailer == nil {
+		return nil, nil
+	}
+
+	if config.BaseURL == "" {
+		return nil, errors.New("a base URL is required to email login links")
+	}
+
+	return auth.NewMagicLinks(auth.MagicLinkConfig{
+		Auth:     authenticator,
+		Store:    handlers.NewMagicLinkStore(config.Queries, config.Location),
+		Mailer:   config.Mailer,
+		Attempts: handlers.NewAttemptStore(config.Queries),
+		BaseURL:  config.BaseURL,
+		SiteName: config.BlogName,
+		Logger:   config.Logger,
+	})
+}
+
+// newOIDC returns the OpenID Connect login of config, or nil when it has none.
+func newOIDC(config *BlogoConfig, authenticator *auth.Auth) (*auth.OIDC, error) {
+	if config.OIDC == nil {
+		return nil, nil
+	}
+
+	config.OIDC.Auth = authenticator
+
+	if config.OIDC.Identities == nil {
+		config.OIDC.Identities = handlers.NewOIDCIdentityStore(config.Queries, config.Location)
+	}
+
+	return auth.NewOIDC(*config.OIDC)
+}
+
+// Start starts the blog server and listens for incoming requests until the
+// process is interrupted or terminated, then shuts down gracefully.
 func (blogo *Blogo) Start() error {
-	// var postHandler PostHandler = handlers.NewPostHandler(blogo.queries, blogo.queries, blogo.location, blogo.logger, blogo.auth, blogo.blogName, blogo.title)
+	if err := blogo.rerenderOutdatedPosts(context.Background()); err != nil {
+		return err
+	}
+
+	auditor := handlers.NewAuditor(blogo.queries, blogo.location, blogo.trustedProxies, blogo.logger)
+
+	// var postHandler PostHandler = handlers.NewPostHandler(blogo.queries, blogo.queries, blogo.location, blogo.logger, blogo.blogName, blogo.title)
+
+	var postHandler PostHandler = handlers.NewPostHandler(blogo.queries, blogo.queries, blogo.queries, blogo.queries, blogo.queries, blogo.readTime, blogo.imageVariants, blogo.maxUploadSize, blogo.location, auditor, blogo.logger, blogo.blogName, blogo.title)
 
-	var postHandler PostHandler = handlers.NewPostHandler(blogo.queries, blogo.queries, blogo.location, blogo.logger, blogo.auth, blogo.blogName, blogo.title)
+	var authHandler AuthHandler = handlers.NewAuthHandler(blogo.auth, blogo.twoFactor, blogo.limiter, blogo.magicLinks, blogo.oidc, blogo.trustedProxies, auditor, blogo.logger, blogo.blogName, blogo.title)
 
-	var authHandler AuthHandler = handlers.NewAuthHandler(blogo.auth, blogo.logger, blogo.blogName, blogo.title)
+	var usersHandler UsersHandler = handlers.NewUsersHandler(blogo.queries, blogo.location, auditor, blogo.logger, blogo.blogName, blogo.title)
+
+	var passwordHandler PasswordHandler = handlers.NewPasswordHandler(blogo.auth, blogo.queries, auditor, blogo.baseURL, blogo.logger, blogo.blogName, blogo.title)
+
+	var sessionsHandler SessionsHandler = handlers.NewSessionsHandler(blogo.auth, blogo.queries, auditor, blogo.logger, blogo.blogName, blogo.title)
+
+	var apiTokensHandler APITokensHandler = handlers.NewAPITokensHandler(blogo.auth, auditor, blogo.logger, blogo.blogName, blogo.title)
+
+	var twoFactorHandler TwoFactorHandler = handlers.NewTwoFactorHandler(blogo.twoFactor, auditor, blogo.logger, blogo.blogName, blogo.title)
+
+	var auditHandler AuditHandler = handlers.NewAuditHandler(blogo.queries, blogo.location, blogo.logger, blogo.blogName, blogo.title)
 
 	var tagHandler TagHandler = handlers.NewTagsHandler(blogo.queries, blogo.logger)
 
-	http.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("internal/static"))))
+	checker, err := linkcheck.New(linkcheck.Config{
+		Site:       handlers.NewLinkCheckSite(blogo.queries, blogo.queries, blogo.queries),
+		Static:     os.DirFS("internal/static"),
+		KnownPaths: []string{"/editor", "/tags", "/login", "/login/email", "/login/oidc", "/admin/links", "/admin/media", "/admin/users", "/admin/sessions", "/admin/audit", "/account/2fa", "/account/password", "/account/tokens"},
+	})
+	if err != nil {
+		return err
+	}
+
+	var mediaHandler MediaHandler = handlers.NewMediaHandler(blogo.queries, blogo.mediaStorage, blogo.imageVariants, blogo.maxUploadSize, blogo.location, auditor, blogo.logger, blogo.blogName, blogo.title)
 
-	http.HandleFunc("/", postHandler.GetPosts)
-	http.HandleFunc("/editor", postHandler.Editor)
-	http.HandleFunc("/editor/{slug}", postHandler.Editor)
-	http.HandleFunc("/post/new", postHandler.CreatePost)
-	http.HandleFunc("/post/parse", postHandler.ParseMarkdown)
-	http.HandleFunc("/post/{slug}", postHandler.ViewPost)
-	http.HandleFunc("/post/delete/{slug}", postHandler.DeletePost)
-	http.HandleFunc("/post/edit/{slug}", postHandler.EditPost)
-	http.HandleFunc("/{tag}", postHandler.GetPosts)
+	var imageHandler ImageHandler = handlers.NewImageHandler(blogo.imageVariants, blogo.logger)
 
-	http.HandleFunc("/tags", tagHandler.GetTags)
-	http.HandleFunc("/tags/search/{tag}", tagHandler.SearchTag)
+	var linkCheckHandler LinkCheckHandler = handlers.NewLinkCheckHandler(blogo.queries, checker, blogo.location, blogo.logger, blogo.blogName, blogo.title)
 
-	http.HandleFunc("/login", authHandler.Login)
-	http.HandleFunc("/logout", authHandler.Logout)
+	mux := newRouter(blogo.auth, blogo.logger, routes{
+		posts:     postHandler,
+		tags:      tagHandler,
+		media:     mediaHandler,
+		images:    imageHandler,
+		linkCheck: linkCheckHandler,
+		twoFactor: twoFactorHandler,
+		password:  passwordHandler,
+		apiTokens: apiTokensHandler,
+		users:     usersHandler,
+		audit:     auditHandler,
+		sessions:  sessionsHandler,
+		auth:      authHandler,
+		codeCSS:   blogo.serveCodeCSS,
+	})
 
 	blogo.logger.Printf("Starting server on port %s\n", blogo.port)
 
-	err := http.ListenAndServe(":"+blogo.port, nil)
-	if err != nil {
+	handler := handlers.CSRF(blogo.auth, blogo.logger, handlers.RenewSessions(blogo.auth, blogo.logger, mux))
+
+	server := &http.Server{
+		Addr:    ":" + blogo.port,
+		Handler: handlers.SecurityHeaders(blogo.security, handler),
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
 		blogo.logger.Printf("Error starting server: %v\n", err)
 		return err
+	case <-ctx.Done():
+	}
+
+	blogo.logger.Println("Shutting down the server")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		blogo.logger.Printf("Error shutting down the server: %v\n", err)
+		return err
+	}
+
+	// The requests are done, but the login links they asked for may still be
+	// on their way
+	if blogo.magicLinks != nil {
+		blogo.magicLinks.Wait()
+	}
+
+	return nil
+}
+
+// shutdownTimeout is how long the requests in progress have to finish once
+// the server is asked to stop.
+const shutdownTimeout = 10 * time.Second
+
+func (blogo *Blogo) serveCodeCSS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/css; charset=utf-8")
+	w.Write(blogo.codeCSS)
+}
+
+// RerenderPosts renders every post again, for example after changing the
+// highlighting output.
+func (blogo *Blogo) RerenderPosts(ctx context.Context) error {
+	postHandler := handlers.NewPostHandler(blogo.queries, blogo.queries, blogo.queries, blogo.queries, blogo.queries, blogo.readTime, blogo.imageVariants, blogo.maxUploadSize, blogo.location, nil, blogo.logger, blogo.blogName, blogo.title)
+
+	if err := postHandler.RerenderPosts(ctx); err != nil {
+		return err
+	}
+
+	return blogo.queries.SetSetting(ctx, repository.SetSettingParams{
+		Key:   renderVersionSetting,
+		Value: strconv.Itoa(markdown.Version),
+	})
+}
+
+// renderVersionSetting stores the markdown.Version the posts were last
+// rendered with.
+const renderVersionSetting = "render_version"
+
+// rerenderOutdatedPosts renders every post again once the Markdown output
+// changed since they were saved, so upgrading is enough to get the new HTML.
+func (blogo *Blogo) rerenderOutdatedPosts(ctx context.Context) error {
+	version, err := blogo.queries.GetSetting(ctx, renderVersionSetting)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	if version == strconv.Itoa(markdown.Version) {
+		return nil
+	}
+
+	blogo.logger.Printf("Rendering the posts again for version %d of the Markdown output\n", markdown.Version)
+
+	return blogo.RerenderPosts(ctx)
+}
+
+// RecomputeReadTimes estimates the reading time and word count of every post
+// again, for posts saved before the estimator or its settings changed.
+func (blogo *Blogo) RecomputeReadTimes(ctx context.Context) error {
+	return blogo.forEachPost(ctx, func(post repository.Post, doc ast.Node, src []byte) error {
+		readTime := blogo.readTime.Estimate(doc, src)
+
+		blogo.logger.Printf("Post %s: %d words, %d min\n", post.Slug, readTime.Words, readTime.Minutes)
+
+		return blogo.queries.UpdatePostReadtime(ctx, repository.UpdatePostReadtimeParams{
+			Readtime: sql.NullInt64{Int64: int64(readTime.Minutes), Valid: true},
+			Words:    sql.NullInt64{Int64: int64(readTime.Words), Valid: true},
+			ID:       post.ID,
+		})
+	})
+}
+
+// RegenerateExcerpts generates the excerpt of every post again.
+func (blogo *Blogo) RegenerateExcerpts(ctx context.Context) error {
+	return blogo.forEachPost(ctx, func(post repository.Post, doc ast.Node, src []byte) error {
+		excerpt := markdown.Excerpt(doc, src, markdown.DefaultExcerptLength)
+
+		blogo.logger.Printf("Post %s: %q\n", post.Slug, excerpt)
+
+		return blogo.queries.UpdatePostExcerpt(ctx, repository.UpdatePostExcerptParams{
+			Excerpt: sql.NullString{String: excerpt, Valid: excerpt != ""},
+			ID:      post.ID,
+		})
+	})
+}
+
+// SetPassword sets the password of the user with username and signs them out
+// everywhere, to recover an account from the command line.
+func (blogo *Blogo) SetPassword(ctx context.Context, username, password string) error {
+	user, err := handlers.NewAuthUsers(blogo.queries, blogo.location).GetUserByUsername(ctx, username)
+	if err != nil {
+		return fmt.Errorf("user %q: %w", username, err)
+	}
+
+	if err := blogo.auth.SetPassword(ctx, user, password); err != nil {
+		return err
+	}
+
+	blogo.logger.Printf("Set the password of %s and ended their sessions\n", username)
+
+	return nil
+}
+
+// forEachPost parses the content of every post and calls fn with the result.
+func (blogo *Blogo) forEachPost(ctx context.Context, fn func(post repository.Post, doc ast.Node, src []byte) error) error {
+	md := markdown.New()
+
+	posts, err := blogo.queries.GetPosts(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, post := range posts {
+		src := []byte(post.Content)
+		doc := md.Parser().Parse(text.NewReader(src))
+
+		if err := fn(post, doc, src); err != nil {
+			return err
+		}
 	}
 
 	return nil
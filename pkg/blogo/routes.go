@@ -0,0 +1,105 @@
+package blogo
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/luizgustavojunqueira/Blogo/internal/handlers"
+)
+
+// routes are the handlers newRouter sends the requests to.
+type routes struct {
+	posts     PostHandler
+	tags      TagHandler
+	media     MediaHandler
+	images    ImageHandler
+	linkCheck LinkCheckHandler
+	twoFactor TwoFactorHandler
+	password  PasswordHandler
+	apiTokens APITokensHandler
+	users     UsersHandler
+	audit     AuditHandler
+	sessions  SessionsHandler
+	auth      AuthHandler
+	codeCSS   http.HandlerFunc
+}
+
+// newRouter registers the routes of the blog with their methods, so other
+// methods get 405 Method Not Allowed, behind the middleware that
+// authenticates their user.
+func newRouter(authenticator handlers.Auth, logger *log.Logger, h routes) *http.ServeMux {
+	// Routes only writers may use are behind user, and the account and admin
+	// pages, which API tokens cannot reach, behind session
+	withUser := func(h http.HandlerFunc) http.Handler { return handlers.LoadUser(authenticator, logger, h) }
+	user := func(h http.HandlerFunc) http.Handler { return handlers.RequireUser(authenticator, logger, h) }
+	session := func(h http.HandlerFunc) http.Handler { return handlers.RequireSession(authenticator, logger, h) }
+
+	mux := http.NewServeMux()
+
+	mux.Handle("GET /static/", http.StripPrefix("/static/", http.FileServer(http.Dir("internal/static"))))
+	mux.HandleFunc("GET /static/chroma.css", h.codeCSS)
+
+	mux.Handle("GET /{$}", withUser(h.posts.GetPosts))
+	mux.Handle("GET /{tag}", withUser(h.posts.GetPosts))
+	mux.Handle("GET /editor", user(h.posts.Editor))
+	mux.Handle("GET /editor/{slug}", user(h.posts.Editor))
+	mux.Handle("POST /post/new", user(h.posts.CreatePost))
+	mux.Handle("POST /post/parse", user(h.posts.ParseMarkdown))
+	mux.Handle("GET /post/{slug}", withUser(h.posts.ViewPost))
+	mux.Handle("POST /post/edit/{slug}", user(h.posts.EditPost))
+	mux.Handle("DELETE /post/{slug}", user(h.posts.DeletePost))
+
+	mux.HandleFunc("GET /tags", h.tags.GetTags)
+	mux.HandleFunc("GET /tags/search/{tag}", h.tags.SearchTag)
+
+	mux.Handle("POST /media/upload", user(h.media.Upload))
+	mux.HandleFunc("GET /media/{name}", h.media.Serve)
+	mux.Handle("DELETE /media/{id}", user(h.media.Delete))
+	mux.Handle("GET /admin/media", session(h.media.Library))
+
+	mux.HandleFunc("GET /images/{width}/{src...}", h.images.ServeVariant)
+
+	mux.Handle("GET /admin/links", session(h.linkCheck.Report))
+	mux.Handle("POST /admin/links/check", session(h.linkCheck.Check))
+
+	mux.Handle("GET /account/2fa", session(h.twoFactor.Settings))
+	mux.Handle("POST /account/2fa/confirm", session(h.twoFactor.Confirm))
+	mux.Handle("POST /account/2fa/disable", session(h.twoFactor.Disable))
+
+	mux.Handle("GET /account/password", session(h.password.Settings))
+	mux.Handle("POST /account/password", session(h.password.Change))
+
+	mux.Handle("GET /account/tokens", session(h.apiTokens.List))
+	mux.Handle("POST /account/tokens/new", session(h.apiTokens.Create))
+	mux.Handle("DELETE /account/tokens/{id}", session(h.apiTokens.Delete))
+
+	mux.Handle("GET /admin/users", session(h.users.List))
+	mux.Handle("POST /admin/users/new", session(h.users.Create))
+	mux.Handle("POST /admin/users/{id}/role", session(h.users.UpdateRole))
+	mux.Handle("POST /admin/users/{id}/email", session(h.users.UpdateEmail))
+	mux.Handle("POST /admin/users/{id}/password/reset", session(h.password.ResetLink))
+	mux.Handle("POST /admin/users/{id}/sessions/revoke", session(h.sessions.RevokeUser))
+
+	mux.Handle("GET /admin/audit", session(h.audit.List))
+	mux.Handle("GET /admin/audit/export", session(h.audit.Export))
+
+	mux.Handle("GET /admin/sessions", session(h.sessions.List))
+	mux.Handle("POST /admin/sessions/{id}/revoke", session(h.sessions.Revoke))
+
+	mux.HandleFunc("GET /login", h.auth.Login)
+	mux.HandleFunc("POST /login", h.auth.Login)
+	mux.HandleFunc("GET /login/2fa", h.auth.LoginCode)
+	mux.HandleFunc("POST /login/2fa", h.auth.LoginCode)
+	mux.HandleFunc("GET /login/email", h.auth.LoginEmail)
+	mux.HandleFunc("POST /login/email", h.auth.LoginEmail)
+	mux.HandleFunc("GET /login/email/{token}", h.auth.LoginLink)
+	mux.HandleFunc("POST /login/email/{token}", h.auth.LoginLink)
+	mux.HandleFunc("GET /login/oidc", h.auth.LoginOIDC)
+	mux.HandleFunc("GET /login/oidc/callback", h.auth.OIDCCallback)
+	mux.HandleFunc("POST /logout", h.auth.Logout)
+
+	mux.HandleFunc("GET /password/reset/{token}", h.password.Reset)
+	mux.HandleFunc("POST /password/reset/{token}", h.password.Reset)
+
+	return mux
+}
@@ -0,0 +1,160 @@
+package blogo
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/luizgustavojunqueira/Blogo/internal/auth"
+)
+
+// handlersMock answers every route with the name of its handler.
+type handlersMock struct{}
+
+func (handlersMock) serve(w http.ResponseWriter, name string) { io.WriteString(w, name) }
+
+func (m handlersMock) GetPosts(w http.ResponseWriter, r *http.Request)   { m.serve(w, "GetPosts") }
+func (m handlersMock) CreatePost(w http.ResponseWriter, r *http.Request) { m.serve(w, "CreatePost") }
+func (m handlersMock) ParseMarkdown(w http.ResponseWriter, r *http.Request) {
+	m.serve(w, "ParseMarkdown")
+}
+func (m handlersMock) Editor(w http.ResponseWriter, r *http.Request)     { m.serve(w, "Editor") }
+func (m handlersMock) ViewPost(w http.ResponseWriter, r *http.Request)   { m.serve(w, "ViewPost") }
+func (m handlersMock) DeletePost(w http.ResponseWriter, r *http.Request) { m.serve(w, "DeletePost") }
+func (m handlersMock) EditPost(w http.ResponseWriter, r *http.Request)   { m.serve(w, "EditPost") }
+func (m handlersMock) GetTags(w http.ResponseWriter, r *http.Request)    { m.serve(w, "GetTags") }
+func (m handlersMock) SearchTag(w http.ResponseWriter, r *http.Request)  { m.serve(w, "SearchTag") }
+func (m handlersMock) Report(w http.ResponseWriter, r *http.Request)     { m.serve(w, "Report") }
+func (m handlersMock) Check(w http.ResponseWriter, r *http.Request)      { m.serve(w, "Check") }
+func (m handlersMock) Upload(w http.ResponseWriter, r *http.Request)     { m.serve(w, "Upload") }
+func (m handlersMock) Serve(w http.ResponseWriter, r *http.Request)      { m.serve(w, "Serve") }
+func (m handlersMock) Library(w http.ResponseWriter, r *http.Request)    { m.serve(w, "Library") }
+func (m handlersMock) Delete(w http.ResponseWriter, r *http.Request)     { m.serve(w, "Delete") }
+func (m handlersMock) ServeVariant(w http.ResponseWriter, r *http.Request) {
+	m.serve(w, "ServeVariant")
+}
+func (m handlersMock) List(w http.ResponseWriter, r *http.Request)        { m.serve(w, "List") }
+func (m handlersMock) Create(w http.ResponseWriter, r *http.Request)      { m.serve(w, "Create") }
+func (m handlersMock) UpdateRole(w http.ResponseWriter, r *http.Request)  { m.serve(w, "UpdateRole") }
+func (m handlersMock) UpdateEmail(w http.ResponseWriter, r *http.Request) { m.serve(w, "UpdateEmail") }
+func (m handlersMock) Settings(w http.ResponseWriter, r *http.Request)    { m.serve(w, "Settings") }
+func (m handlersMock) Change(w http.ResponseWriter, r *http.Request)      { m.serve(w, "Change") }
+func (m handlersMock) ResetLink(w http.ResponseWriter, r *http.Request)   { m.serve(w, "ResetLink") }
+func (m handlersMock) Reset(w http.ResponseWriter, r *http.Request)       { m.serve(w, "Reset") }
+func (m handlersMock) Revoke(w http.ResponseWriter, r *http.Request)      { m.serve(w, "Revoke") }
+func (m handlersMock) RevokeUser(w http.ResponseWriter, r *http.Request)  { m.serve(w, "RevokeUser") }
+func (m handlersMock) Export(w http.ResponseWriter, r *http.Request)      { m.serve(w, "Export") }
+func (m handlersMock) Confirm(w http.ResponseWriter, r *http.Request)     { m.serve(w, "Confirm") }
+func (m handlersMock) Disable(w http.ResponseWriter, r *http.Request)     { m.serve(w, "Disable") }
+func (m handlersMock) Login(w http.ResponseWriter, r *http.Request)       { m.serve(w, "Login") }
+func (m handlersMock) LoginCode(w http.ResponseWriter, r *http.Request)   { m.serve(w, "LoginCode") }
+func (m handlersMock) LoginEmail(w http.ResponseWriter, r *http.Request)  { m.serve(w, "LoginEmail") }
+func (m handlersMock) LoginLink(w http.ResponseWriter, r *http.Request)   { m.serve(w, "LoginLink") }
+func (m handlersMock) LoginOIDC(w http.ResponseWriter, r *http.Request)   { m.serve(w, "LoginOIDC") }
+func (m handlersMock) OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	m.serve(w, "OIDCCallback")
+}
+func (m handlersMock) Logout(w http.ResponseWriter, r *http.Request) { m.serve(w, "Logout") }
+
+// authMock signs in "writer" with the cookie "session=writer" and with the
+// API token "token", which has every scope.
+type authMock struct{}
+
+func (authMock) UserFromRequest(r *http.Request) (auth.User, error) {
+	if token, ok := auth.BearerToken(r); ok {
+		if token != "token" {
+			return auth.User{}, auth.ErrAPITokenNotFound
+		}
+		return auth.User{ID: 1, Username: "writer", Role: auth.RoleAdmin, Scopes: []string{auth.ScopeRead, auth.ScopeWrite, auth.ScopeDelete}}, nil
+	}
+
+	if cookie, err := r.Cookie("session"); err == nil && cookie.Value == "writer" {
+		return auth.User{ID: 1, Username: "writer", Role: auth.RoleAdmin}, nil
+	}
+	return auth.User{}, auth.ErrSessionNotFound
+}
+
+func TestNewRouter(t *testing.T) {
+	h := handlersMock{}
+	router := newRouter(authMock{}, log.New(io.Discard, "", 0), routes{
+		posts:     h,
+		tags:      h,
+		media:     h,
+		images:    h,
+		linkCheck: h,
+		twoFactor: h,
+		password:  h,
+		apiTokens: h,
+		users:     h,
+		audit:     h,
+		sessions:  h,
+		auth:      h,
+		codeCSS:   func(w http.ResponseWriter, r *http.Request) { io.WriteString(w, "codeCSS") },
+	})
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		cookie   string
+		token    string
+		wantCode int
+		wantBody string // Handler that served the request
+	}{
+		{name: "Home", method: http.MethodGet, path: "/", wantCode: http.StatusOK, wantBody: "GetPosts"},
+		{name: "Tag", method: http.MethodGet, path: "/go", wantCode: http.StatusOK, wantBody: "GetPosts"},
+		{name: "Post", method: http.MethodGet, path: "/post/hello", wantCode: http.StatusOK, wantBody: "ViewPost"},
+		{name: "Code styles", method: http.MethodGet, path: "/static/chroma.css", wantCode: http.StatusOK, wantBody: "codeCSS"},
+		{name: "Delete a post", method: http.MethodDelete, path: "/post/hello", cookie: "writer", wantCode: http.StatusOK, wantBody: "DeletePost"},
+		{name: "Delete with GET", method: http.MethodGet, path: "/post/delete/hello", cookie: "writer", wantCode: http.StatusNotFound},
+		{name: "Wrong method on a post", method: http.MethodPut, path: "/post/hello", cookie: "writer", wantCode: http.StatusMethodNotAllowed},
+		{name: "Wrong method on an admin page", method: http.MethodDelete, path: "/admin/users", cookie: "writer", wantCode: http.StatusMethodNotAllowed},
+		{name: "Wrong method on the home page", method: http.MethodPost, path: "/", wantCode: http.StatusMethodNotAllowed},
+		{name: "Logout", method: http.MethodPost, path: "/logout", cookie: "writer", wantCode: http.StatusOK, wantBody: "Logout"},
+		{name: "Logout with GET is a tag", method: http.MethodGet, path: "/logout", cookie: "writer", wantCode: http.StatusOK, wantBody: "GetPosts"},
+		{name: "Anonymous editor", method: http.MethodGet, path: "/editor", wantCode: http.StatusFound},
+		{name: "Wrong token on the editor", method: http.MethodPost, path: "/post/new", token: "wrong", wantCode: http.StatusUnauthorized},
+		{name: "Token creates a post", method: http.MethodPost, path: "/post/new", token: "token", wantCode: http.StatusOK, wantBody: "CreatePost"},
+		{name: "Token on an admin page", method: http.MethodGet, path: "/admin/users", token: "token", wantCode: http.StatusUnauthorized},
+		{name: "Token on an account page", method: http.MethodPost, path: "/account/tokens/new", token: "token", wantCode: http.StatusUnauthorized},
+		{name: "Session on an admin page", method: http.MethodGet, path: "/admin/users", cookie: "writer", wantCode: http.StatusOK, wantBody: "List"},
+		{name: "Anonymous admin page", method: http.MethodGet, path: "/admin/audit", wantCode: http.StatusFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.cookie != "" {
+				r.AddCookie(&http.Cookie{Name: "session", Value: tt.cookie})
+			}
+			if tt.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+
+			if w.Code != tt.wantCode {
+				t.Fatalf("%s %s status = %d, want %d", tt.method, tt.path, w.Code, tt.wantCode)
+			}
+
+			switch tt.wantCode {
+			case http.StatusOK:
+				if got := w.Body.String(); got != tt.wantBody {
+					t.Errorf("%s %s served by %s, want %s", tt.method, tt.path, got, tt.wantBody)
+				}
+			case http.StatusFound:
+				if location := w.Header().Get("Location"); location != "/" {
+					t.Errorf("redirected to %q, want /", location)
+				}
+			case http.StatusMethodNotAllowed:
+				if allow := w.Header().Get("Allow"); allow == "" || strings.Contains(allow, tt.method) {
+					t.Errorf("Allow = %q, want the other methods", allow)
+				}
+			}
+		})
+	}
+}